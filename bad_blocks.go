@@ -0,0 +1,156 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+// BadBlock is a block rejected by the chain, persisted for later inspection.
+type BadBlock struct {
+	Block    *types.Block
+	Receipts types.Receipts // Receipts of the processed block, nil if processing failed
+	Error    string         // Reason the block was rejected
+	Time     uint64         // Unix time the block was rejected
+}
+
+// badBlockRLP is the storage encoding of a bad block.
+type badBlockRLP struct {
+	Block    *types.Block
+	Receipts []*types.ReceiptForStorage
+	Error    string
+	Time     uint64
+}
+
+// EncodeRLP implements rlp.Encoder, storing the receipts with their logs.
+func (bad *BadBlock) EncodeRLP(w io.Writer) error {
+	enc := &badBlockRLP{Block: bad.Block, Error: bad.Error, Time: bad.Time}
+	for _, receipt := range bad.Receipts {
+		enc.Receipts = append(enc.Receipts, (*types.ReceiptForStorage)(receipt))
+	}
+	return rlp.Encode(w, enc)
+}
+
+// DecodeRLP implements rlp.Decoder.
+func (bad *BadBlock) DecodeRLP(s *rlp.Stream) error {
+	var dec badBlockRLP
+	if err := s.Decode(&dec); err != nil {
+		return err
+	}
+	bad.Block, bad.Error, bad.Time, bad.Receipts = dec.Block, dec.Error, dec.Time, nil
+	for _, receipt := range dec.Receipts {
+		bad.Receipts = append(bad.Receipts, (*types.Receipt)(receipt))
+	}
+	return nil
+}
+
+// BadBlock retrieves the persisted bad block with the given hash.
+func (bc *BlockChain) BadBlock(hash common.Hash) *BadBlock {
+	return GetBadBlock(bc.chainDb, hash)
+}
+
+// addBadBlock persists a bad block along with its receipts and the error it
+// was rejected with.
+func (bc *BlockChain) addBadBlock(block *types.Block, receipts types.Receipts, err error) {
+	bad := &BadBlock{Block: block, Receipts: receipts, Error: err.Error(), Time: uint64(time.Now().Unix())}
+	if err := WriteBadBlock(bc.chainDb, bad); err != nil {
+		log.Error("Failed to persist bad block", "number", block.Number(), "hash", block.Hash(), "err", err)
+	}
+}
+
+// BadBlockReplay is the result of re-executing a bad block on the state of
+// its parent.
+type BadBlockReplay struct {
+	Hash         common.Hash  `json:"hash"`
+	Number       uint64       `json:"number"`
+	Error        string       `json:"error"`       // Reason the block was rejected
+	ReplayError  string       `json:"replayError"` // Reason the replay failed, empty if the block is valid now
+	Transactions []*TxReplay  `json:"transactions"`
+	Diff         *BlockDiff   `json:"diff"` // Changes made to the parent state, up to the failure
+	Root         *common.Hash `json:"root"` // State root after the block, nil if a transaction failed
+}
+
+// TxReplay is the re-execution of a transaction of a bad block.
+type TxReplay struct {
+	Hash    common.Hash    `json:"hash"`
+	Receipt *types.Receipt `json:"receipt"`
+	Trace   []vm.StructLog `json:"trace"`
+	Error   string         `json:"error,omitempty"`
+}
+
+// ReplayBadBlock re-executes the persisted bad block with the given hash on
+// the state of its parent, tracing every transaction with a structured EVM
+// logger configured by logConfig, and diffing the state against the parent.
+// Execution stops at the first failing transaction. The state of the parent
+// must be available.
+func (bc *BlockChain) ReplayBadBlock(hash common.Hash, logConfig *vm.LogConfig) (*BadBlockReplay, error) {
+	bad := bc.BadBlock(hash)
+	if bad == nil {
+		return nil, ErrUnknownBlock
+	}
+	block := bad.Block
+	parent := bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, fmt.Errorf("parent of bad block #%d [%x…] unknown", block.NumberU64(), hash[:4])
+	}
+	statedb, err := state.New(parent.Root(), bc.stateDatabase())
+	if err != nil {
+		return nil, ErrStateUnavailable
+	}
+	replay := &BadBlockReplay{
+		Hash:   hash,
+		Number: block.NumberU64(),
+		Error:  bad.Error,
+		Diff:   &BlockDiff{Hash: hash, Number: block.NumberU64(), Diff: state.NewStateDiff()},
+	}
+	var (
+		header   = block.Header()
+		gp       = new(GasPool).AddGas(block.GasLimit())
+		usedGas  = big.NewInt(0)
+		receipts types.Receipts
+	)
+	for i, tx := range block.Transactions() {
+		tracer := vm.NewStructLogger(logConfig)
+		statedb.Prepare(tx.Hash(), hash, i)
+		statedb.StartDiff()
+		receipt, _, err := ApplyTransaction(bc.config, bc, nil, gp, statedb, header, tx, usedGas, vm.Config{Debug: true, Tracer: tracer})
+		txDiff := statedb.StopDiff()
+
+		replay.Diff.Transactions = append(replay.Diff.Transactions, txDiff)
+		replay.Diff.Diff.Merge(txDiff)
+		replay.Transactions = append(replay.Transactions, &TxReplay{Hash: tx.Hash(), Receipt: receipt, Trace: tracer.StructLogs()})
+		if err != nil {
+			replay.Transactions[i].Error = err.Error()
+			replay.ReplayError = fmt.Sprintf("transaction %d [%x…]: %v", i, tx.Hash().Bytes()[:4], err)
+			break
+		}
+		receipts = append(receipts, receipt)
+	}
+	if replay.ReplayError == "" {
+		statedb.StartDiff()
+		bc.engine.Finalize(bc, header, statedb, block.Transactions(), block.Uncles(), receipts)
+		replay.Diff.Finalize = statedb.StopDiff()
+		replay.Diff.Diff.Merge(replay.Diff.Finalize)
+
+		root := statedb.IntermediateRoot(true)
+		replay.Root = &root
+		if err := bc.Validator().ValidateState(block, parent, statedb, receipts, usedGas); err != nil {
+			replay.ReplayError = err.Error()
+		}
+	}
+	if err := statedb.Error(); err != nil {
+		// Partially pruned state
+		return nil, ErrStateUnavailable
+	}
+	return replay, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+// Tests that rejected blocks are persisted across restarts and can be
+// replayed with traces and a state diff.
+func TestBadBlockReplay(t *testing.T) {
+	// The deferred stop covers the restarted chain as well as a failure
+	// before the restart
+	c, err := newTestChain(big.NewInt(1000000000))
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer c.stop()
+
+	// Deploy a counter, then count and transfer in a block with a wrong root
+	counter := crypto.CreateAddress(c.addr, 0)
+	chain := c.generate(nil, 2, func(i int, gen *BlockGen) {
+		if i == 0 {
+			c.send(gen, types.NewContractCreation(gen.TxNonce(c.addr), new(big.Int), big.NewInt(100000), new(big.Int), counterCode))
+			return
+		}
+		c.send(gen, types.NewTransaction(gen.TxNonce(c.addr), counter, new(big.Int), big.NewInt(100000), new(big.Int), nil))
+		c.transfer(gen, common.Address{0x01})
+	})
+	if _, err := c.blockchain.InsertChain(chain[:1]); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	header := chain[1].Header()
+	header.Root = common.Hash{0x01}
+	bad := types.NewBlockWithHeader(header).WithBody(chain[1].Transactions(), chain[1].Uncles())
+	if _, err := c.blockchain.InsertChain(types.Blocks{bad}); err == nil {
+		t.Fatalf("bad block accepted")
+	}
+	// Restart and check the bad block survived
+	c.stop()
+	if err := c.open(); err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	blockchain := c.blockchain
+
+	if list, _ := blockchain.BadBlocks(); len(list) != 1 || list[0].Hash != bad.Hash() {
+		t.Fatalf("bad block list mismatch: have %v, want [%x]", list, bad.Hash())
+	}
+	record := blockchain.BadBlock(bad.Hash())
+	if record == nil {
+		t.Fatalf("bad block missing")
+	}
+	if !strings.Contains(record.Error, "invalid merkle root") {
+		t.Errorf("bad block error mismatch: have %q", record.Error)
+	}
+	if len(record.Receipts) != 2 || len(record.Receipts[0].Logs) != 1 {
+		t.Errorf("bad block receipts mismatch: have %v", record.Receipts)
+	}
+	// Replay it, finding the right root
+	replay, err := blockchain.ReplayBadBlock(bad.Hash(), &vm.LogConfig{DisableMemory: true})
+	if err != nil {
+		t.Fatalf("failed to replay bad block: %v", err)
+	}
+	if !strings.Contains(replay.ReplayError, "invalid merkle root") {
+		t.Errorf("replay error mismatch: have %q", replay.ReplayError)
+	}
+	if replay.Root == nil || *replay.Root != chain[1].Root() {
+		t.Errorf("replay root mismatch: have %v, want %x", replay.Root, chain[1].Root())
+	}
+	if len(replay.Transactions) != 2 {
+		t.Fatalf("replayed transaction count mismatch: have %d, want 2", len(replay.Transactions))
+	}
+	if len(replay.Transactions[0].Trace) == 0 {
+		t.Errorf("counter call not traced")
+	}
+	if len(replay.Transactions[1].Trace) != 0 {
+		t.Errorf("transfer traced: %v", replay.Transactions[1].Trace)
+	}
+	account := replay.Diff.Diff.Accounts[counter]
+	if account == nil || account.Storage[common.Hash{}].To != common.BigToHash(big.NewInt(1)) {
+		t.Errorf("counter diff mismatch: have %+v", account)
+	}
+	if account := replay.Diff.Diff.Accounts[common.Address{0x01}]; account == nil || account.Balance == nil || account.Balance.To.Int64() != 1000 {
+		t.Errorf("transfer diff mismatch: have %+v", account)
+	}
+	if _, err := blockchain.ReplayBadBlock(chain[0].Hash(), nil); err != ErrUnknownBlock {
+		t.Errorf("replay of a good block error mismatch: have %v, want %v", err, ErrUnknownBlock)
+	}
+}
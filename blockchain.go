@@ -23,6 +23,7 @@ import (
 	"io"
 	"math/big"
 	mrand "math/rand"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -54,6 +55,7 @@ var (
 const (
 	bodyCacheLimit      = 256
 	blockCacheLimit     = 256
+	receiptsCacheLimit  = 32
 	maxFutureBlocks     = 256
 	maxTimeFutureBlocks = 30
 	badBlockLimit       = 10
@@ -97,11 +99,21 @@ type BlockChain struct {
 	currentBlock     *types.Block // Current head of the block chain
 	currentFastBlock *types.Block // Current head of the fast-sync chain (may be above the block chain!)
 
-	stateCache   state.Database // State database to reuse between imports (contains state cache)
-	bodyCache    *lru.Cache     // Cache for the most recent block bodies
-	bodyRLPCache *lru.Cache     // Cache for the most recent block bodies in RLP encoded format
-	blockCache   *lru.Cache     // Cache for the most recent entire blocks
-	futureBlocks *lru.Cache     // future blocks are blocks added for later processing
+	stateCache   atomic.Value     // State database to reuse between imports (contains state cache), see stateDatabase
+	snaps        *state.Snapshots // Flat snapshots of the recent states, nil if disabled
+	bodyCache    *lru.Cache       // Cache for the most recent block bodies
+	bodyRLPCache *lru.Cache       // Cache for the most recent block bodies in RLP encoded format
+	blockCache   *lru.Cache       // Cache for the most recent entire blocks
+	futureBlocks *lru.Cache       // future blocks are blocks added for later processing
+
+	cacheConfig state.CacheConfig // Cache sizes of the state database
+
+	txLookupLimit uint64        // Number of recent blocks with indexed transactions, 0 for all (atomic)
+	txIndexLock   sync.Mutex    // Serializes updates of the indexed block range
+	txIndexKick   chan struct{} // Wakes the transaction indexer on limit changes
+
+	noReceipts    int32      // Whether receipts are re-derived on demand instead of stored (atomic)
+	receiptsCache *lru.Cache // Cache for the most recently derived block receipts
 
 	quit    chan struct{} // blockchain quit channel
 	running int32         // running must be called atomically
@@ -113,24 +125,26 @@ type BlockChain struct {
 	processor Processor // block processor interface
 	validator Validator // block and state validator interface
 	vmConfig  vm.Config
-
-	badBlocks *lru.Cache // Bad block cache
 }
 
 // NewBlockChain returns a fully initialised block chain using information
 // available in the database. It initialises the default Ethereum Validator and
 // Processor.
 func NewBlockChain(chainDb ethdb.Database, config *params.ChainConfig, engine consensus.Engine, vmConfig vm.Config) (*BlockChain, error) {
+	// Upgrade the database before reading anything from it
+	if err := MigrateDatabase(chainDb, false); err != nil {
+		return nil, err
+	}
 	bodyCache, _ := lru.New(bodyCacheLimit)
 	bodyRLPCache, _ := lru.New(bodyCacheLimit)
 	blockCache, _ := lru.New(blockCacheLimit)
 	futureBlocks, _ := lru.New(maxFutureBlocks)
-	badBlocks, _ := lru.New(badBlockLimit)
 
 	bc := &BlockChain{
 		config:       config,
 		chainDb:      chainDb,
-		stateCache:   state.NewDatabase(chainDb),
+		cacheConfig:  state.DefaultCacheConfig,
+		txIndexKick:  make(chan struct{}, 1),
 		quit:         make(chan struct{}),
 		bodyCache:    bodyCache,
 		bodyRLPCache: bodyRLPCache,
@@ -138,10 +152,13 @@ func NewBlockChain(chainDb ethdb.Database, config *params.ChainConfig, engine co
 		futureBlocks: futureBlocks,
 		engine:       engine,
 		vmConfig:     vmConfig,
-		badBlocks:    badBlocks,
 	}
+	bc.stateCache.Store(state.NewDatabase(chainDb))
+	bc.receiptsCache, _ = lru.New(receiptsCacheLimit)
 	bc.SetValidator(NewBlockValidator(config, bc, engine))
-	bc.SetProcessor(NewStateProcessor(config, bc, engine))
+	processor := NewStateProcessor(config, bc, engine)
+	processor.SetWorkers(runtime.NumCPU())
+	bc.SetProcessor(processor)
 
 	var err error
 	bc.hc, err = NewHeaderChain(chainDb, config, engine, bc.getProcInterrupt)
@@ -170,6 +187,9 @@ func NewBlockChain(chainDb ethdb.Database, config *params.ChainConfig, engine co
 	}
 	// Take ownership of this particular state
 	go bc.update()
+
+	bc.wg.Add(1)
+	go bc.maintainTxIndex()
 	return bc, nil
 }
 
@@ -195,7 +215,7 @@ func (bc *BlockChain) loadLastState() error {
 		return bc.Reset()
 	}
 	// Make sure the state associated with the block is available
-	if _, err := state.New(currentBlock.Root(), bc.stateCache); err != nil {
+	if _, err := state.New(currentBlock.Root(), bc.stateDatabase()); err != nil {
 		// Dangling block without a state associated, init from scratch
 		log.Warn("Head state missing, resetting chain", "number", currentBlock.Number(), "hash", currentBlock.Hash())
 		return bc.Reset()
@@ -249,6 +269,15 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	bc.hc.SetHead(head, delFn)
 	currentHeader := bc.hc.CurrentHeader()
 
+	// Drop the frozen blocks above the new head
+	if db, ok := bc.chainDb.(interface {
+		TruncateAncients(items uint64) error
+	}); ok {
+		if err := db.TruncateAncients(currentHeader.Number.Uint64() + 1); err != nil {
+			return err
+		}
+	}
+
 	// Clear out any stale content from the caches
 	bc.bodyCache.Purge()
 	bc.bodyRLPCache.Purge()
@@ -260,7 +289,7 @@ func (bc *BlockChain) SetHead(head uint64) error {
 		bc.currentBlock = bc.GetBlock(currentHeader.Hash(), currentHeader.Number.Uint64())
 	}
 	if bc.currentBlock != nil {
-		if _, err := state.New(bc.currentBlock.Root(), bc.stateCache); err != nil {
+		if _, err := state.New(bc.currentBlock.Root(), bc.stateDatabase()); err != nil {
 			// Rewound state missing, rolled back to before pivot, reset to genesis
 			bc.currentBlock = nil
 		}
@@ -282,7 +311,11 @@ func (bc *BlockChain) SetHead(head uint64) error {
 	if err := WriteHeadFastBlockHash(bc.chainDb, bc.currentFastBlock.Hash()); err != nil {
 		log.Crit("Failed to reset head fast block", "err", err)
 	}
-	return bc.loadLastState()
+	if err := bc.loadLastState(); err != nil {
+		return err
+	}
+	bc.capSnapshots(bc.currentBlock.Root(), snapshotLayers)
+	return nil
 }
 
 // FastSyncCommitHead sets the current head block to the one defined by the hash
@@ -296,6 +329,10 @@ func (bc *BlockChain) FastSyncCommitHead(hash common.Hash) error {
 	if _, err := trie.NewSecure(block.Root(), bc.chainDb, 0); err != nil {
 		return err
 	}
+	// The root is committed before the preimages are fetched
+	if progress, ok := state.ReadSyncProgress(bc.chainDb); ok && progress.Root == block.Root() {
+		return fmt.Errorf("state sync of block [%x…] unfinished", hash[:4])
+	}
 	// If all checks out, manually set the head block
 	bc.mu.Lock()
 	bc.currentBlock = block
@@ -355,6 +392,16 @@ func (bc *BlockChain) SetProcessor(processor Processor) {
 	bc.processor = processor
 }
 
+// SetProcessWorkers sets the number of transactions executed speculatively in
+// parallel when importing blocks, runtime.NumCPU() by default. With fewer than
+// two workers transactions are applied sequentially. It has no effect on a
+// processor other than StateProcessor set with SetProcessor.
+func (bc *BlockChain) SetProcessWorkers(workers int) {
+	if processor, ok := bc.Processor().(*StateProcessor); ok {
+		processor.SetWorkers(workers)
+	}
+}
+
 // SetValidator sets the validator which is used to validate incoming blocks.
 func (bc *BlockChain) SetValidator(validator Validator) {
 	bc.procmu.Lock()
@@ -383,7 +430,38 @@ func (bc *BlockChain) State() (*state.StateDB, error) {
 
 // StateAt returns a new mutable state based on a particular point in time.
 func (bc *BlockChain) StateAt(root common.Hash) (*state.StateDB, error) {
-	return state.New(root, bc.stateCache)
+	return state.New(root, bc.stateDatabase())
+}
+
+// SetStateCacheConfig replaces the state database of the chain with one
+// using the given cache sizes. The cached tries and code of the previous one
+// are dropped.
+func (bc *BlockChain) SetStateCacheConfig(config state.CacheConfig) {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.cacheConfig = config
+	bc.stateCache.Store(state.NewDatabaseWithConfig(bc.chainDb, config, bc.snaps))
+}
+
+// stateDatabase returns the state database of the chain. It may be called
+// without holding any lock, the database being replaced atomically.
+func (bc *BlockChain) stateDatabase() state.Database {
+	return bc.stateCache.Load().(state.Database)
+}
+
+// StateView returns a read-only view of the current state, see StateViewAt.
+func (bc *BlockChain) StateView() (*state.StateView, error) {
+	return bc.StateViewAt(bc.CurrentBlock().Root())
+}
+
+// StateViewAt returns a read-only view of the state at a particular point in
+// time. It can be read by many goroutines in parallel and shares the state
+// caches of the chain.
+func (bc *BlockChain) StateViewAt(root common.Hash) (*state.StateView, error) {
+	return state.NewStateView(root, bc.stateDatabase())
 }
 
 // Reset purges the entire blockchain, restoring it to its genesis state.
@@ -533,7 +611,7 @@ func (bc *BlockChain) HasBlockAndState(hash common.Hash) bool {
 		return false
 	}
 	// Ensure the associated state is also present
-	_, err := bc.stateCache.OpenTrie(block.Root())
+	_, err := bc.stateDatabase().OpenTrie(block.Root())
 	return err == nil
 }
 
@@ -607,6 +685,16 @@ func (bc *BlockChain) Stop() {
 	atomic.StoreInt32(&bc.procInterrupt, 1)
 
 	bc.wg.Wait()
+
+	// Persist the snapshot of the head state, so it is loaded on restart
+	bc.mu.Lock()
+	bc.capSnapshots(bc.currentBlock.Root(), 0)
+	snaps := bc.snaps
+	bc.mu.Unlock()
+	if snaps != nil {
+		snaps.Close()
+	}
+
 	log.Info("Blockchain manager stopped")
 }
 
@@ -737,8 +825,12 @@ func (bc *BlockChain) InsertReceiptChain(blockChain types.Blocks, receiptChain [
 		if err := WriteBody(batch, block.Hash(), block.NumberU64(), block.Body()); err != nil {
 			return i, fmt.Errorf("failed to write block body: %v", err)
 		}
-		if err := WriteBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts); err != nil {
-			return i, fmt.Errorf("failed to write block receipts: %v", err)
+		if !bc.Receiptless() {
+			if err := WriteBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts); err != nil {
+				return i, fmt.Errorf("failed to write block receipts: %v", err)
+			}
+		} else if err := WriteOmittedReceipts(batch, block.Hash(), block.NumberU64()); err != nil {
+			return i, fmt.Errorf("failed to write omitted receipts marker: %v", err)
 		}
 		if err := WriteTxLookupEntries(batch, block); err != nil {
 			return i, fmt.Errorf("failed to write lookup metadata: %v", err)
@@ -813,7 +905,11 @@ func (bc *BlockChain) WriteBlockAndState(block *types.Block, receipts []*types.R
 	if _, err := state.CommitTo(batch, true /*bc.config.IsEIP158(block.Number())*/); err != nil {
 		return NonStatTy, err
 	}
-	if err := WriteBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts); err != nil {
+	if !bc.Receiptless() {
+		if err := WriteBlockReceipts(batch, block.Hash(), block.NumberU64(), receipts); err != nil {
+			return NonStatTy, err
+		}
+	} else if err := WriteOmittedReceipts(batch, block.Hash(), block.NumberU64()); err != nil {
 		return NonStatTy, err
 	}
 
@@ -846,6 +942,7 @@ func (bc *BlockChain) WriteBlockAndState(block *types.Block, receipts []*types.R
 	// Set new head.
 	if status == CanonStatTy {
 		bc.insert(block)
+		bc.capSnapshots(block.Root(), snapshotLayers)
 	}
 	bc.futureBlocks.Remove(block.Hash())
 	return status, nil
@@ -959,7 +1056,7 @@ func (bc *BlockChain) insertChain(chain types.Blocks) (int, []interface{}, []*ty
 		} else {
 			parent = chain[i-1]
 		}
-		state, err := state.New(parent.Root(), bc.stateCache)
+		state, err := state.New(parent.Root(), bc.stateDatabase())
 		if err != nil {
 			return i, events, coalescedLogs, err
 		}
@@ -1073,7 +1170,7 @@ func (bc *BlockChain) reorg(oldBlock, newBlock *types.Block) error {
 		// These logs are later announced as deleted.
 		collectLogs = func(h common.Hash) {
 			// Coalesce logs and set 'Removed'.
-			receipts := GetBlockReceipts(bc.chainDb, h, bc.hc.GetBlockNumber(h))
+			receipts, _ := bc.GetReceiptsByHash(h)
 			for _, receipt := range receipts {
 				for _, log := range receipt.Logs {
 					del := *log
@@ -1203,42 +1300,64 @@ func (bc *BlockChain) PostChainEvents(events []interface{}, logs []*types.Log) {
 
 func (bc *BlockChain) update() {
 	futureTimer := time.Tick(5 * time.Second)
+	freezeTimer := time.Tick(time.Minute)
 	for {
 		select {
 		case <-futureTimer:
 			bc.procFutureBlocks()
+		case <-freezeTimer:
+			bc.freeze()
 		case <-bc.quit:
 			return
 		}
 	}
 }
 
+// freeze moves the old canonical blocks into the freezer of the chain
+// database, if it has one.
+func (bc *BlockChain) freeze() {
+	db, ok := bc.chainDb.(interface {
+		Freeze() (int, error)
+	})
+	if !ok {
+		return
+	}
+	for {
+		// Exclude rewinds, which truncate the frozen blocks
+		bc.mu.RLock()
+		n, err := db.Freeze()
+		bc.mu.RUnlock()
+
+		if err != nil {
+			log.Error("Failed to freeze ancient blocks", "err", err)
+			return
+		}
+		if n < freezerBatchLimit || bc.getProcInterrupt() {
+			return
+		}
+	}
+}
+
 // BadBlockArgs represents the entries in the list returned when bad blocks are queried.
 type BadBlockArgs struct {
 	Hash   common.Hash   `json:"hash"`
 	Header *types.Header `json:"header"`
 }
 
-// BadBlocks returns a list of the last 'bad blocks' that the client has seen on the network
+// BadBlocks returns a list of the last 'bad blocks' that the client has seen on the network,
+// the most recent first. They are persisted, surviving restarts.
 func (bc *BlockChain) BadBlocks() ([]BadBlockArgs, error) {
-	headers := make([]BadBlockArgs, 0, bc.badBlocks.Len())
-	for _, hash := range bc.badBlocks.Keys() {
-		if hdr, exist := bc.badBlocks.Peek(hash); exist {
-			header := hdr.(*types.Header)
-			headers = append(headers, BadBlockArgs{header.Hash(), header})
-		}
+	bad := GetBadBlocks(bc.chainDb)
+	headers := make([]BadBlockArgs, 0, len(bad))
+	for _, b := range bad {
+		headers = append(headers, BadBlockArgs{b.Block.Hash(), b.Block.Header()})
 	}
 	return headers, nil
 }
 
-// addBadBlock adds a bad block to the bad-block LRU cache
-func (bc *BlockChain) addBadBlock(block *types.Block) {
-	bc.badBlocks.Add(block.Header().Hash(), block.Header())
-}
-
-// reportBlock logs a bad block error.
+// reportBlock logs a bad block error and persists the block.
 func (bc *BlockChain) reportBlock(block *types.Block, receipts types.Receipts, err error) {
-	bc.addBadBlock(block)
+	bc.addBadBlock(block, receipts, err)
 
 	var receiptString string
 	for _, receipt := range receipts {
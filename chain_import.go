@@ -0,0 +1,207 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/types"
+)
+
+// DefaultImportBatch is the number of blocks inserted at once by Import.
+const DefaultImportBatch = 2500
+
+var errImportInterrupted = errors.New("import interrupted")
+
+// ImportOptions configures a chain import.
+type ImportOptions struct {
+	First     uint64               // Number of the first block to import
+	Last      uint64               // Number of the last block to import, 0 for all
+	BatchSize int                  // Blocks inserted at once, DefaultImportBatch if 0
+	Progress  func(ImportProgress) // Called after every batch if not nil
+}
+
+// ImportProgress is the progress of a chain import.
+type ImportProgress struct {
+	Head     uint64        // Number of the last block read from the export
+	Imported uint64        // Blocks inserted into the chain
+	Skipped  uint64        // Blocks skipped, being in the chain already
+	Elapsed  time.Duration // Time since the import started
+}
+
+// Import inserts the blocks of an export written by Export or ExportN, which
+// may be gzip compressed. Blocks already in the chain are skipped, so an
+// interrupted import resumes from the current head when restarted.
+//
+// Decoding and transaction sender recovery of a batch run concurrently with
+// the insertion of the previous one, whose headers are verified in parallel.
+func (bc *BlockChain) Import(r io.Reader, opts ImportOptions) error {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatch
+	}
+	if opts.Last != 0 && opts.First > opts.Last {
+		return fmt.Errorf("import failed: first (%d) is greater than last (%d)", opts.First, opts.Last)
+	}
+	buf := bufio.NewReader(r)
+	if magic, err := buf.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = buf
+	}
+	var (
+		progress = ImportProgress{}
+		start    = time.Now()
+		batches  = make(chan importBatch, 1)
+		quit     = make(chan struct{})
+		reader   sync.WaitGroup
+	)
+	// Stop the reader before returning, it mustn't read from a closed gzip
+	// stream
+	defer func() {
+		close(quit)
+		reader.Wait()
+	}()
+	reader.Add(1)
+	go func() {
+		defer reader.Done()
+		bc.readImport(rlp.NewStream(r, 0), opts, batches, quit)
+	}()
+
+	log.Info("Importing blockchain", "first", opts.First, "last", opts.Last)
+	for batch := range batches {
+		if batch.err != nil {
+			return batch.err
+		}
+		if bc.getProcInterrupt() {
+			return errImportInterrupted
+		}
+		if len(batch.blocks) > 0 {
+			if n, err := bc.InsertChain(batch.blocks); err != nil {
+				return fmt.Errorf("import failed on #%d: %v", batch.blocks[n].NumberU64(), err)
+			}
+		}
+		progress.Head = batch.head
+		progress.Imported += uint64(len(batch.blocks))
+		progress.Skipped += batch.skipped
+		progress.Elapsed = time.Since(start)
+		if opts.Progress != nil {
+			opts.Progress(progress)
+		}
+	}
+	log.Info("Imported blockchain", "imported", progress.Imported, "skipped", progress.Skipped, "elapsed", progress.Elapsed)
+	return nil
+}
+
+// importBatch is a batch of decoded blocks to insert.
+type importBatch struct {
+	blocks  types.Blocks
+	head    uint64 // Number of the last block read
+	skipped uint64 // Known blocks dropped from the batch
+	err     error
+}
+
+// readImport decodes the export into batches of unknown blocks with their
+// transaction senders recovered, until the end of the stream or the range.
+func (bc *BlockChain) readImport(stream *rlp.Stream, opts ImportOptions, batches chan<- importBatch, quit <-chan struct{}) {
+	defer close(batches)
+
+	deliver := func(batch importBatch) bool {
+		bc.recoverSenders(batch.blocks)
+		select {
+		case batches <- batch:
+			return true
+		case <-quit:
+			return false
+		}
+	}
+	var batch importBatch
+	for {
+		select {
+		case <-quit:
+			return
+		default:
+		}
+		block := new(types.Block)
+		if err := stream.Decode(block); err == io.EOF {
+			break
+		} else if err != nil {
+			deliver(importBatch{err: fmt.Errorf("import failed after #%d: %v", batch.head, err)})
+			return
+		}
+		number := block.NumberU64()
+		if number < opts.First || number == 0 {
+			continue
+		}
+		if opts.Last != 0 && number > opts.Last {
+			break
+		}
+		batch.head = number
+		if bc.knownImport(block) {
+			batch.skipped++
+		} else {
+			batch.blocks = append(batch.blocks, block)
+		}
+		if len(batch.blocks)+int(batch.skipped) >= opts.BatchSize {
+			if !deliver(batch) {
+				return
+			}
+			batch = importBatch{head: number}
+		}
+	}
+	if len(batch.blocks) > 0 || batch.skipped > 0 {
+		deliver(batch)
+	}
+}
+
+// knownImport reports whether the imported block is in the chain already,
+// either canonical below the head or with its state available.
+func (bc *BlockChain) knownImport(block *types.Block) bool {
+	number := block.NumberU64()
+	if number <= bc.CurrentBlock().NumberU64() && GetCanonicalHash(bc.chainDb, number) == block.Hash() {
+		return true
+	}
+	return bc.HasBlockAndState(block.Hash())
+}
+
+// recoverSenders derives the senders of the transactions of the blocks on all
+// cores. The signatures cache them, so block processing doesn't have to.
+func (bc *BlockChain) recoverSenders(blocks types.Blocks) {
+	type task struct {
+		signer types.Signer
+		tx     *types.Transaction
+	}
+	tasks := make(chan task, 256)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				types.Sender(task.signer, task.tx)
+			}
+		}()
+	}
+	for _, block := range blocks {
+		signer := types.MakeSigner(bc.config, block.Number())
+		for _, tx := range block.Transactions() {
+			tasks <- task{signer, tx}
+		}
+	}
+	close(tasks)
+	wg.Wait()
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+// Tests that exports are imported in batches, range limited, gzip compressed
+// and resumed from the current head.
+func TestChainImport(t *testing.T) {
+	_, source, err, _ := newCanonical(32, true)
+	if err != nil {
+		t.Fatalf("failed to create source chain: %v", err)
+	}
+	defer source.Stop()
+
+	_, blockchain, _, _ := newCanonical(0, true)
+	defer blockchain.Stop()
+
+	// Import the first part of a plain export
+	var plain bytes.Buffer
+	if err := source.Export(&plain); err != nil {
+		t.Fatalf("failed to export chain: %v", err)
+	}
+	var reports []ImportProgress
+	opts := ImportOptions{Last: 20, BatchSize: 6, Progress: func(p ImportProgress) { reports = append(reports, p) }}
+	if err := blockchain.Import(&plain, opts); err != nil {
+		t.Fatalf("failed to import chain: %v", err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != source.GetBlockByNumber(20).Hash() {
+		t.Fatalf("head mismatch: have #%d, want #20", head.NumberU64())
+	}
+	if len(reports) != 4 {
+		t.Errorf("progress report count mismatch: have %d, want 4", len(reports))
+	}
+	if last := reports[len(reports)-1]; last.Head != 20 || last.Imported != 20 || last.Skipped != 0 {
+		t.Errorf("progress mismatch: %+v", last)
+	}
+	// Resume from a gzipped range export overlapping the imported blocks
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if err := source.ExportN(gz, 10, 32); err != nil {
+		t.Fatalf("failed to export chain range: %v", err)
+	}
+	gz.Close()
+
+	var last ImportProgress
+	if err := blockchain.Import(&compressed, ImportOptions{Progress: func(p ImportProgress) { last = p }}); err != nil {
+		t.Fatalf("failed to resume import: %v", err)
+	}
+	if head := blockchain.CurrentBlock(); head.Hash() != source.CurrentBlock().Hash() {
+		t.Fatalf("head mismatch: have #%d, want #32", head.NumberU64())
+	}
+	if last.Head != 32 || last.Imported != 12 || last.Skipped != 11 {
+		t.Errorf("resumed progress mismatch: %+v", last)
+	}
+}
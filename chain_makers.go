@@ -84,12 +84,9 @@ func NewChainEnv(config *params.ChainConfig, g *Genesis, engine consensus.Engine
 		blockChain:   bc,
 		db:           db,
 		mapSigners:   make(map[common.Address]struct{}),
-		arraySigners: make([]common.Address, len(g.ExtraData)/common.AddressLength),
+		arraySigners: g.SignerList(),
 	}
 
-	for i := 0; i < len(ce.arraySigners); i++ {
-		copy(ce.arraySigners[i][:], g.ExtraData[i*common.AddressLength:])
-	}
 	for _, s := range ce.arraySigners {
 		ce.mapSigners[s] = struct{}{}
 	}
@@ -406,6 +403,107 @@ func newCanonical(n int, full bool) (ethdb.Database, *BlockChain, error, *ChainE
 	return db, blockchain, err, chainEnv
 }
 
+// testChain is a fresh chain whose genesis funds a single account, used by
+// tests needing blocks with transactions.
+type testChain struct {
+	key     *ecdsa.PrivateKey
+	addr    common.Address
+	signer  types.Signer
+	gspec   *Genesis
+	genesis *types.Block
+
+	db         ethdb.Database
+	engine     *ethash.Ethash
+	blockchain *BlockChain
+	chainEnv   *ChainEnv
+}
+
+// newTestChain creates a chain whose genesis allocates balance to a freshly
+// generated key.
+func newTestChain(balance *big.Int) (*testChain, error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	c := &testChain{key: key, addr: crypto.PubkeyToAddress(key.PublicKey), gspec: DefaultPPOWTestingGenesisBlock()}
+	c.gspec.Alloc = GenesisAlloc{c.addr: {Balance: balance}}
+	c.signer = types.NewEIP155Signer(c.gspec.Config.ChainId)
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// sibling creates a chain with the same genesis and funded key as c in a
+// database of its own.
+func (c *testChain) sibling() (*testChain, error) {
+	sibling := &testChain{key: c.key, addr: c.addr, signer: c.signer, gspec: c.gspec}
+	if err := sibling.init(); err != nil {
+		return nil, err
+	}
+	return sibling, nil
+}
+
+// init commits the genesis into a new database and opens the chain on it.
+func (c *testChain) init() error {
+	db, err := ethdb.NewMemDatabase()
+	if err != nil {
+		return err
+	}
+	if c.genesis, err = c.gspec.Commit(db); err != nil {
+		return err
+	}
+	c.db, c.engine = db, ethash.NewFaker(db)
+	return c.open()
+}
+
+// open opens the chain on its database, e.g. after stop.
+func (c *testChain) open() error {
+	blockchain, err := NewBlockChain(c.db, c.gspec.Config, c.engine, vm.Config{})
+	if err != nil {
+		return err
+	}
+	c.blockchain = blockchain
+	c.chainEnv = NewChainEnv(c.gspec.Config, c.gspec, c.engine, c.blockchain, c.db)
+	return nil
+}
+
+// stop stops the chain last opened. Stopping a stopped chain is a no-op, so
+// tests can defer it and still stop and reopen the chain.
+func (c *testChain) stop() {
+	c.blockchain.Stop()
+}
+
+// generate creates n blocks on top of parent, the genesis if nil. Each block
+// is filled by gen, by default a transfer from the funded account to the
+// address numbered after the block. Generation panics on invalid blocks.
+func (c *testChain) generate(parent *types.Block, n int, gen func(int, *BlockGen)) []*types.Block {
+	if parent == nil {
+		parent = c.genesis
+	}
+	if gen == nil {
+		gen = func(i int, block *BlockGen) {
+			c.transfer(block, common.Address{byte(i)})
+		}
+	}
+	blocks, _ := c.chainEnv.GenerateChain(parent, n, gen)
+	return blocks
+}
+
+// transfer adds a transfer of 1000 wei from the funded account to the block.
+func (c *testChain) transfer(block *BlockGen, to common.Address) {
+	c.send(block, types.NewTransaction(block.TxNonce(c.addr), to, big.NewInt(1000), new(big.Int).SetUint64(params.TxGas), big.NewInt(1), nil))
+}
+
+// send signs the transaction with the funded key and adds it to the block.
+func (c *testChain) send(block *BlockGen, tx *types.Transaction) {
+	signed, err := types.SignTx(tx, c.signer, c.key)
+	if err != nil {
+		panic(err)
+	}
+	block.AddTx(signed)
+}
+
 // makeHeaderChain creates a deterministic chain of headers rooted at parent.
 func (self *ChainEnv) makeHeaderChain(parent *types.Header, n int, seed int) []*types.Header {
 	blocks := self.makeBlockChain(types.NewBlockWithHeader(parent), n, seed)
@@ -0,0 +1,146 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"fmt"
+
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+)
+
+// RewindTo makes the known block with the given hash the head of the chain,
+// be it an ancestor of the current head or a block on a side chain. Unlike
+// SetHead nothing is deleted: the blocks leaving the canonical chain remain
+// available as side chain blocks. The state of the block must be available.
+//
+// Subscribers see the dropped blocks as ChainSideEvents and their logs as a
+// RemovedLogsEvent, followed by a ChainHeadEvent of the new head, which also
+// resets the transaction pool.
+func (bc *BlockChain) RewindTo(hash common.Hash) error {
+	oldChain, deletedLogs, err := bc.rewindTo(hash)
+	if err != nil {
+		return err
+	}
+	if len(deletedLogs) > 0 {
+		bc.rmLogsFeed.Send(RemovedLogsEvent{deletedLogs})
+	}
+	events := make([]interface{}, 0, len(oldChain)+1)
+	for _, block := range oldChain {
+		events = append(events, ChainSideEvent{Block: block})
+	}
+	events = append(events, ChainHeadEvent{Block: bc.CurrentBlock()})
+	bc.PostChainEvents(events, nil)
+	return nil
+}
+
+// rewindTo switches the canonical chain to the block with the given hash,
+// returning the blocks dropped from it and their logs.
+func (bc *BlockChain) rewindTo(hash common.Hash) (types.Blocks, []*types.Log, error) {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	target := bc.GetBlockByHash(hash)
+	if target == nil {
+		return nil, nil, ErrUnknownBlock
+	}
+	if _, err := state.New(target.Root(), bc.stateDatabase()); err != nil {
+		return nil, nil, ErrStateUnavailable
+	}
+	// Walk both chains back to their common ancestor
+	var (
+		oldChain, newChain types.Blocks
+		oldBlock, newBlock = bc.currentBlock, target
+	)
+	for oldBlock.NumberU64() > newBlock.NumberU64() {
+		oldChain = append(oldChain, oldBlock)
+		if oldBlock = bc.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1); oldBlock == nil {
+			return nil, nil, fmt.Errorf("invalid old chain")
+		}
+	}
+	for newBlock.NumberU64() > oldBlock.NumberU64() {
+		newChain = append(newChain, newBlock)
+		if newBlock = bc.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1); newBlock == nil {
+			return nil, nil, fmt.Errorf("invalid new chain")
+		}
+	}
+	for oldBlock.Hash() != newBlock.Hash() {
+		oldChain = append(oldChain, oldBlock)
+		newChain = append(newChain, newBlock)
+
+		oldBlock = bc.GetBlock(oldBlock.ParentHash(), oldBlock.NumberU64()-1)
+		newBlock = bc.GetBlock(newBlock.ParentHash(), newBlock.NumberU64()-1)
+		if oldBlock == nil {
+			return nil, nil, fmt.Errorf("invalid old chain")
+		}
+		if newBlock == nil {
+			return nil, nil, fmt.Errorf("invalid new chain")
+		}
+	}
+	ancestor := oldBlock
+
+	// Frozen blocks are canonical for good
+	if db, ok := bc.chainDb.(interface {
+		Ancients() uint64
+	}); ok && ancestor.NumberU64()+1 < db.Ancients() {
+		return nil, nil, fmt.Errorf("rewind to #%d [%x…] forks off frozen block #%d", target.NumberU64(), hash[:4], ancestor.NumberU64())
+	}
+	log.Warn("Rewinding blockchain", "number", target.Number(), "hash", hash, "ancestor", ancestor.Number(),
+		"drop", len(oldChain), "add", len(newChain))
+
+	var (
+		deletedTxs, addedTxs types.Transactions
+		deletedLogs          []*types.Log
+	)
+	for _, block := range oldChain {
+		deletedTxs = append(deletedTxs, block.Transactions()...)
+
+		receipts, _ := bc.GetReceiptsByHash(block.Hash())
+		for _, receipt := range receipts {
+			for _, l := range receipt.Logs {
+				del := *l
+				del.Removed = true
+				deletedLogs = append(deletedLogs, &del)
+			}
+		}
+	}
+	// Switch the canonical hashes over to the new chain and drop the ones
+	// above it, the headers beyond the head block included
+	for _, block := range newChain {
+		if err := WriteCanonicalHash(bc.chainDb, block.Hash(), block.NumberU64()); err != nil {
+			return nil, nil, err
+		}
+		if err := WriteTxLookupEntries(bc.chainDb, block); err != nil {
+			return nil, nil, err
+		}
+		addedTxs = append(addedTxs, block.Transactions()...)
+	}
+	top := bc.hc.CurrentHeader().Number.Uint64()
+	if number := bc.currentBlock.NumberU64(); number > top {
+		top = number
+	}
+	for number := target.NumberU64() + 1; number <= top; number++ {
+		DeleteCanonicalHash(bc.chainDb, number)
+	}
+	for _, tx := range types.TxDifference(deletedTxs, addedTxs) {
+		DeleteTxLookupEntry(bc.chainDb, tx.Hash())
+	}
+	// Move all the heads to the target
+	if err := WriteHeadBlockHash(bc.chainDb, hash); err != nil {
+		log.Crit("Failed to insert head block hash", "err", err)
+	}
+	if err := WriteHeadFastBlockHash(bc.chainDb, hash); err != nil {
+		log.Crit("Failed to insert head fast block hash", "err", err)
+	}
+	bc.hc.SetCurrentHeader(target.Header())
+	bc.currentBlock = target
+	bc.currentFastBlock = target
+	bc.futureBlocks.Purge()
+
+	bc.capSnapshots(target.Root(), snapshotLayers)
+	return oldChain, deletedLogs, nil
+}
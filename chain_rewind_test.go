@@ -0,0 +1,151 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/types"
+)
+
+// Tests that the chain can be rewound to an ancestor and switched to a side
+// chain and back, keeping all blocks and announcing the dropped ones.
+func TestRewindTo(t *testing.T) {
+	// this code generates a log
+	code := common.Hex2Bytes("60606040525b7f24ec1d3ff24c2f6ff210738839dbc339cd45a5294d85c79361016243157aae7b60405180905060405180910390a15b600a8060416000396000f360606040526008565b00")
+
+	c, err := newTestChain(big.NewInt(10000000000000))
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer c.stop()
+	db, blockchain, genesis := c.db, c.blockchain, c.genesis
+
+	// A canonical chain of 4 blocks with a log in the second, and a side chain
+	// of 2 blocks forking off the first
+	chain := c.generate(nil, 4, func(i int, gen *BlockGen) {
+		if i == 1 {
+			c.send(gen, types.NewContractCreation(gen.TxNonce(c.addr), new(big.Int), big.NewInt(1000000), big.NewInt(1), code))
+			return
+		}
+		c.transfer(gen, common.Address{byte(i)})
+	})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	side := c.generate(chain[0], 2, func(i int, gen *BlockGen) {
+		c.transfer(gen, common.Address{0xff})
+	})
+	if _, err := blockchain.InsertChain(side); err != nil {
+		t.Fatalf("failed to insert side chain: %v", err)
+	}
+	if head := blockchain.CurrentBlock().Hash(); head != chain[3].Hash() {
+		t.Fatalf("side chain became canonical")
+	}
+	pool := NewTxPool(testTxPoolConfig, c.gspec.Config, blockchain)
+	defer pool.Stop()
+
+	var (
+		sideCh   = make(chan ChainSideEvent, 16)
+		rmLogsCh = make(chan RemovedLogsEvent, 16)
+		headCh   = make(chan ChainHeadEvent, 16)
+	)
+	defer blockchain.SubscribeChainSideEvent(sideCh).Unsubscribe()
+	defer blockchain.SubscribeRemovedLogsEvent(rmLogsCh).Unsubscribe()
+	defer blockchain.SubscribeChainHeadEvent(headCh).Unsubscribe()
+
+	// check verifies the canonical chain and the announced dropped blocks.
+	check := func(canon []*types.Block, dropped []*types.Block, logs bool) {
+		head := canon[len(canon)-1]
+		if have := blockchain.CurrentBlock().Hash(); have != head.Hash() {
+			t.Fatalf("head mismatch: have %x, want %x", have, head.Hash())
+		}
+		if have := blockchain.CurrentHeader().Hash(); have != head.Hash() {
+			t.Errorf("head header mismatch: have %x, want %x", have, head.Hash())
+		}
+		for _, block := range canon {
+			if hash := GetCanonicalHash(db, block.NumberU64()); hash != block.Hash() {
+				t.Errorf("block %d: canonical hash mismatch: have %x, want %x", block.NumberU64(), hash, block.Hash())
+			}
+			for _, tx := range block.Transactions() {
+				if hash, _, _ := GetTxLookupEntry(db, tx.Hash()); hash != block.Hash() {
+					t.Errorf("block %d: lookup mismatch: have %x, want %x", block.NumberU64(), hash, block.Hash())
+				}
+			}
+		}
+		if hash := GetCanonicalHash(db, head.NumberU64()+1); hash != (common.Hash{}) {
+			t.Errorf("canonical hash above head: %x", hash)
+		}
+		for _, block := range append(chain, side...) {
+			if blockchain.GetBlockByHash(block.Hash()) == nil {
+				t.Errorf("block %d [%x…] lost", block.NumberU64(), block.Hash().Bytes()[:4])
+			}
+		}
+		for _, block := range dropped {
+			select {
+			case ev := <-sideCh:
+				if ev.Block.Hash() != block.Hash() {
+					t.Errorf("side event mismatch: have %x, want %x", ev.Block.Hash(), block.Hash())
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("side event of block %d missing", block.NumberU64())
+			}
+		}
+		if logs {
+			select {
+			case ev := <-rmLogsCh:
+				if len(ev.Logs) == 0 || !ev.Logs[0].Removed {
+					t.Errorf("removed logs mismatch: %v", ev.Logs)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("removed logs event missing")
+			}
+		}
+		select {
+		case ev := <-headCh:
+			if ev.Block.Hash() != head.Hash() {
+				t.Errorf("head event mismatch: have %x, want %x", ev.Block.Hash(), head.Hash())
+			}
+		case <-time.After(time.Second):
+			t.Fatal("head event missing")
+		}
+		select {
+		case ev := <-sideCh:
+			t.Errorf("unexpected side event of block %d", ev.Block.NumberU64())
+		case ev := <-rmLogsCh:
+			t.Errorf("unexpected removed logs: %v", ev.Logs)
+		default:
+		}
+	}
+	// Switch to the side chain, dropping the log
+	if err := blockchain.RewindTo(side[1].Hash()); err != nil {
+		t.Fatalf("failed to rewind to side chain: %v", err)
+	}
+	check([]*types.Block{genesis, chain[0], side[0], side[1]}, []*types.Block{chain[3], chain[2], chain[1]}, true)
+
+	// The pool picks up the transactions dropped from the canonical chain
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.Get(chain[3].Transactions()[0].Hash()) == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("dropped transaction not reinjected into the pool")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Back to the original chain, then to one of its ancestors
+	if err := blockchain.RewindTo(chain[3].Hash()); err != nil {
+		t.Fatalf("failed to rewind to original chain: %v", err)
+	}
+	check(append([]*types.Block{genesis}, chain...), []*types.Block{side[1], side[0]}, false)
+
+	if err := blockchain.RewindTo(chain[0].Hash()); err != nil {
+		t.Fatalf("failed to rewind to ancestor: %v", err)
+	}
+	check([]*types.Block{genesis, chain[0]}, []*types.Block{chain[3], chain[2], chain[1]}, true)
+
+	if err := blockchain.RewindTo(common.Hash{0x01}); err != ErrUnknownBlock {
+		t.Errorf("unknown block rewind error mismatch: have %v, want %v", err, ErrUnknownBlock)
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/types"
+)
+
+// DatabaseStat is the number and total size of the database entries of a kind.
+type DatabaseStat struct {
+	Kind  string
+	Count uint64
+	Size  common.StorageSize
+}
+
+// databaseKinds classifies the database entries by key layout, see the
+// prefixes in database_util.go. The first matching kind counts.
+var databaseKinds = []struct {
+	name  string
+	match func(key []byte) bool
+}{
+	{"Headers", keyLayout(headerPrefix, 8+common.HashLength, nil)},
+	{"Total difficulties", keyLayout(headerPrefix, 8+common.HashLength, tdSuffix)},
+	{"Canonical hashes", keyLayout(headerPrefix, 8, numSuffix)},
+	{"Block numbers", keyLayout(blockHashPrefix, common.HashLength, nil)},
+	{"Bodies", keyLayout(bodyPrefix, 8+common.HashLength, nil)},
+	{"Receipts", keyLayout(blockReceiptsPrefix, 8+common.HashLength, nil)},
+	{"Omitted receipts markers", keyLayout(noReceiptsPrefix, 8+common.HashLength, nil)},
+	{"Transaction lookups", keyLayout(lookupPrefix, common.HashLength, nil)},
+	{"Bloom bits", keyLayout(bloomBitsPrefix, 2+8+common.HashLength, nil)},
+	{"Bad blocks", keyLayout(badBlockPrefix, common.HashLength, nil)},
+	{"Trie preimages", keyLayout([]byte(preimagePrefix), common.HashLength, nil)},
+	{"Trie nodes and codes", keyLayout(nil, common.HashLength, nil)},
+	{"State snapshot accounts", keyLayout([]byte("sa"), common.HashLength, nil)},
+	{"State snapshot storage", keyLayout([]byte("ss"), 2*common.HashLength, nil)},
+}
+
+// keyLayout matches keys made of the prefix, a fixed size body and the suffix.
+func keyLayout(prefix []byte, body int, suffix []byte) func(key []byte) bool {
+	return func(key []byte) bool {
+		return len(key) == len(prefix)+body+len(suffix) && bytes.HasPrefix(key, prefix) && bytes.HasSuffix(key, suffix)
+	}
+}
+
+// InspectDatabase returns the number and size of the entries of each kind in
+// the database, the unclassified ones last. The blocks of a freezer count
+// as an extra kind.
+func InspectDatabase(db ethdb.Database) ([]DatabaseStat, error) {
+	stats := make([]DatabaseStat, len(databaseKinds)+1)
+	for i, kind := range databaseKinds {
+		stats[i].Kind = kind.name
+	}
+	stats[len(databaseKinds)].Kind = "Other"
+
+	var (
+		start  = time.Now()
+		logged = time.Now()
+		count  uint64
+	)
+	err := forEachEntry(db, func(key, value []byte) error {
+		stat := &stats[len(databaseKinds)]
+		for i, kind := range databaseKinds {
+			if kind.match(key) {
+				stat = &stats[i]
+				break
+			}
+		}
+		stat.Count++
+		stat.Size += common.StorageSize(len(key) + len(value))
+
+		if count++; time.Since(logged) > statsReportLimit {
+			log.Info("Inspecting database", "entries", count, "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fdb, ok := db.(*FreezerDB); ok {
+		stats = append(stats, DatabaseStat{
+			Kind:  "Ancient blocks",
+			Count: fdb.Ancients(),
+			Size:  common.StorageSize(fdb.freezer.size()),
+		})
+	}
+	return stats, nil
+}
+
+// IssueKind classifies integrity issues by how they are repaired.
+type IssueKind int
+
+const (
+	BlockIssue   IssueKind = iota // Chain or block data broken, repaired by rewinding the chain
+	ReceiptIssue                  // Receipts missing or broken, repaired by re-deriving them
+	LookupIssue                   // Transaction lookup entries broken, repaired by reindexing
+)
+
+// IntegrityIssue is an inconsistency of the canonical chain data.
+type IntegrityIssue struct {
+	Kind    IssueKind
+	Number  uint64
+	Hash    common.Hash
+	Problem string
+}
+
+func (issue IntegrityIssue) String() string {
+	return fmt.Sprintf("#%d [%x…]: %s", issue.Number, issue.Hash[:4], issue.Problem)
+}
+
+// IntegrityReport is the result of a database verification.
+type IntegrityReport struct {
+	Head       uint64 // Number of the head header
+	HeadBlock  uint64 // Number of the head block
+	Consistent uint64 // Number of the last block before the first issue
+	Issues     []IntegrityIssue
+}
+
+// VerifyDatabase walks the canonical chain from the genesis to the head
+// header, checking that the canonical hashes, headers, number index and total
+// difficulties of all blocks, and the bodies, receipts and transaction lookup
+// entries of all blocks up to the head block are present and reference each
+// other correctly. Blocks imported in receiptless mode have no receipts. The
+// state of the head block must be available.
+func VerifyDatabase(db ethdb.Database) (*IntegrityReport, error) {
+	headHeader := GetBlockNumber(db, GetHeadHeaderHash(db))
+	headBlock := GetBlockNumber(db, GetHeadBlockHash(db))
+	if headHeader == missingNumber || headBlock == missingNumber {
+		return nil, fmt.Errorf("chain head missing")
+	}
+	report := &IntegrityReport{Head: headHeader, HeadBlock: headBlock}
+	issue := func(kind IssueKind, number uint64, hash common.Hash, format string, args ...interface{}) {
+		if len(report.Issues) == 0 && number > 0 {
+			report.Consistent = number - 1
+		}
+		report.Issues = append(report.Issues, IntegrityIssue{kind, number, hash, fmt.Sprintf(format, args...)})
+	}
+	var (
+		start    = time.Now()
+		logged   = time.Now()
+		parent   common.Hash
+		parentTd *big.Int
+		txTail   = GetTxIndexTail(db)
+	)
+	for number := uint64(0); number <= headHeader; number++ {
+		if time.Since(logged) > statsReportLimit {
+			log.Info("Verifying database", "number", number, "head", headHeader, "issues", len(report.Issues), "elapsed", common.PrettyDuration(time.Since(start)))
+			logged = time.Now()
+		}
+		hash := GetCanonicalHash(db, number)
+		if hash == (common.Hash{}) {
+			issue(BlockIssue, number, hash, "canonical hash missing")
+			parent, parentTd = common.Hash{}, nil
+			continue
+		}
+		header := GetHeader(db, hash, number)
+		if header == nil {
+			issue(BlockIssue, number, hash, "header missing")
+			parent, parentTd = hash, nil
+			continue
+		}
+		if header.Hash() != hash || header.Number.Uint64() != number {
+			issue(BlockIssue, number, hash, "header mismatch: hash %x, number %d", header.Hash(), header.Number)
+		}
+		if number > 0 && header.ParentHash != parent {
+			issue(BlockIssue, number, hash, "parent mismatch: have %x, want %x", header.ParentHash, parent)
+		}
+		if n := GetBlockNumber(db, hash); n != number {
+			issue(BlockIssue, number, hash, "number index mismatch: have %d", n)
+		}
+		td := GetTd(db, hash, number)
+		switch {
+		case td == nil:
+			issue(BlockIssue, number, hash, "total difficulty missing")
+		case parentTd != nil && td.Cmp(new(big.Int).Add(parentTd, header.Difficulty)) != 0:
+			issue(BlockIssue, number, hash, "total difficulty mismatch: have %v, want %v", td, new(big.Int).Add(parentTd, header.Difficulty))
+		}
+		parent, parentTd = hash, td
+
+		if number > headBlock {
+			continue
+		}
+		body := GetBody(db, hash, number)
+		if body == nil {
+			issue(BlockIssue, number, hash, "body missing")
+			continue
+		}
+		if root := types.DeriveSha(types.Transactions(body.Transactions)); root != header.TxHash {
+			issue(BlockIssue, number, hash, "transaction root mismatch: have %x, want %x", root, header.TxHash)
+		}
+		if uncles := types.CalcUncleHash(body.Uncles); uncles != header.UncleHash {
+			issue(BlockIssue, number, hash, "uncle hash mismatch: have %x, want %x", uncles, header.UncleHash)
+		}
+		if len(body.Transactions) > 0 && !HasOmittedReceipts(db, hash, number) {
+			receipts := GetBlockReceipts(db, hash, number)
+			switch {
+			case receipts == nil:
+				issue(ReceiptIssue, number, hash, "receipts missing")
+			case types.DeriveSha(receipts) != header.ReceiptHash:
+				issue(ReceiptIssue, number, hash, "receipt root mismatch: have %x, want %x", types.DeriveSha(receipts), header.ReceiptHash)
+			}
+		}
+		if number >= txTail {
+			for i, tx := range body.Transactions {
+				if blockHash, n, index := GetTxLookupEntry(db, tx.Hash()); blockHash != hash || n != number || index != uint64(i) {
+					issue(LookupIssue, number, hash, "lookup entry of transaction %d mismatch: have #%d [%x…] index %d", i, n, blockHash[:4], index)
+				}
+			}
+		}
+		if number == headBlock {
+			if has, _ := db.Has(header.Root[:]); !has {
+				issue(BlockIssue, number, hash, "head state %x missing", header.Root)
+			}
+		}
+	}
+	if len(report.Issues) == 0 {
+		report.Consistent = headHeader
+	}
+	return report, nil
+}
+
+// VerifyDatabase checks the integrity of the chain database, see the function
+// of the same name. With repair set, broken block data is repaired by rewinding
+// the chain with SetHead to the last block with state before the first such
+// issue, broken lookup entries by reindexing their blocks, and broken receipts
+// by re-deriving them where the state allows. Rewinding to the genesis, which
+// fast synced chains would fall back to, or into the freezer is refused.
+func (bc *BlockChain) VerifyDatabase(repair bool) (*IntegrityReport, error) {
+	report, err := VerifyDatabase(bc.chainDb)
+	if err != nil || len(report.Issues) == 0 || !repair {
+		return report, err
+	}
+	for _, issue := range report.Issues {
+		log.Warn("Database inconsistency", "number", issue.Number, "hash", issue.Hash, "problem", issue.Problem)
+	}
+	head := report.HeadBlock
+	for _, issue := range report.Issues {
+		if issue.Kind != BlockIssue {
+			continue
+		}
+		if issue.Number == 0 {
+			return report, fmt.Errorf("genesis block inconsistent, can't repair: %v", issue)
+		}
+		// Rewind to a block with state, so the head block is usable
+		target := issue.Number - 1
+		for ; target > 0; target-- {
+			if header := bc.GetHeaderByNumber(target); header != nil {
+				if has, _ := bc.chainDb.Has(header.Root[:]); has {
+					break
+				}
+			}
+		}
+		if target == 0 && issue.Number > 1 {
+			return report, fmt.Errorf("no block with state before %v, refusing to rewind to genesis", issue)
+		}
+		if fdb, ok := bc.chainDb.(*FreezerDB); ok && target+1 < fdb.Ancients() {
+			return report, fmt.Errorf("block with state #%d before %v is frozen, refusing to rewind the freezer", target, issue)
+		}
+		log.Warn("Rewinding chain", "issue", issue, "target", target)
+		if err := bc.SetHead(target); err != nil {
+			return report, err
+		}
+		head = target
+		break
+	}
+	var reindexFrom, reindexTo uint64 = math.MaxUint64, 0
+	for _, issue := range report.Issues {
+		if issue.Number > head {
+			continue
+		}
+		switch issue.Kind {
+		case LookupIssue:
+			if issue.Number < reindexFrom {
+				reindexFrom = issue.Number
+			}
+			if issue.Number > reindexTo {
+				reindexTo = issue.Number
+			}
+		case ReceiptIssue:
+			block := bc.GetBlock(issue.Hash, issue.Number)
+			if block == nil {
+				return report, fmt.Errorf("block of %v missing", issue)
+			}
+			receipts, err := bc.deriveReceipts(block)
+			if err != nil {
+				log.Warn("Failed to re-derive receipts", "number", issue.Number, "hash", issue.Hash, "err", err)
+				continue
+			}
+			if err := WriteBlockReceipts(bc.chainDb, issue.Hash, issue.Number, receipts); err != nil {
+				return report, err
+			}
+			log.Info("Re-derived block receipts", "number", issue.Number, "hash", issue.Hash)
+		}
+	}
+	if reindexFrom <= reindexTo {
+		log.Info("Reindexing transactions", "from", reindexFrom, "to", reindexTo)
+		return report, bc.ReindexTransactions(reindexFrom, reindexTo)
+	}
+	return report, nil
+}
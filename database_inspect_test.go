@@ -0,0 +1,92 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"math/big"
+	"testing"
+)
+
+// Tests that the database inspection classifies the chain data, and that
+// verification finds inconsistencies and repairs them, rewinding only for
+// broken block data and never to the genesis.
+func TestVerifyDatabase(t *testing.T) {
+	c, err := newTestChain(big.NewInt(1000000000000))
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer c.stop()
+
+	db, blockchain := c.db, c.blockchain
+	chain := c.generate(nil, 16, nil)
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	stats, err := InspectDatabase(db)
+	if err != nil {
+		t.Fatalf("failed to inspect database: %v", err)
+	}
+	counts := make(map[string]uint64)
+	for _, stat := range stats {
+		counts[stat.Kind] = stat.Count
+	}
+	for kind, want := range map[string]uint64{"Headers": 17, "Canonical hashes": 17, "Bodies": 17, "Total difficulties": 17, "Transaction lookups": 16} {
+		if counts[kind] != want {
+			t.Errorf("%s: count mismatch: have %d, want %d", kind, counts[kind], want)
+		}
+	}
+	report, err := blockchain.VerifyDatabase(false)
+	if err != nil {
+		t.Fatalf("failed to verify database: %v", err)
+	}
+	if len(report.Issues) != 0 || report.Consistent != 16 {
+		t.Fatalf("consistent database reported broken: %+v", report)
+	}
+	// Drop receipts and a lookup entry, both are repaired in place
+	DeleteBlockReceipts(db, chain[4].Hash(), 5)
+	DeleteTxLookupEntry(db, chain[11].Transactions()[0].Hash())
+
+	if report, err = blockchain.VerifyDatabase(true); err != nil {
+		t.Fatalf("failed to repair database: %v", err)
+	}
+	if len(report.Issues) != 2 || report.Issues[0].Kind != ReceiptIssue || report.Issues[1].Kind != LookupIssue {
+		t.Fatalf("issues mismatch: %+v", report)
+	}
+	if head := blockchain.CurrentBlock().NumberU64(); head != 16 {
+		t.Errorf("head mismatch after repair: have %d, want 16", head)
+	}
+	if report, err = blockchain.VerifyDatabase(false); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("repaired database inconsistent: %+v (%v)", report, err)
+	}
+	// Drop a body, with no state below it like after a fast sync
+	DeleteBody(db, chain[9].Hash(), 10)
+	blockchain.bodyCache.Purge()
+	blockchain.blockCache.Purge()
+
+	root := chain[8].Root()
+	state, _ := db.Get(root[:])
+	for _, block := range chain[:9] {
+		db.Delete(block.Root().Bytes())
+	}
+	if _, err = blockchain.VerifyDatabase(true); err == nil {
+		t.Fatalf("rewound to the genesis")
+	}
+	if head := blockchain.CurrentBlock().NumberU64(); head != 16 {
+		t.Errorf("head mismatch after refused repair: have %d, want 16", head)
+	}
+	// Restore the state of the block before it
+	db.Put(root[:], state)
+
+	if report, err = blockchain.VerifyDatabase(true); err != nil {
+		t.Fatalf("failed to repair database: %v", err)
+	}
+	if len(report.Issues) != 1 || report.Issues[0].Number != 10 || report.Issues[0].Kind != BlockIssue || report.Consistent != 9 {
+		t.Fatalf("issues mismatch: %+v", report)
+	}
+	if head := blockchain.CurrentBlock().NumberU64(); head != 9 {
+		t.Errorf("head mismatch after repair: have %d, want 9", head)
+	}
+	if report, err = blockchain.VerifyDatabase(false); err != nil || len(report.Issues) != 0 || report.Consistent != 9 {
+		t.Errorf("repaired database inconsistent: %+v (%v)", report, err)
+	}
+}
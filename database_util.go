@@ -48,6 +48,21 @@ var (
 	headBlockKey  = []byte("LastBlock")
 	headFastKey   = []byte("LastFast")
 
+	// txIndexTailKey tracks the oldest block whose transactions are indexed.
+	txIndexTailKey = []byte("TransactionIndexTail")
+
+	// schemaVersionKey tracks the schema version of the database, upgraded by
+	// migrations in place. It is independent of the blockchain version, whose
+	// changes force a resync. migrationMarkerKey tracks the migration in
+	// progress.
+	schemaVersionKey   = []byte("DatabaseSchemaVersion")
+	migrationMarkerKey = []byte("DatabaseMigration")
+
+	// badBlockIndexKey tracks the hashes of the most recent blocks rejected
+	// by the chain, stored under badBlockPrefix.
+	badBlockIndexKey = []byte("InvalidBlockIndex")
+	badBlockPrefix   = []byte("InvalidBlock-") // badBlockPrefix + hash -> bad block
+
 	// Data item prefixes (use single byte to avoid mixing data types, avoid `i`).
 	headerPrefix        = []byte("h") // headerPrefix + num (uint64 big endian) + hash -> header
 	tdSuffix            = []byte("t") // headerPrefix + num (uint64 big endian) + hash + tdSuffix -> td
@@ -55,6 +70,7 @@ var (
 	blockHashPrefix     = []byte("H") // blockHashPrefix + hash -> num (uint64 big endian)
 	bodyPrefix          = []byte("b") // bodyPrefix + num (uint64 big endian) + hash -> block body
 	blockReceiptsPrefix = []byte("r") // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
+	noReceiptsPrefix    = []byte("R") // noReceiptsPrefix + num (uint64 big endian) + hash -> receipts omitted marker
 	lookupPrefix        = []byte("l") // lookupPrefix + hash -> transaction/receipt lookup metadata
 	bloomBitsPrefix     = []byte("B") // bloomBitsPrefix + bit (uint16 big endian) + section (uint64 big endian) + hash -> bloom bits
 
@@ -182,6 +198,14 @@ func blockBodyKey(hash common.Hash, number uint64) []byte {
 	return append(append(bodyPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
 }
 
+func tdKey(hash common.Hash, number uint64) []byte {
+	return append(headerKey(hash, number), tdSuffix...)
+}
+
+func blockReceiptsKey(hash common.Hash, number uint64) []byte {
+	return append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 // GetBody retrieves the block body (transactons, uncles) corresponding to the
 // hash, nil if none found.
 func GetBody(db DatabaseReader, hash common.Hash, number uint64) *types.Body {
@@ -233,7 +257,8 @@ func GetBlock(db DatabaseReader, hash common.Hash, number uint64) *types.Block {
 }
 
 // GetBlockReceipts retrieves the receipts generated by the transactions included
-// in a block given by its hash.
+// in a block given by its hash. The receipts of blocks imported in receiptless
+// mode aren't stored, see HasOmittedReceipts.
 func GetBlockReceipts(db DatabaseReader, hash common.Hash, number uint64) types.Receipts {
 	data, _ := db.Get(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash[:]...))
 	if len(data) == 0 {
@@ -304,12 +329,16 @@ func GetTransaction(db DatabaseReader, hash common.Hash) (*types.Transaction, co
 }
 
 // GetReceipt retrieves a specific transaction receipt from the database, along with
-// its added positional metadata.
+// its added positional metadata. Omitted receipts aren't found, they have to be
+// re-derived through BlockChain.GetReceipt.
 func GetReceipt(db DatabaseReader, hash common.Hash) (*types.Receipt, common.Hash, uint64, uint64) {
 	// Retrieve the lookup metadata and resolve the receipt from the receipts
 	blockHash, blockNumber, receiptIndex := GetTxLookupEntry(db, hash)
 
 	if blockHash != (common.Hash{}) {
+		if HasOmittedReceipts(db, blockHash, blockNumber) {
+			return nil, common.Hash{}, 0, 0
+		}
 		receipts := GetBlockReceipts(db, blockHash, blockNumber)
 		if len(receipts) <= int(receiptIndex) {
 			log.Error("Receipt refereced missing", "number", blockNumber, "hash", blockHash, "index", receiptIndex)
@@ -460,6 +489,23 @@ func WriteBlockReceipts(db ethdb.Putter, hash common.Hash, number uint64, receip
 	return nil
 }
 
+// WriteOmittedReceipts marks the receipts of a block as deliberately not
+// stored, so they are re-derived on demand instead of reported missing.
+func WriteOmittedReceipts(db ethdb.Putter, hash common.Hash, number uint64) error {
+	key := append(append(noReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+	if err := db.Put(key, []byte{1}); err != nil {
+		log.Crit("Failed to store omitted receipts marker", "err", err)
+	}
+	return nil
+}
+
+// HasOmittedReceipts reports whether the receipts of a block were not stored
+// because it was imported in receiptless mode.
+func HasOmittedReceipts(db DatabaseReader, hash common.Hash, number uint64) bool {
+	data, _ := db.Get(append(append(noReceiptsPrefix, encodeBlockNumber(number)...), hash[:]...))
+	return len(data) > 0
+}
+
 // WriteTxLookupEntries stores a positional metadata for every transaction from
 // a block, enabling hash based transaction and receipt lookups.
 func WriteTxLookupEntries(db ethdb.Putter, block *types.Block) error {
@@ -481,6 +527,33 @@ func WriteTxLookupEntries(db ethdb.Putter, block *types.Block) error {
 	return nil
 }
 
+// DeleteTxLookupEntries removes the positional metadata of every transaction
+// from a block.
+func DeleteTxLookupEntries(db DatabaseDeleter, block *types.Block) {
+	for _, tx := range block.Transactions() {
+		DeleteTxLookupEntry(db, tx.Hash())
+	}
+}
+
+// GetTxIndexTail retrieves the number of the oldest block whose transactions
+// are indexed. Databases predating the index tail have all blocks indexed.
+func GetTxIndexTail(db DatabaseReader) uint64 {
+	data, _ := db.Get(txIndexTailKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WriteTxIndexTail stores the number of the oldest block whose transactions
+// are indexed.
+func WriteTxIndexTail(db ethdb.Putter, number uint64) error {
+	if err := db.Put(txIndexTailKey, encodeBlockNumber(number)); err != nil {
+		log.Crit("Failed to store transaction index tail", "err", err)
+	}
+	return nil
+}
+
 // WriteBloomBits writes the compressed bloom bits vector belonging to the given
 // section and bit index.
 func WriteBloomBits(db ethdb.Putter, bit uint, section uint64, head common.Hash, bits []byte) {
@@ -526,6 +599,7 @@ func DeleteBlock(db DatabaseDeleter, hash common.Hash, number uint64) {
 // DeleteBlockReceipts removes all receipt data associated with a block hash.
 func DeleteBlockReceipts(db DatabaseDeleter, hash common.Hash, number uint64) {
 	db.Delete(append(append(blockReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
+	db.Delete(append(append(noReceiptsPrefix, encodeBlockNumber(number)...), hash.Bytes()...))
 }
 
 // DeleteTxLookupEntry removes all transaction data associated with a hash.
@@ -574,6 +648,116 @@ func WriteBlockChainVersion(db ethdb.Putter, vsn int) {
 	db.Put([]byte("BlockchainVersion"), enc)
 }
 
+// GetSchemaVersion reads the schema version of the database, 0 if it predates
+// the schema versioning.
+func GetSchemaVersion(db DatabaseReader) uint64 {
+	data, _ := db.Get(schemaVersionKey)
+	if len(data) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// WriteSchemaVersion stores the schema version of the database.
+func WriteSchemaVersion(db ethdb.Putter, version uint64) error {
+	if err := db.Put(schemaVersionKey, encodeBlockNumber(version)); err != nil {
+		log.Crit("Failed to store database schema version", "err", err)
+	}
+	return nil
+}
+
+// badBlockKey returns the key of the bad block with the given hash.
+func badBlockKey(hash common.Hash) []byte {
+	return append(append([]byte{}, badBlockPrefix...), hash[:]...)
+}
+
+// getBadBlockIndex retrieves the hashes of the persisted bad blocks, the most
+// recent first.
+func getBadBlockIndex(db DatabaseReader) []common.Hash {
+	data, _ := db.Get(badBlockIndexKey)
+	if len(data) == 0 {
+		return nil
+	}
+	var index []common.Hash
+	if err := rlp.DecodeBytes(data, &index); err != nil {
+		log.Error("Invalid bad block index RLP", "err", err)
+		return nil
+	}
+	return index
+}
+
+// GetBadBlocks retrieves the persisted bad blocks, the most recent first.
+func GetBadBlocks(db DatabaseReader) []*BadBlock {
+	var list []*BadBlock
+	for _, hash := range getBadBlockIndex(db) {
+		if bad := GetBadBlock(db, hash); bad != nil {
+			list = append(list, bad)
+		}
+	}
+	return list
+}
+
+// GetBadBlock retrieves the persisted bad block with the given hash.
+func GetBadBlock(db DatabaseReader, hash common.Hash) *BadBlock {
+	data, _ := db.Get(badBlockKey(hash))
+	if len(data) == 0 {
+		return nil
+	}
+	bad := new(BadBlock)
+	if err := rlp.DecodeBytes(data, bad); err != nil {
+		log.Error("Invalid bad block RLP", "hash", hash, "err", err)
+		return nil
+	}
+	return bad
+}
+
+// WriteBadBlock persists a bad block, replacing an earlier record of it and
+// keeping only the most recent badBlockLimit ones.
+func WriteBadBlock(db ethdb.Database, bad *BadBlock) error {
+	hash := bad.Block.Hash()
+	data, err := rlp.EncodeToBytes(bad)
+	if err != nil {
+		return err
+	}
+	var (
+		index   = []common.Hash{hash}
+		dropped []common.Hash
+	)
+	for _, old := range getBadBlockIndex(db) {
+		switch {
+		case old == hash:
+		case len(index) == badBlockLimit:
+			dropped = append(dropped, old)
+		default:
+			index = append(index, old)
+		}
+	}
+	enc, err := rlp.EncodeToBytes(index)
+	if err != nil {
+		return err
+	}
+	// Write the block before indexing it and drop the evicted ones after, so
+	// the index never refers to missing blocks
+	if err := db.Put(badBlockKey(hash), data); err != nil {
+		log.Crit("Failed to store bad block", "err", err)
+	}
+	if err := db.Put(badBlockIndexKey, enc); err != nil {
+		log.Crit("Failed to store bad block index", "err", err)
+	}
+	for _, old := range dropped {
+		db.Delete(badBlockKey(old))
+	}
+	return nil
+}
+
+// DeleteBadBlocks removes all persisted bad blocks.
+func DeleteBadBlocks(db ethdb.Database) {
+	for _, hash := range getBadBlockIndex(db) {
+		db.Delete(badBlockKey(hash))
+	}
+	db.Delete(badBlockIndexKey)
+}
+
 // WriteChainConfig writes the chain config settings to the database.
 func WriteChainConfig(db ethdb.Putter, hash common.Hash, cfg *params.ChainConfig) error {
 	// short circuit and ignore if nil config. GetChainConfig
@@ -387,3 +387,49 @@ func TestBlockReceiptStorage(t *testing.T) {
 		t.Fatalf("deleted receipts returned: %v", rs)
 	}
 }
+
+// Tests that bad blocks are stored individually, the most recent first, and
+// that only the most recent badBlockLimit ones are kept.
+func TestBadBlockStorage(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+
+	var blocks []*types.Block
+	for i := 0; i < badBlockLimit+2; i++ {
+		block := types.NewBlockWithHeader(&types.Header{Number: big.NewInt(int64(i)), Extra: []byte("bad block")})
+		if err := WriteBadBlock(db, &BadBlock{Block: block, Error: "invalid"}); err != nil {
+			t.Fatalf("block %d: failed to write bad block: %v", i, err)
+		}
+		blocks = append(blocks, block)
+	}
+	// Rewriting a kept block moves it to the front
+	if err := WriteBadBlock(db, &BadBlock{Block: blocks[5], Error: "invalid again"}); err != nil {
+		t.Fatalf("failed to rewrite bad block: %v", err)
+	}
+	want := []*types.Block{blocks[5]}
+	for i := len(blocks) - 1; len(want) < badBlockLimit; i-- {
+		if i != 5 {
+			want = append(want, blocks[i])
+		}
+	}
+	list := GetBadBlocks(db)
+	if len(list) != len(want) {
+		t.Fatalf("bad block count mismatch: have %d, want %d", len(list), len(want))
+	}
+	for i, bad := range list {
+		if bad.Block.Hash() != want[i].Hash() {
+			t.Errorf("bad block %d: hash mismatch: have %x, want %x", i, bad.Block.Hash(), want[i].Hash())
+		}
+	}
+	if bad := GetBadBlock(db, blocks[5].Hash()); bad == nil || bad.Error != "invalid again" {
+		t.Errorf("rewritten bad block mismatch: have %+v", bad)
+	}
+	for _, block := range blocks[:2] {
+		if has, _ := db.Has(badBlockKey(block.Hash())); has {
+			t.Errorf("evicted bad block %d retained", block.NumberU64())
+		}
+	}
+	DeleteBadBlocks(db)
+	if keys := len(db.Keys()); keys != 0 {
+		t.Errorf("%d entries left after deleting bad blocks", keys)
+	}
+}
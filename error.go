@@ -32,4 +32,12 @@ var (
 	// ErrNonceTooHigh is returned if the nonce of a transaction is higher than the
 	// next one expected based on the local chain.
 	ErrNonceTooHigh = errors.New("nonce too high")
+
+	// ErrTxExpired is returned if a transaction is included in or submitted for
+	// a block past its valid-until block number.
+	ErrTxExpired = errors.New("transaction expired")
+
+	// ErrPrivacyTxEnvelope is returned if a privacy transaction carries a
+	// sponsored or multi-signature payload, neither of which applies to it.
+	ErrPrivacyTxEnvelope = errors.New("privacy transaction with sponsored or multi-signature payload")
 )
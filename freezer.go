@@ -0,0 +1,308 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"sync"
+	"sync/atomic"
+
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+)
+
+const (
+	// DefaultFreezerThreshold is the number of recent blocks kept in the
+	// key-value store, older canonical blocks are moved to the freezer.
+	DefaultFreezerThreshold = 90000
+
+	// freezerBatchLimit is the maximum number of blocks frozen at once.
+	freezerBatchLimit = 2048
+)
+
+// Tables of the freezer, holding an item per canonical block.
+const (
+	freezerHashTable       = "hashes"
+	freezerHeaderTable     = "headers"
+	freezerBodiesTable     = "bodies"
+	freezerReceiptTable    = "receipts"
+	freezerDifficultyTable = "diffs"
+)
+
+var freezerTables = []string{freezerHashTable, freezerHeaderTable, freezerBodiesTable, freezerReceiptTable, freezerDifficultyTable}
+
+// Freezer stores old canonical blocks in append-only flat files, sparing the
+// key-value store the compaction of data that never changes.
+type Freezer struct {
+	frozen uint64     // Number of blocks frozen, accessed atomically
+	lock   sync.Mutex // Serializes appends and truncations
+	tables map[string]*freezerTable
+}
+
+// NewFreezer opens the freezer in the given directory, creating it if needed.
+func NewFreezer(dir string) (*Freezer, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f := &Freezer{tables: make(map[string]*freezerTable)}
+	for _, name := range freezerTables {
+		table, err := openFreezerTable(dir, name)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		f.tables[name] = table
+	}
+	// Drop the blocks not written to all tables before a crash
+	frozen := f.tables[freezerHashTable].items
+	for _, table := range f.tables {
+		if table.items < frozen {
+			frozen = table.items
+		}
+	}
+	if err := f.truncate(frozen); err != nil {
+		f.Close()
+		return nil, err
+	}
+	log.Info("Opened block freezer", "dir", dir, "blocks", frozen)
+	return f, nil
+}
+
+// Ancients returns the number of frozen blocks.
+func (f *Freezer) Ancients() uint64 {
+	return atomic.LoadUint64(&f.frozen)
+}
+
+// Ancient returns the item of the frozen block with the given number from
+// the table of the given kind.
+func (f *Freezer) Ancient(kind string, number uint64) ([]byte, error) {
+	if number >= f.Ancients() {
+		return nil, errOutOfBounds
+	}
+	return f.tables[kind].retrieve(number)
+}
+
+// Append freezes the block with the given number, which must be the next
+// one, given its hash and the raw encodings of its header, body, receipts and
+// total difficulty.
+func (f *Freezer) Append(number uint64, hash common.Hash, header, body, receipts, td []byte) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if number != f.Ancients() {
+		return errOutOrder
+	}
+	items := map[string][]byte{
+		freezerHashTable:       hash[:],
+		freezerHeaderTable:     header,
+		freezerBodiesTable:     body,
+		freezerReceiptTable:    receipts,
+		freezerDifficultyTable: td,
+	}
+	for _, name := range freezerTables {
+		if err := f.tables[name].append(number, items[name]); err != nil {
+			f.truncate(number)
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, number+1)
+	return nil
+}
+
+// Truncate drops the frozen blocks from the given number on.
+func (f *Freezer) Truncate(items uint64) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	return f.truncate(items)
+}
+
+func (f *Freezer) truncate(items uint64) error {
+	if items < f.Ancients() {
+		atomic.StoreUint64(&f.frozen, items)
+	}
+	for _, table := range f.tables {
+		if err := table.truncate(items); err != nil {
+			return err
+		}
+	}
+	atomic.StoreUint64(&f.frozen, items)
+	return nil
+}
+
+// size returns the total size of the frozen data.
+func (f *Freezer) size() uint64 {
+	var size uint64
+	for _, table := range f.tables {
+		table.lock.RLock()
+		size += table.size + table.items*8
+		table.lock.RUnlock()
+	}
+	return size
+}
+
+// Sync flushes the freezer to disk.
+func (f *Freezer) Sync() error {
+	for _, table := range f.tables {
+		if err := table.sync(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the files of the freezer.
+func (f *Freezer) Close() error {
+	var errs []error
+	for _, table := range f.tables {
+		if err := table.close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+// FreezerDB is a chain database whose old canonical headers, bodies,
+// receipts and total difficulties are moved to a freezer. Reads of them are
+// served from the freezer transparently, so the accessors in
+// database_util.go work unchanged.
+type FreezerDB struct {
+	ethdb.Database
+	freezer   *Freezer
+	threshold uint64 // Number of recent blocks not frozen
+}
+
+// NewFreezerDB creates a chain database on the key-value store, freezing
+// canonical blocks older than threshold blocks into the freezer.
+func NewFreezerDB(db ethdb.Database, freezer *Freezer, threshold uint64) *FreezerDB {
+	return &FreezerDB{Database: db, freezer: freezer, threshold: threshold}
+}
+
+// KeyValueStore returns the key-value store of the database.
+func (db *FreezerDB) KeyValueStore() ethdb.Database {
+	return db.Database
+}
+
+// Ancients returns the number of frozen blocks.
+func (db *FreezerDB) Ancients() uint64 {
+	return db.freezer.Ancients()
+}
+
+// ancientKey returns the freezer table and block number holding the value of
+// the key, if it is a frozen one.
+func (db *FreezerDB) ancientKey(key []byte) (string, uint64, bool) {
+	var kind string
+	switch {
+	case len(key) == len(headerPrefix)+8+common.HashLength && bytes.HasPrefix(key, headerPrefix):
+		kind = freezerHeaderTable
+	case len(key) == len(headerPrefix)+8+common.HashLength+len(tdSuffix) && bytes.HasPrefix(key, headerPrefix) && bytes.HasSuffix(key, tdSuffix):
+		kind = freezerDifficultyTable
+	case len(key) == len(bodyPrefix)+8+common.HashLength && bytes.HasPrefix(key, bodyPrefix):
+		kind = freezerBodiesTable
+	case len(key) == len(blockReceiptsPrefix)+8+common.HashLength && bytes.HasPrefix(key, blockReceiptsPrefix):
+		kind = freezerReceiptTable
+	default:
+		return "", 0, false
+	}
+	number := binary.BigEndian.Uint64(key[1:9])
+	if number >= db.freezer.Ancients() {
+		return "", 0, false
+	}
+	// Side chain blocks of frozen numbers stay in the key-value store
+	hash, err := db.freezer.Ancient(freezerHashTable, number)
+	if err != nil || !bytes.Equal(hash, key[9:9+common.HashLength]) {
+		return "", 0, false
+	}
+	return kind, number, true
+}
+
+func (db *FreezerDB) Get(key []byte) ([]byte, error) {
+	if kind, number, ok := db.ancientKey(key); ok {
+		if data, err := db.freezer.Ancient(kind, number); err == nil {
+			return data, nil
+		}
+	}
+	return db.Database.Get(key)
+}
+
+func (db *FreezerDB) Has(key []byte) (bool, error) {
+	if _, _, ok := db.ancientKey(key); ok {
+		return true, nil
+	}
+	return db.Database.Has(key)
+}
+
+// Freeze moves a batch of canonical blocks older than the threshold from the
+// key-value store into the freezer, returning the number of blocks frozen.
+// Blocks missing their body, e.g. during fast sync, stop the freezing.
+func (db *FreezerDB) Freeze() (int, error) {
+	head := GetBlockNumber(db.Database, GetHeadBlockHash(db.Database))
+	if head == missingNumber || head < db.threshold {
+		return 0, nil
+	}
+	var (
+		first = db.freezer.Ancients()
+		limit = head - db.threshold
+	)
+	if limit > first+freezerBatchLimit {
+		limit = first + freezerBatchLimit
+	}
+	var hashes []common.Hash
+	for number := first; number < limit; number++ {
+		hash := GetCanonicalHash(db.Database, number)
+		if hash == (common.Hash{}) {
+			break
+		}
+		header, _ := db.Database.Get(headerKey(hash, number))
+		body, _ := db.Database.Get(blockBodyKey(hash, number))
+		td, _ := db.Database.Get(tdKey(hash, number))
+		if len(header) == 0 || len(body) == 0 || len(td) == 0 {
+			break
+		}
+		receipts, _ := db.Database.Get(blockReceiptsKey(hash, number))
+		if err := db.freezer.Append(number, hash, header, body, receipts, td); err != nil {
+			return len(hashes), err
+		}
+		hashes = append(hashes, hash)
+	}
+	if len(hashes) == 0 {
+		return 0, nil
+	}
+	if err := db.freezer.Sync(); err != nil {
+		return 0, err
+	}
+	// The blocks are safe in the freezer, drop them from the key-value store
+	for i, hash := range hashes {
+		number := first + uint64(i)
+		for _, key := range [][]byte{headerKey(hash, number), tdKey(hash, number), blockBodyKey(hash, number), blockReceiptsKey(hash, number)} {
+			if err := db.Database.Delete(key); err != nil {
+				return len(hashes), err
+			}
+		}
+	}
+	log.Debug("Froze ancient blocks", "first", first, "count", len(hashes))
+	return len(hashes), nil
+}
+
+// TruncateAncients drops the frozen blocks from the given number on.
+func (db *FreezerDB) TruncateAncients(items uint64) error {
+	if items >= db.freezer.Ancients() {
+		return nil
+	}
+	log.Warn("Truncating ancient blocks", "from", items, "frozen", db.freezer.Ancients())
+	return db.freezer.Truncate(items)
+}
+
+// Close closes the freezer and the key-value store.
+func (db *FreezerDB) Close() {
+	if err := db.freezer.Close(); err != nil {
+		log.Error("Failed to close block freezer", "err", err)
+	}
+	db.Database.Close()
+}
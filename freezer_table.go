@@ -0,0 +1,172 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	// errOutOfBounds is returned if an item is not in a freezer table.
+	errOutOfBounds = errors.New("out of bounds")
+
+	// errOutOrder is returned if an item isn't appended right after the last.
+	errOutOrder = errors.New("appended item out of order")
+)
+
+// freezerTable is an append-only flat file of items, numbered from zero. The
+// data file holds the items back to back, the index file the end offset of
+// each item in the data file as a big endian uint64.
+type freezerTable struct {
+	lock  sync.RWMutex
+	data  *os.File
+	index *os.File
+	items uint64 // Number of items in the table
+	size  uint64 // Size of the data file
+}
+
+// openFreezerTable opens the table of the given name in the directory,
+// dropping any partially written item left by a crash.
+func openFreezerTable(dir, name string) (*freezerTable, error) {
+	data, err := os.OpenFile(filepath.Join(dir, name+".dat"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	index, err := os.OpenFile(filepath.Join(dir, name+".idx"), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	t := &freezerTable{data: data, index: index}
+	if err := t.repair(); err != nil {
+		t.close()
+		return nil, fmt.Errorf("freezer table %s: %v", name, err)
+	}
+	return t, nil
+}
+
+// repair makes the index and data files consistent, truncating the items
+// whose index entry or data is incomplete.
+func (t *freezerTable) repair() error {
+	stat, err := t.index.Stat()
+	if err != nil {
+		return err
+	}
+	items := uint64(stat.Size()) / 8
+	if stat, err = t.data.Stat(); err != nil {
+		return err
+	}
+	size := uint64(stat.Size())
+
+	for ; items > 0; items-- {
+		end, err := t.offset(items)
+		if err != nil {
+			return err
+		}
+		if end <= size {
+			break
+		}
+	}
+	t.items = items
+	return t.truncate(items)
+}
+
+// offset returns the end offset of the item before the given one in the
+// data file, which is the start offset of the given one.
+func (t *freezerTable) offset(item uint64) (uint64, error) {
+	if item == 0 {
+		return 0, nil
+	}
+	var buf [8]byte
+	if _, err := t.index.ReadAt(buf[:], int64(item-1)*8); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
+// append adds the item, which must be numbered right after the last one.
+func (t *freezerTable) append(item uint64, blob []byte) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if item != t.items {
+		return errOutOrder
+	}
+	if _, err := t.data.WriteAt(blob, int64(t.size)); err != nil {
+		return err
+	}
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], t.size+uint64(len(blob)))
+	if _, err := t.index.WriteAt(buf[:], int64(item)*8); err != nil {
+		return err
+	}
+	t.items++
+	t.size += uint64(len(blob))
+	return nil
+}
+
+// retrieve returns the item with the given number.
+func (t *freezerTable) retrieve(item uint64) ([]byte, error) {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	if item >= t.items {
+		return nil, errOutOfBounds
+	}
+	start, err := t.offset(item)
+	if err != nil {
+		return nil, err
+	}
+	end, err := t.offset(item + 1)
+	if err != nil {
+		return nil, err
+	}
+	blob := make([]byte, end-start)
+	if _, err := t.data.ReadAt(blob, int64(start)); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// truncate drops the items from the given number on.
+func (t *freezerTable) truncate(items uint64) error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if items > t.items {
+		return nil
+	}
+	size, err := t.offset(items)
+	if err != nil {
+		return err
+	}
+	if err := t.index.Truncate(int64(items) * 8); err != nil {
+		return err
+	}
+	if err := t.data.Truncate(int64(size)); err != nil {
+		return err
+	}
+	t.items, t.size = items, size
+	return nil
+}
+
+// sync flushes the table to disk.
+func (t *freezerTable) sync() error {
+	if err := t.data.Sync(); err != nil {
+		return err
+	}
+	return t.index.Sync()
+}
+
+func (t *freezerTable) close() error {
+	errData, errIndex := t.data.Close(), t.index.Close()
+	if errData != nil {
+		return errData
+	}
+	return errIndex
+}
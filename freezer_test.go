@@ -0,0 +1,141 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/consensus/ethash"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/params"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+// Tests that frozen blocks are read transparently, survive a restart and are
+// truncated on rewinds.
+func TestFreezer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	freezer, err := NewFreezer(dir)
+	if err != nil {
+		t.Fatalf("failed to open freezer: %v", err)
+	}
+	kv, _ := ethdb.NewMemDatabase()
+	db := NewFreezerDB(kv, freezer, 8)
+
+	gspec := DefaultPPOWTestingGenesisBlock()
+	genesis := gspec.MustCommit(db)
+	engine := ethash.NewFaker(db)
+	blockchain, _ := NewBlockChain(db, params.TestChainConfig, engine, vm.Config{})
+	defer blockchain.Stop()
+
+	chainEnv := NewChainEnv(params.TestChainConfig, gspec, engine, blockchain, db)
+	if _, err := blockchain.InsertChain(chainEnv.makeBlockChain(genesis, 32, canonicalSeed)); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	type entry struct {
+		hash     common.Hash
+		txs      common.Hash
+		receipts types.Receipts
+		td       *big.Int
+	}
+	want := make([]entry, 33)
+	for number := range want {
+		block := blockchain.GetBlockByNumber(uint64(number))
+		want[number] = entry{block.Hash(), types.DeriveSha(block.Transactions()), GetBlockReceipts(db, block.Hash(), block.NumberU64()), GetTd(db, block.Hash(), block.NumberU64())}
+	}
+	blockchain.freeze()
+	if frozen := db.Ancients(); frozen != 32-8 {
+		t.Fatalf("frozen block count mismatch: have %d, want %d", frozen, 32-8)
+	}
+	for number := range want {
+		hash := GetCanonicalHash(db, uint64(number))
+		if number < 24 {
+			if has, _ := kv.Has(headerKey(hash, uint64(number))); has {
+				t.Errorf("block %d: header retained in key-value store", number)
+			}
+		}
+		block := GetBlock(db, hash, uint64(number))
+		if block == nil {
+			t.Fatalf("block %d: missing", number)
+		}
+		have := entry{block.Hash(), types.DeriveSha(block.Transactions()), GetBlockReceipts(db, hash, uint64(number)), GetTd(db, hash, uint64(number))}
+		if !reflect.DeepEqual(have, want[number]) {
+			t.Errorf("block %d: mismatch: have %+v, want %+v", number, have, want[number])
+		}
+	}
+	// Rewind into the frozen blocks
+	if err := blockchain.SetHead(10); err != nil {
+		t.Fatalf("failed to rewind: %v", err)
+	}
+	if frozen := db.Ancients(); frozen != 11 {
+		t.Errorf("frozen block count mismatch after rewind: have %d, want 11", frozen)
+	}
+	if block := blockchain.GetBlockByNumber(10); block == nil {
+		t.Errorf("head block missing after rewind")
+	}
+	// Frozen blocks must survive a restart
+	freezer.Close()
+	if freezer, err = NewFreezer(dir); err != nil {
+		t.Fatalf("failed to reopen freezer: %v", err)
+	}
+	defer freezer.Close()
+	if frozen := freezer.Ancients(); frozen != 11 {
+		t.Errorf("frozen block count mismatch after restart: have %d, want 11", frozen)
+	}
+}
+
+// Tests that partially written items are dropped when a table is reopened.
+func TestFreezerTableRepair(t *testing.T) {
+	dir, err := ioutil.TempDir("", "freezer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	table, err := openFreezerTable(dir, "test")
+	if err != nil {
+		t.Fatalf("failed to open table: %v", err)
+	}
+	for i := byte(0); i < 4; i++ {
+		if err := table.append(uint64(i), []byte{i, i, i}); err != nil {
+			t.Fatalf("failed to append item %d: %v", i, err)
+		}
+	}
+	if err := table.append(5, nil); err != errOutOrder {
+		t.Errorf("out of order append error mismatch: have %v, want %v", err, errOutOrder)
+	}
+	table.close()
+
+	// Cut the data of the last item
+	if err := os.Truncate(filepath.Join(dir, "test.dat"), 11); err != nil {
+		t.Fatal(err)
+	}
+	if table, err = openFreezerTable(dir, "test"); err != nil {
+		t.Fatalf("failed to reopen table: %v", err)
+	}
+	defer table.close()
+
+	if table.items != 3 {
+		t.Errorf("item count mismatch: have %d, want 3", table.items)
+	}
+	for i := byte(0); i < 3; i++ {
+		if blob, err := table.retrieve(uint64(i)); err != nil || !reflect.DeepEqual(blob, []byte{i, i, i}) {
+			t.Errorf("item %d: mismatch: have %x (%v)", i, blob, err)
+		}
+	}
+	if _, err := table.retrieve(3); err != errOutOfBounds {
+		t.Errorf("truncated item error mismatch: have %v, want %v", err, errOutOfBounds)
+	}
+}
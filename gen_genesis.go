@@ -24,6 +24,9 @@ func (g Genesis) MarshalJSON() ([]byte, error) {
 		Mixhash    common.Hash                                 `json:"mixHash"`
 		Coinbase   common.Address                              `json:"coinbase"`
 		Alloc      map[common.UnprefixedAddress]GenesisAccount `json:"alloc"      gencodec:"required"`
+		Signers    []common.Address                            `json:"signers,omitempty"`
+		OTANotes   []GenesisOTANote                            `json:"otaNotes,omitempty"`
+		Contracts  []GenesisContract                           `json:"contracts,omitempty"`
 		Number     math.HexOrDecimal64                         `json:"number"`
 		GasUsed    math.HexOrDecimal64                         `json:"gasUsed"`
 		ParentHash common.Hash                                 `json:"parentHash"`
@@ -43,6 +46,9 @@ func (g Genesis) MarshalJSON() ([]byte, error) {
 			enc.Alloc[common.UnprefixedAddress(k)] = v
 		}
 	}
+	enc.Signers = g.Signers
+	enc.OTANotes = g.OTANotes
+	enc.Contracts = g.Contracts
 	enc.Number = math.HexOrDecimal64(g.Number)
 	enc.GasUsed = math.HexOrDecimal64(g.GasUsed)
 	enc.ParentHash = g.ParentHash
@@ -60,6 +66,9 @@ func (g *Genesis) UnmarshalJSON(input []byte) error {
 		Mixhash    *common.Hash                                `json:"mixHash"`
 		Coinbase   *common.Address                             `json:"coinbase"`
 		Alloc      map[common.UnprefixedAddress]GenesisAccount `json:"alloc"      gencodec:"required"`
+		Signers    []common.Address                            `json:"signers,omitempty"`
+		OTANotes   []GenesisOTANote                            `json:"otaNotes,omitempty"`
+		Contracts  []GenesisContract                           `json:"contracts,omitempty"`
 		Number     *math.HexOrDecimal64                        `json:"number"`
 		GasUsed    *math.HexOrDecimal64                        `json:"gasUsed"`
 		ParentHash *common.Hash                                `json:"parentHash"`
@@ -101,6 +110,15 @@ func (g *Genesis) UnmarshalJSON(input []byte) error {
 	for k, v := range dec.Alloc {
 		g.Alloc[common.Address(k)] = v
 	}
+	if dec.Signers != nil {
+		g.Signers = dec.Signers
+	}
+	if dec.OTANotes != nil {
+		g.OTANotes = dec.OTANotes
+	}
+	if dec.Contracts != nil {
+		g.Contracts = dec.Contracts
+	}
 	if dec.Number != nil {
 		g.Number = uint64(*dec.Number)
 	}
@@ -24,6 +24,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"reflect"
 	"strings"
 
 	"github.com/combchain/combchain/crypto"
@@ -36,10 +37,13 @@ import (
 	"github.com/combchain/go-combchain/rlp"
 	"github.com/combchain/go-combchain/state"
 	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
 )
 
 //go:generate gencodec -type Genesis -field-override genesisSpecMarshaling -out gen_genesis.go
 //go:generate gencodec -type GenesisAccount -field-override genesisAccountMarshaling -out gen_genesis_account.go
+//go:generate gencodec -type GenesisOTANote -field-override genesisOTANoteMarshaling -out gen_genesis_ota_note.go
+//go:generate gencodec -type GenesisContract -field-override genesisContractMarshaling -out gen_genesis_contract.go
 
 var errGenesisNoConfig = errors.New("genesis has no chain configuration")
 
@@ -56,6 +60,10 @@ type Genesis struct {
 	Coinbase   common.Address      `json:"coinbase"`
 	Alloc      GenesisAlloc        `json:"alloc"      gencodec:"required"`
 
+	Signers   []common.Address  `json:"signers,omitempty"`   // PPOW signers, the extra data if none is given
+	OTANotes  []GenesisOTANote  `json:"otaNotes,omitempty"`  // OTA notes minted after the allocation
+	Contracts []GenesisContract `json:"contracts,omitempty"` // Contracts deployed after the OTA notes, in order
+
 	// These fields are used for consensus tests. Please don't use them
 	// in actual genesis blocks.
 	Number     uint64      `json:"number"`
@@ -87,6 +95,23 @@ type GenesisAccount struct {
 	PrivateKey []byte                      `json:"secretKey,omitempty"` // for tests
 }
 
+// GenesisOTANote is a one-time address note minted in the genesis state, in a
+// combcoin or stamp denomination.
+type GenesisOTANote struct {
+	Address []byte   `json:"address" gencodec:"required"` // One-time combAddr
+	Value   *big.Int `json:"value"   gencodec:"required"`
+}
+
+// GenesisContract is a contract deployed in the genesis state by running its
+// constructor.
+type GenesisContract struct {
+	Address     common.Address `json:"address"     gencodec:"required"` // Address the deployment must yield
+	Deployer    common.Address `json:"deployer"`                        // Creator, the address derives from its nonce
+	Constructor []byte         `json:"constructor" gencodec:"required"` // Init code with the encoded constructor arguments
+	Value       *big.Int       `json:"value,omitempty"`                 // Endowment, taken from the deployer
+	Gas         uint64         `json:"gas,omitempty"`                   // Gas of the constructor, the block gas limit if 0
+}
+
 // field type overrides for gencodec
 type genesisSpecMarshaling struct {
 	Nonce      math.HexOrDecimal64
@@ -107,6 +132,17 @@ type genesisAccountMarshaling struct {
 	PrivateKey hexutil.Bytes
 }
 
+type genesisOTANoteMarshaling struct {
+	Address hexutil.Bytes
+	Value   *math.HexOrDecimal256
+}
+
+type genesisContractMarshaling struct {
+	Constructor hexutil.Bytes
+	Value       *math.HexOrDecimal256
+	Gas         math.HexOrDecimal64
+}
+
 // storageJSON represents a 256 bit byte array, but allows less than 256 bits when
 // unmarshaling from hex.
 type storageJSON common.Hash
@@ -156,6 +192,12 @@ func SetupGenesisBlock(db ethdb.Database, genesis *Genesis) (*params.ChainConfig
 	if genesis != nil && genesis.Config == nil {
 		return params.AllProtocolChanges, common.Hash{}, errGenesisNoConfig
 	}
+	// The rest of the specification is checked as the block is built below
+	if genesis != nil {
+		if err := genesis.checkSpec(); err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
+	}
 
 	// Just commit the new block if there is no stored genesis block.
 	stored := GetCanonicalHash(db, 0)
@@ -170,12 +212,18 @@ func SetupGenesisBlock(db ethdb.Database, genesis *Genesis) (*params.ChainConfig
 			log.Info("Writing custom genesis block")
 		}
 		block, err := genesis.Commit(db)
-		return genesis.Config, block.Hash(), err
+		if err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
+		return genesis.Config, block.Hash(), nil
 	}
 
 	// Check whether the genesis block is already written.
 	if genesis != nil {
-		block, _ := genesis.ToBlock()
+		block, _, err := genesis.toBlock()
+		if err != nil {
+			return genesis.Config, common.Hash{}, err
+		}
 		hash := block.Hash()
 		if hash != stored {
 			return genesis.Config, block.Hash(), &GenesisMismatchError{stored, hash}
@@ -231,8 +279,130 @@ func (g *Genesis) configOrDefault(ghash common.Hash) *params.ChainConfig {
 	}
 }
 
-// ToBlock creates the block and state of a genesis specification.
+// Validate checks the genesis specification: the order of the fork blocks of
+// the chain configuration, the signers, the OTA notes and the deployment of
+// the contracts.
+func (g *Genesis) Validate() error {
+	_, err := g.validate()
+	return err
+}
+
+// validate checks the genesis specification, returning the genesis block
+// built to check the contracts.
+func (g *Genesis) validate() (*types.Block, error) {
+	if err := g.checkSpec(); err != nil {
+		return nil, err
+	}
+	// The contracts are only known good once deployed
+	block, _, err := g.toBlock()
+	return block, err
+}
+
+// checkSpec checks the parts of the genesis specification that don't need
+// the genesis block to be built.
+func (g *Genesis) checkSpec() error {
+	if g.Config != nil {
+		if err := checkForkOrder(g.Config); err != nil {
+			return err
+		}
+	}
+	seen := make(map[common.Address]bool)
+	for _, signer := range g.Signers {
+		if signer == (common.Address{}) {
+			return errors.New("genesis signer is the zero address")
+		}
+		if seen[signer] {
+			return fmt.Errorf("duplicate genesis signer %x", signer)
+		}
+		seen[signer] = true
+	}
+	if len(g.Signers) > 0 && len(g.ExtraData) > 0 && !bytes.Equal(g.ExtraData, signersExtra(g.Signers)) {
+		return errors.New("genesis extra data conflicts with signers")
+	}
+	notes := make(map[string]bool)
+	for i, note := range g.OTANotes {
+		if len(note.Address) != common.WAddressLength {
+			return fmt.Errorf("genesis OTA note %d: invalid address length %d", i, len(note.Address))
+		}
+		if !vm.IsOTADenomination(note.Value) {
+			return fmt.Errorf("genesis OTA note %d: value %v is no denomination", i, note.Value)
+		}
+		ax, _ := vm.GetAXFromcombAddr(note.Address)
+		if notes[string(ax)] {
+			return fmt.Errorf("genesis OTA note %d: duplicate", i)
+		}
+		notes[string(ax)] = true
+	}
+	for i, contract := range g.Contracts {
+		if len(contract.Constructor) == 0 {
+			return fmt.Errorf("genesis contract %d: constructor missing", i)
+		}
+	}
+	return nil
+}
+
+// checkForkOrder checks that the fork blocks of a chain configuration are
+// non-negative and activate in the order they are declared in. The DAO fork
+// is independent of the others.
+func checkForkOrder(config *params.ChainConfig) error {
+	var (
+		v        = reflect.ValueOf(config).Elem()
+		lastName string
+		last     *big.Int
+	)
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Name
+		if !strings.HasSuffix(name, "Block") || name == "DAOForkBlock" || !v.Field(i).CanInterface() {
+			continue
+		}
+		block, ok := v.Field(i).Interface().(*big.Int)
+		if !ok || block == nil {
+			continue
+		}
+		if block.Sign() < 0 {
+			return fmt.Errorf("invalid chain configuration: %s at negative block %v", name, block)
+		}
+		if last != nil && block.Cmp(last) < 0 {
+			return fmt.Errorf("invalid chain configuration: %s at block %v before %s at block %v", name, block, lastName, last)
+		}
+		lastName, last = name, block
+	}
+	return nil
+}
+
+// signersExtra returns the genesis extra data listing the PPOW signers.
+func signersExtra(signers []common.Address) []byte {
+	extra := make([]byte, 0, len(signers)*common.AddressLength)
+	for _, signer := range signers {
+		extra = append(extra, signer[:]...)
+	}
+	return extra
+}
+
+// SignerList returns the PPOW signers of the genesis, listed in its extra
+// data if set and in Signers otherwise, as the genesis block is built.
+func (g *Genesis) SignerList() []common.Address {
+	if len(g.ExtraData) == 0 {
+		return g.Signers
+	}
+	signers := make([]common.Address, len(g.ExtraData)/common.AddressLength)
+	for i := range signers {
+		copy(signers[i][:], g.ExtraData[i*common.AddressLength:])
+	}
+	return signers
+}
+
+// ToBlock creates the block and state of a genesis specification. It panics
+// if the specification is invalid, see Validate.
 func (g *Genesis) ToBlock() (*types.Block, *state.StateDB) {
+	block, statedb, err := g.toBlock()
+	if err != nil {
+		panic(err)
+	}
+	return block, statedb
+}
+
+func (g *Genesis) toBlock() (*types.Block, *state.StateDB, error) {
 	db, _ := ethdb.NewMemDatabase()
 	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
 	for addr, account := range g.Alloc {
@@ -243,19 +413,26 @@ func (g *Genesis) ToBlock() (*types.Block, *state.StateDB) {
 			statedb.SetState(addr, key, value)
 		}
 	}
-	root := statedb.IntermediateRoot(false)
+	for i, note := range g.OTANotes {
+		if _, err := vm.AddOTAIfNotExist(statedb, note.Value, note.Address); err != nil {
+			return nil, nil, fmt.Errorf("genesis OTA note %d: %v", i, err)
+		}
+	}
+	extra := g.ExtraData
+	if len(extra) == 0 && len(g.Signers) > 0 {
+		extra = signersExtra(g.Signers)
+	}
 	head := &types.Header{
 		Number:     new(big.Int).SetUint64(g.Number),
 		Nonce:      types.EncodeNonce(g.Nonce),
 		Time:       new(big.Int).SetUint64(g.Timestamp),
 		ParentHash: g.ParentHash,
-		Extra:      g.ExtraData,
+		Extra:      extra,
 		GasLimit:   new(big.Int).SetUint64(g.GasLimit),
 		GasUsed:    new(big.Int).SetUint64(g.GasUsed),
 		Difficulty: g.Difficulty,
 		MixDigest:  g.Mixhash,
 		Coinbase:   g.Coinbase,
-		Root:       root,
 	}
 	if g.GasLimit == 0 {
 		head.GasLimit = params.GenesisGasLimit
@@ -263,13 +440,61 @@ func (g *Genesis) ToBlock() (*types.Block, *state.StateDB) {
 	if g.Difficulty == nil {
 		head.Difficulty = params.GenesisDifficulty
 	}
-	return types.NewBlock(head, nil, nil, nil), statedb
+	if err := g.deployContracts(statedb, head); err != nil {
+		return nil, nil, err
+	}
+	head.Root = statedb.IntermediateRoot(false)
+	return types.NewBlock(head, nil, nil, nil), statedb, nil
+}
+
+// deployContracts runs the constructors of the genesis contracts.
+func (g *Genesis) deployContracts(statedb *state.StateDB, head *types.Header) error {
+	if len(g.Contracts) == 0 {
+		return nil
+	}
+	config := g.Config
+	if config == nil {
+		config = params.AllProtocolChanges
+	}
+	for i, contract := range g.Contracts {
+		context := vm.Context{
+			CanTransfer: CanTransfer,
+			Transfer:    Transfer,
+			GetHash:     func(uint64) common.Hash { return common.Hash{} },
+			Origin:      contract.Deployer,
+			GasPrice:    new(big.Int),
+			Coinbase:    head.Coinbase,
+			GasLimit:    new(big.Int).Set(head.GasLimit),
+			BlockNumber: new(big.Int).Set(head.Number),
+			Time:        new(big.Int).Set(head.Time),
+			Difficulty:  new(big.Int).Set(head.Difficulty),
+		}
+		gas, value := contract.Gas, contract.Value
+		if gas == 0 {
+			gas = head.GasLimit.Uint64()
+		}
+		if value == nil {
+			value = new(big.Int)
+		}
+		evm := vm.NewEVM(context, statedb, config, vm.Config{})
+		_, addr, _, err := evm.Create(vm.AccountRef(contract.Deployer), contract.Constructor, gas, value)
+		if err != nil {
+			return fmt.Errorf("genesis contract %d: constructor failed: %v", i, err)
+		}
+		if addr != contract.Address {
+			return fmt.Errorf("genesis contract %d: deployed at %x, want %x", i, addr, contract.Address)
+		}
+	}
+	return nil
 }
 
 // Commit writes the block and state of a genesis specification to the database.
 // The block is committed as the canonical head block.
 func (g *Genesis) Commit(db ethdb.Database) (*types.Block, error) {
-	block, statedb := g.ToBlock()
+	block, statedb, err := g.toBlock()
+	if err != nil {
+		return nil, err
+	}
 	if block.Number().Sign() != 0 {
 		return nil, fmt.Errorf("can't commit genesis block with number > 0")
 	}
@@ -294,6 +519,10 @@ func (g *Genesis) Commit(db ethdb.Database) (*types.Block, error) {
 	if err := WriteHeadHeaderHash(db, block.Hash()); err != nil {
 		return nil, err
 	}
+	// New databases are in the latest schema, needing no migrations
+	if err := WriteSchemaVersion(db, LatestSchemaVersion()); err != nil {
+		return nil, err
+	}
 	config := g.Config
 	if config == nil {
 		config = params.AllProtocolChanges
@@ -319,14 +548,12 @@ func GenesisBlockForTesting(db ethdb.Database, addr common.Address, balance *big
 
 // DefaultPPOWTestingGenesisBlock returns the combchain ppow testing genesis block
 func DefaultPPOWTestingGenesisBlock() *Genesis {
-
 	key, _ := crypto.HexToECDSA("f1572f76b75b40a7da72d6f2ee7fda3d1189c2d28f0a2f096347055abe344d7f")
-	coinbase := crypto.PubkeyToAddress(key.PublicKey)
 	return &Genesis{
-		Coinbase:   coinbase,
+		Coinbase:   crypto.PubkeyToAddress(key.PublicKey),
 		Config:     params.TestChainConfig,
 		Nonce:      66,
-		ExtraData:  hexutil.MustDecode("0xf9b32578b4420a36f132db32b56f3831a7cc1804810524175efa012446103d1a04c9f4263a962accdb05642eabc8347ec78e21bdf0d906ba579d423ab5eb9bf02a924367ed9d4f86dfcb1c572cd9a4f80036805b6846f26ac35f2a7d7eda4a2a58f08e8ef073d4e52c506f3f288faa9db1c1e5ae0f1e70f8c38eb01bce9bcb61327532dc5a540da4cf484ae57e98bc5a465c1d2afa6b9376709a525981f53d493a46ef1eb55428b3b88a222d80d23531054ef51dbd100cf8286136659a7d63a38a154e28dbf3e0fd"),
+		Signers:    ppwSigners(ppwTestingSigAddr),
 		GasLimit:   0x47b760,
 		Difficulty: big.NewInt(1),
 		Alloc:      jsonPrealloc(combchainPPOWTestAllocJson),
@@ -338,7 +565,7 @@ func DefaultGenesisBlock() *Genesis {
 	return &Genesis{
 		Config:     params.combchainChainConfig,
 		Nonce:      98,
-		ExtraData:  hexutil.MustDecode(getMainNetPpwSignStr()),
+		Signers:    ppwSigners(ppwMainNetSigAddr),
 		GasLimit:   0x2fefd8,
 		Difficulty: big.NewInt(1048576),
 		//Difficulty: big.NewInt(17179869184),
@@ -351,7 +578,7 @@ func DefaultTestnetGenesisBlock() *Genesis {
 	return &Genesis{
 		Config:     params.TestnetChainConfig,
 		Nonce:      28, //same with the version
-		ExtraData:  hexutil.MustDecode(getTestNetPpwSignStr()),
+		Signers:    ppwSigners(ppwTestNetSigAddr),
 		GasLimit:   0x2fefd8,
 		Difficulty: big.NewInt(1048576),
 		Alloc:      jsonPrealloc(combchainTestAllocJson),
@@ -363,7 +590,7 @@ func DefaultInternalGenesisBlock() *Genesis {
 	return &Genesis{
 		Config:     params.InternalChainConfig,
 		Nonce:      20,
-		ExtraData:  hexutil.MustDecode(getInternalNetPpwSignStr()),
+		Signers:    ppwSigners(ppwInternalSigAddr),
 		GasLimit:   0x2fefd8,
 		Difficulty: big.NewInt(1),
 		Alloc:      jsonPrealloc(combchainTestAllocJson),
@@ -388,7 +615,7 @@ func DevGenesisBlock() *Genesis {
 	return &Genesis{
 		Config:     params.AllProtocolChanges,
 		Nonce:      42,
-		ExtraData:  hexutil.MustDecode("0x9da26fc2e1d6ad9fdd46138906b0104ae68a65d8"),
+		Signers:    []common.Address{common.HexToAddress("0x9da26fc2e1d6ad9fdd46138906b0104ae68a65d8")},
 		GasLimit:   4712388,
 		Difficulty: big.NewInt(1),
 		Alloc:      jsonPrealloc(combchainPPOWDevAllocJson),
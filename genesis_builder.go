@@ -0,0 +1,159 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"strings"
+
+	"github.com/combchain/go-combchain/accounts/keystore"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/params"
+)
+
+// Consensus engines a genesis can be built for.
+const (
+	EnginePPOW   = "ppow"   // Permissioned proof of work, signers listed in the extra data
+	EngineClique = "clique" // Proof of authority, signers between the vanity and seal of the extra data
+	EngineFaker  = "faker"  // Proof of work accepting any seal, for tests
+)
+
+// cliqueEpoch is the clique checkpoint interval of built genesis blocks.
+const cliqueEpoch = 30000
+
+// GenesisOptions configures a genesis built by BuildGenesis.
+type GenesisOptions struct {
+	Engine     string              // Consensus engine, EnginePPOW by default
+	Config     *params.ChainConfig // Chain ID and fork blocks, AllProtocolChanges if nil
+	Forks      map[string]*big.Int // Fork blocks overriding the config by name, e.g. "byzantium"
+	Period     uint64              // Seconds between clique blocks
+	Signers    []common.Address    // Existing signers
+	NewSigners int                 // Number of signer keys to create in the keystore
+	Keystore   string              // Keystore directory of the created signer keys
+	Passphrase string              // Passphrase of the created signer keys
+
+	Alloc         GenesisAlloc
+	SignerBalance *big.Int // Balance allocated to every signer on top of Alloc
+
+	Coinbase   common.Address
+	Nonce      uint64
+	Timestamp  uint64
+	GasLimit   uint64   // params.GenesisGasLimit if 0
+	Difficulty *big.Int // 1 if nil
+}
+
+// GenesisBuild is a genesis built by BuildGenesis.
+type GenesisBuild struct {
+	Genesis *Genesis
+	Hash    common.Hash      // Hash of the genesis block
+	Signers []common.Address // Signers of the network, the created ones last
+}
+
+// BuildGenesis builds a validated genesis for a private network, creating
+// the requested signer keys in the keystore.
+func BuildGenesis(opts GenesisOptions) (*GenesisBuild, error) {
+	engine := opts.Engine
+	if engine == "" {
+		engine = EnginePPOW
+	}
+	if engine != EnginePPOW && engine != EngineClique && engine != EngineFaker {
+		return nil, fmt.Errorf("unknown consensus engine %q", engine)
+	}
+	// Only copy the configuration if it changes, keeping the presets shared
+	config := opts.Config
+	if config == nil {
+		config = params.AllProtocolChanges
+	}
+	if len(opts.Forks) > 0 || engine == EngineClique || config.Clique != nil {
+		cpy := *config
+		config = &cpy
+		for name, block := range opts.Forks {
+			if err := setForkBlock(config, name, block); err != nil {
+				return nil, err
+			}
+		}
+		config.Clique = nil
+		if engine == EngineClique {
+			config.Clique = &params.CliqueConfig{Period: opts.Period, Epoch: cliqueEpoch}
+		}
+	}
+	// Assemble the signers, creating the new ones
+	signers := append([]common.Address{}, opts.Signers...)
+	if opts.NewSigners > 0 {
+		if opts.Keystore == "" {
+			return nil, fmt.Errorf("no keystore for %d new signers", opts.NewSigners)
+		}
+		ks := keystore.NewKeyStore(opts.Keystore, keystore.StandardScryptN, keystore.StandardScryptP)
+		for i := 0; i < opts.NewSigners; i++ {
+			account, err := ks.NewAccount(opts.Passphrase)
+			if err != nil {
+				return nil, fmt.Errorf("failed to create signer key: %v", err)
+			}
+			signers = append(signers, account.Address)
+		}
+	}
+	if len(signers) == 0 && engine != EngineFaker {
+		return nil, fmt.Errorf("%s genesis without signers", engine)
+	}
+	alloc := make(GenesisAlloc, len(opts.Alloc)+len(signers))
+	for addr, account := range opts.Alloc {
+		alloc[addr] = account
+	}
+	if opts.SignerBalance != nil && opts.SignerBalance.Sign() > 0 {
+		for _, signer := range signers {
+			account := alloc[signer]
+			if account.Balance == nil {
+				account.Balance = new(big.Int)
+			}
+			account.Balance = new(big.Int).Add(account.Balance, opts.SignerBalance)
+			alloc[signer] = account
+		}
+	}
+	genesis := &Genesis{
+		Config:     config,
+		Nonce:      opts.Nonce,
+		Timestamp:  opts.Timestamp,
+		GasLimit:   opts.GasLimit,
+		Difficulty: opts.Difficulty,
+		Coinbase:   opts.Coinbase,
+		Alloc:      alloc,
+	}
+	if genesis.GasLimit == 0 {
+		genesis.GasLimit = params.GenesisGasLimit.Uint64()
+	}
+	if genesis.Difficulty == nil {
+		genesis.Difficulty = big.NewInt(1)
+	}
+	switch engine {
+	case EngineClique:
+		genesis.ExtraData = make([]byte, 32, 32+len(signers)*common.AddressLength+65)
+		genesis.ExtraData = append(append(genesis.ExtraData, signersExtra(signers)...), make([]byte, 65)...)
+	default:
+		genesis.Signers = signers
+	}
+	block, err := genesis.validate()
+	if err != nil {
+		return nil, err
+	}
+	return &GenesisBuild{Genesis: genesis, Hash: block.Hash(), Signers: signers}, nil
+}
+
+// setForkBlock sets the fork block of the given name, e.g. "byzantium" for
+// ByzantiumBlock, in the chain configuration.
+func setForkBlock(config *params.ChainConfig, name string, block *big.Int) error {
+	v := reflect.ValueOf(config).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i).Name
+		if !strings.EqualFold(field, name) && !strings.EqualFold(field, name+"Block") {
+			continue
+		}
+		if !strings.HasSuffix(field, "Block") || !v.Field(i).CanSet() || v.Field(i).Type() != reflect.TypeOf(block) {
+			break
+		}
+		v.Field(i).Set(reflect.ValueOf(block))
+		return nil
+	}
+	return fmt.Errorf("unknown fork %q", name)
+}
@@ -2,68 +2,15 @@
 
 package core
 
-import (
-	"bytes"
-	//"fmt"
-	"strings"
-)
-
-func getMainNetPpwSignStr() string {
-
-	var buf bytes.Buffer
-	buf.WriteString("0x")
-
-	for _, str := range ppwMainNetSigAddr {
-		addr := strings.ToLower(str[:])
-		if strings.HasPrefix(addr,"0x") {
-			buf.WriteString(addr[2:])
-		} else {
-			buf.WriteString(addr[:])
-		}
-	}
-
-	//fmt.Print(buf.String())
-	return buf.String()
-
-}
-
-
-func getTestNetPpwSignStr() string {
-
-	var buf bytes.Buffer
-	buf.WriteString("0x")
-
-	for _, str := range ppwTestNetSigAddr {
-		addr := strings.ToLower(str[:])
-		if strings.HasPrefix(addr,"0x") {
-			buf.WriteString(addr[2:])
-		} else {
-			buf.WriteString(addr[:])
-		}
-	}
-
-	//fmt.Print(buf.String())
-	return buf.String()
-
-}
+import "github.com/combchain/go-combchain/common"
 
-func getInternalNetPpwSignStr() string {
-
-	var buf bytes.Buffer
-	buf.WriteString("0x")
-
-	for _, str := range ppwInternalSigAddr {
-		addr := strings.ToLower(str[:])
-		if strings.HasPrefix(addr,"0x") {
-			buf.WriteString(addr[2:])
-		} else {
-			buf.WriteString(addr[:])
-		}
+// ppwSigners parses a PPOW signer list of hex addresses.
+func ppwSigners(addrs []string) []common.Address {
+	signers := make([]common.Address, len(addrs))
+	for i, addr := range addrs {
+		signers[i] = common.HexToAddress(addr)
 	}
-
-	//fmt.Print(buf.String())
-	return buf.String()
-
+	return signers
 }
 
 var (
@@ -954,4 +901,17 @@ var (
 		"0x69d37238330ffcafcba91adba8b86453f115357a",
 		"0x2cc79fa3b80c5b9b02051facd02478ea88a78e2c",
 	}
+
+	ppwTestingSigAddr []string = []string{
+		"0xf9b32578b4420a36f132db32b56f3831a7cc1804",
+		"0x810524175efa012446103d1a04c9f4263a962acc",
+		"0xdb05642eabc8347ec78e21bdf0d906ba579d423a",
+		"0xb5eb9bf02a924367ed9d4f86dfcb1c572cd9a4f8",
+		"0x0036805b6846f26ac35f2a7d7eda4a2a58f08e8e",
+		"0xf073d4e52c506f3f288faa9db1c1e5ae0f1e70f8",
+		"0xc38eb01bce9bcb61327532dc5a540da4cf484ae5",
+		"0x7e98bc5a465c1d2afa6b9376709a525981f53d49",
+		"0x3a46ef1eb55428b3b88a222d80d23531054ef51d",
+		"0xbd100cf8286136659a7d63a38a154e28dbf3e0fd",
+	}
 )
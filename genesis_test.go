@@ -17,16 +17,24 @@
 package core
 
 import (
+	"bytes"
+	"encoding/json"
 	"math/big"
 	"reflect"
+	"strings"
 	"testing"
 
 	"fmt"
+	"io/ioutil"
+	"os"
 
 	"github.com/davecgh/go-spew/spew"
+	"github.com/combchain/combchain/crypto"
 	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/common/hexutil"
 	"github.com/combchain/go-combchain/ethdb"
 	"github.com/combchain/go-combchain/params"
+	"github.com/combchain/go-combchain/vm/evm"
 )
 
 func TestDefaultGenesisBlock(t *testing.T) {
@@ -184,3 +192,195 @@ func TestSetupGenesis(t *testing.T) {
 		}
 	}
 }
+
+// Tests that signers, OTA notes and contracts of a declarative genesis end up
+// in the genesis block, survive a JSON round trip and are validated.
+func TestGenesisSchema(t *testing.T) {
+	var (
+		deployer = common.HexToAddress("0xdeadbeef")
+		signers  = []common.Address{{0x01}, {0x02}}
+		note     = make([]byte, common.WAddressLength)
+		coin, _  = new(big.Int).SetString("10000000000000000000", 10)
+	)
+	note[1] = 0xaa
+	genesis := &Genesis{
+		Config:     params.TestChainConfig,
+		GasLimit:   0x47b760,
+		Difficulty: big.NewInt(1),
+		Alloc:      GenesisAlloc{deployer: {Balance: big.NewInt(1000)}},
+		Signers:    signers,
+		OTANotes:   []GenesisOTANote{{Address: note, Value: coin}},
+		Contracts: []GenesisContract{{
+			Address:     crypto.CreateAddress(deployer, 0),
+			Deployer:    deployer,
+			Constructor: counterCode,
+			Value:       big.NewInt(100),
+		}},
+	}
+	if err := genesis.Validate(); err != nil {
+		t.Fatalf("valid genesis rejected: %v", err)
+	}
+	block, statedb := genesis.ToBlock()
+	if !bytes.Equal(block.Extra(), append(signers[0].Bytes(), signers[1].Bytes()...)) {
+		t.Errorf("extra data mismatch: have %x", block.Extra())
+	}
+	if exist, balance, err := vm.CheckOTAAXExist(statedb, note[1:1+common.HashLength]); err != nil || !exist || balance.Cmp(coin) != 0 {
+		t.Errorf("OTA note mismatch: exist %v, balance %v (%v)", exist, balance, err)
+	}
+	contract := genesis.Contracts[0].Address
+	if code := statedb.GetCode(contract); !bytes.Equal(code, counterCode[12:]) {
+		t.Errorf("contract code mismatch: have %x, want %x", code, counterCode[12:])
+	}
+	if balance := statedb.GetBalance(contract); balance.Int64() != 100 {
+		t.Errorf("contract endowment mismatch: have %v, want 100", balance)
+	}
+	if balance := statedb.GetBalance(deployer); balance.Int64() != 900 {
+		t.Errorf("deployer balance mismatch: have %v, want 900", balance)
+	}
+	// JSON round trip
+	enc, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatalf("failed to encode genesis: %v", err)
+	}
+	dec := new(Genesis)
+	if err := json.Unmarshal(enc, dec); err != nil {
+		t.Fatalf("failed to decode genesis: %v", err)
+	}
+	if decBlock, _ := dec.ToBlock(); decBlock.Hash() != block.Hash() {
+		t.Errorf("decoded genesis hash mismatch: have %x, want %x", decBlock.Hash(), block.Hash())
+	}
+	// Invalid specifications
+	tests := []struct {
+		name   string
+		modify func(g *Genesis)
+		err    string
+	}{
+		{"duplicate signer", func(g *Genesis) { g.Signers = append(g.Signers, signers[0]) }, "duplicate genesis signer"},
+		{"conflicting extra", func(g *Genesis) { g.ExtraData = []byte{0x01} }, "conflicts with signers"},
+		{"odd note value", func(g *Genesis) { g.OTANotes = []GenesisOTANote{{Address: note, Value: big.NewInt(7)}} }, "no denomination"},
+		{"short note address", func(g *Genesis) { g.OTANotes = []GenesisOTANote{{Address: note[1:], Value: coin}} }, "invalid address length"},
+		{"wrong contract address", func(g *Genesis) { g.Contracts[0].Address = common.Address{0x01} }, "deployed at"},
+		{"poor deployer", func(g *Genesis) { g.Contracts[0].Value = big.NewInt(1001) }, "constructor failed"},
+		{"fork order", func(g *Genesis) {
+			g.Config = &params.ChainConfig{ChainId: big.NewInt(1), EIP155Block: big.NewInt(5), ByzantiumBlock: big.NewInt(2)}
+		}, "before EIP155Block"},
+	}
+	for _, test := range tests {
+		g := *genesis
+		g.Contracts = append([]GenesisContract{}, genesis.Contracts...)
+		test.modify(&g)
+
+		db, _ := ethdb.NewMemDatabase()
+		if _, _, err := SetupGenesisBlock(db, &g); err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error mismatch: have %v, want %q", test.name, err, test.err)
+		}
+	}
+}
+
+func TestBuildGenesis(t *testing.T) {
+	dir, err := ioutil.TempDir("", "genesis-builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		signer = common.Address{0x01}
+		funded = common.Address{0x02}
+		coin   = big.NewInt(1000)
+	)
+	build, err := BuildGenesis(GenesisOptions{
+		Signers:       []common.Address{signer},
+		NewSigners:    1,
+		Keystore:      dir,
+		Alloc:         GenesisAlloc{funded: {Balance: coin}, signer: {Balance: coin}},
+		SignerBalance: coin,
+		Forks:         map[string]*big.Int{"byzantium": big.NewInt(10)},
+	})
+	if err != nil {
+		t.Fatalf("failed to build genesis: %v", err)
+	}
+	if len(build.Signers) != 2 || build.Signers[0] != signer {
+		t.Fatalf("signers mismatch: have %x", build.Signers)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 || !strings.Contains(strings.ToLower(files[0].Name()), common.Bytes2Hex(build.Signers[1][:])) {
+		t.Errorf("signer key not in keystore: %v", files)
+	}
+	genesis := build.Genesis
+	if !reflect.DeepEqual(genesis.SignerList(), build.Signers) {
+		t.Errorf("genesis signers mismatch: have %x, want %x", genesis.SignerList(), build.Signers)
+	}
+	if genesis.Config.ByzantiumBlock.Int64() != 10 || params.AllProtocolChanges.ByzantiumBlock.Int64() == 10 {
+		t.Errorf("byzantium fork mismatch: have %v", genesis.Config.ByzantiumBlock)
+	}
+	block, statedb := genesis.ToBlock()
+	if block.Hash() != build.Hash {
+		t.Errorf("genesis hash mismatch: have %x, want %x", block.Hash(), build.Hash)
+	}
+	if !bytes.Equal(block.Extra(), signersExtra(build.Signers)) {
+		t.Errorf("extra data mismatch: have %x", block.Extra())
+	}
+	if balance := statedb.GetBalance(signer); balance.Int64() != 2000 {
+		t.Errorf("signer balance mismatch: have %v, want 2000", balance)
+	}
+	if balance := statedb.GetBalance(build.Signers[1]); balance.Int64() != 1000 {
+		t.Errorf("new signer balance mismatch: have %v, want 1000", balance)
+	}
+	if balance := statedb.GetBalance(funded); balance.Int64() != 1000 {
+		t.Errorf("allocation mismatch: have %v, want 1000", balance)
+	}
+	enc, err := json.Marshal(genesis)
+	if err != nil {
+		t.Fatalf("failed to encode genesis: %v", err)
+	}
+	dec := new(Genesis)
+	if err := json.Unmarshal(enc, dec); err != nil {
+		t.Fatalf("failed to decode genesis: %v", err)
+	}
+	if decBlock, _ := dec.ToBlock(); decBlock.Hash() != build.Hash {
+		t.Errorf("decoded genesis hash mismatch: have %x, want %x", decBlock.Hash(), build.Hash)
+	}
+	// Clique keeps the signers between the vanity and the seal
+	clique, err := BuildGenesis(GenesisOptions{Engine: EngineClique, Signers: []common.Address{signer}, Period: 5})
+	if err != nil {
+		t.Fatalf("failed to build clique genesis: %v", err)
+	}
+	if extra := clique.Genesis.ExtraData; len(extra) != 32+common.AddressLength+65 || !bytes.Equal(extra[32:32+common.AddressLength], signer[:]) {
+		t.Errorf("clique extra data mismatch: have %x", extra)
+	}
+	if config := clique.Genesis.Config.Clique; config == nil || config.Period != 5 || params.AllProtocolChanges.Clique != nil {
+		t.Errorf("clique config mismatch: have %v", config)
+	}
+	// The faker needs no signers, the others do
+	if _, err := BuildGenesis(GenesisOptions{Engine: EngineFaker}); err != nil {
+		t.Errorf("failed to build faker genesis: %v", err)
+	}
+	if _, err := BuildGenesis(GenesisOptions{}); err == nil {
+		t.Errorf("ppow genesis without signers built")
+	}
+	if _, err := BuildGenesis(GenesisOptions{NewSigners: 1}); err == nil {
+		t.Errorf("signers created without a keystore")
+	}
+	if _, err := BuildGenesis(GenesisOptions{Engine: EngineFaker, Forks: map[string]*big.Int{"unknown": big.NewInt(1)}}); err == nil || !strings.Contains(err.Error(), "unknown fork") {
+		t.Errorf("unknown fork error mismatch: have %v", err)
+	}
+	// The presets are valid and keep their genesis blocks
+	presets := []struct {
+		preset *Genesis
+		extra  string
+	}{
+		{DevGenesisBlock(), "0x9da26fc2e1d6ad9fdd46138906b0104ae68a65d8"},
+		{DefaultPPOWTestingGenesisBlock(), "0xf9b32578b4420a36f132db32b56f3831a7cc1804810524175efa012446103d1a04c9f4263a962accdb05642eabc8347ec78e21bdf0d906ba579d423ab5eb9bf02a924367ed9d4f86dfcb1c572cd9a4f80036805b6846f26ac35f2a7d7eda4a2a58f08e8ef073d4e52c506f3f288faa9db1c1e5ae0f1e70f8c38eb01bce9bcb61327532dc5a540da4cf484ae57e98bc5a465c1d2afa6b9376709a525981f53d493a46ef1eb55428b3b88a222d80d23531054ef51dbd100cf8286136659a7d63a38a154e28dbf3e0fd"},
+	}
+	for i, test := range presets {
+		if err := test.preset.Validate(); err != nil {
+			t.Errorf("preset %d: invalid: %v", i, err)
+		}
+		spec := *test.preset
+		spec.Signers, spec.ExtraData = nil, hexutil.MustDecode(test.extra)
+		want, _ := spec.ToBlock()
+		if have, _ := test.preset.ToBlock(); have.Hash() != want.Hash() {
+			t.Errorf("preset %d: genesis hash mismatch: have %x, want %x", i, have.Hash(), want.Hash())
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+)
+
+// Migration upgrades the chain database to a schema version. Migrations must
+// tolerate being rerun after a crash, resuming from their last checkpoint.
+type Migration struct {
+	Version uint64 // Schema version of the database after the migration
+	Name    string
+	Migrate func(ctx *MigrationContext) error
+}
+
+var (
+	migrationsLock sync.Mutex
+	migrations     []Migration // Registered migrations, ordered by version
+)
+
+// RegisterMigration registers a migration to the given schema version, run
+// by NewBlockChain on databases of older versions. It panics if a migration
+// to the version is registered already.
+func RegisterMigration(version uint64, name string, migrate func(ctx *MigrationContext) error) {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+
+	for _, m := range migrations {
+		if m.Version == version {
+			panic(fmt.Sprintf("migration %q to schema version %d conflicts with %q", name, version, m.Name))
+		}
+	}
+	migrations = append(migrations, Migration{Version: version, Name: name, Migrate: migrate})
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+}
+
+// LatestSchemaVersion returns the schema version of new databases, that of
+// the last registered migration.
+func LatestSchemaVersion() uint64 {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// PendingMigrations returns the migrations the database needs, in order.
+func PendingMigrations(db DatabaseReader) []Migration {
+	migrationsLock.Lock()
+	defer migrationsLock.Unlock()
+
+	version := GetSchemaVersion(db)
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+// migrationMarker records the migration in progress, so it is resumed after
+// a crash.
+type migrationMarker struct {
+	Version    uint64
+	Checkpoint []byte
+}
+
+// MigrateDatabase runs the pending migrations of the database in order. In a
+// dry run the migrations read the database but their writes are discarded,
+// reporting the work they would do.
+func MigrateDatabase(db ethdb.Database, dryRun bool) error {
+	for _, m := range PendingMigrations(db) {
+		ctx := &MigrationContext{db: db, migration: m, start: time.Now(), logged: time.Now(), dryRun: dryRun}
+		if dryRun {
+			ctx.db = &dryRunDB{Database: db}
+		}
+		if enc, _ := db.Get(migrationMarkerKey); len(enc) > 0 {
+			var marker migrationMarker
+			if err := rlp.DecodeBytes(enc, &marker); err == nil && marker.Version == m.Version {
+				ctx.checkpoint = marker.Checkpoint
+				log.Info("Resuming database migration", "version", m.Version, "name", m.Name)
+			}
+		}
+		log.Info("Migrating database", "version", m.Version, "name", m.Name, "dryrun", dryRun)
+		if err := ctx.SetCheckpoint(ctx.checkpoint); err != nil {
+			return err
+		}
+		if err := m.Migrate(ctx); err != nil {
+			return fmt.Errorf("migration %q to schema version %d failed: %v", m.Name, m.Version, err)
+		}
+		log.Info("Migrated database", "version", m.Version, "name", m.Name, "dryrun", dryRun, "elapsed", time.Since(ctx.start))
+		if dryRun {
+			continue
+		}
+		// A marker left behind by a crash here is ignored, being outdated
+		if err := WriteSchemaVersion(db, m.Version); err != nil {
+			return err
+		}
+		if err := db.Delete(migrationMarkerKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrationContext gives a running migration access to the database and
+// lets it report progress and record checkpoints.
+type MigrationContext struct {
+	db         ethdb.Database
+	migration  Migration
+	checkpoint []byte
+	dryRun     bool
+	start      time.Time
+	logged     time.Time
+}
+
+// DB returns the database to migrate. Writes to it are discarded in dry runs.
+func (ctx *MigrationContext) DB() ethdb.Database {
+	return ctx.db
+}
+
+// DryRun reports whether the writes of the migration are discarded.
+func (ctx *MigrationContext) DryRun() bool {
+	return ctx.dryRun
+}
+
+// Checkpoint returns the last checkpoint recorded by the migration before a
+// crash, nil when starting afresh.
+func (ctx *MigrationContext) Checkpoint() []byte {
+	return ctx.checkpoint
+}
+
+// SetCheckpoint records how far the migration got. It should be called after
+// the writes up to the checkpoint are persisted.
+func (ctx *MigrationContext) SetCheckpoint(checkpoint []byte) error {
+	ctx.checkpoint = checkpoint
+	enc, err := rlp.EncodeToBytes(&migrationMarker{Version: ctx.migration.Version, Checkpoint: checkpoint})
+	if err != nil {
+		return err
+	}
+	return ctx.db.Put(migrationMarkerKey, enc)
+}
+
+// Progress reports the progress of the migration, logging it periodically.
+func (ctx *MigrationContext) Progress(done, total uint64) {
+	if time.Since(ctx.logged) < statsReportLimit && done < total {
+		return
+	}
+	ctx.logged = time.Now()
+	log.Info("Migrating database", "version", ctx.migration.Version, "name", ctx.migration.Name, "done", done, "total", total, "elapsed", time.Since(ctx.start))
+}
+
+// dryRunDB discards the writes to a database.
+type dryRunDB struct {
+	ethdb.Database
+}
+
+func (db *dryRunDB) Put(key []byte, value []byte) error { return nil }
+func (db *dryRunDB) Delete(key []byte) error            { return nil }
+func (db *dryRunDB) NewBatch() ethdb.Batch              { return &dryRunBatch{} }
+
+// dryRunBatch is a batch discarding its writes.
+type dryRunBatch struct {
+	size int
+}
+
+func (b *dryRunBatch) Put(key, value []byte) error {
+	b.size += len(value)
+	return nil
+}
+func (b *dryRunBatch) ValueSize() int { return b.size }
+func (b *dryRunBatch) Write() error   { return nil }
@@ -0,0 +1,113 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/combchain/go-combchain/ethdb"
+)
+
+// Tests that migrations run in order, support dry runs and resume from their
+// checkpoint after a crash.
+func TestMigrations(t *testing.T) {
+	defer func(registered []Migration) { migrations = registered }(migrations)
+	migrations = nil
+
+	var (
+		crash = true
+		runs  []uint64
+	)
+	// The second migration is registered first, ordering is by version
+	RegisterMigration(2, "rename entries", func(ctx *MigrationContext) error {
+		runs = append(runs, 2)
+		start := 0
+		if cp := ctx.Checkpoint(); cp != nil {
+			start = int(cp[0])
+		}
+		for i := start; i < 8; i++ {
+			key := []byte(fmt.Sprintf("old-%d", i))
+			value, err := ctx.DB().Get(key)
+			if err != nil {
+				return err
+			}
+			ctx.DB().Put([]byte(fmt.Sprintf("new-%d", i)), value)
+			ctx.DB().Delete(key)
+			if err := ctx.SetCheckpoint([]byte{byte(i + 1)}); err != nil {
+				return err
+			}
+			ctx.Progress(uint64(i+1), 8)
+			if i == 3 && crash {
+				return errors.New("crash")
+			}
+		}
+		return nil
+	})
+	RegisterMigration(1, "add entries", func(ctx *MigrationContext) error {
+		runs = append(runs, 1)
+		batch := ctx.DB().NewBatch()
+		for i := 0; i < 8; i++ {
+			batch.Put([]byte(fmt.Sprintf("old-%d", i)), []byte{byte(i)})
+		}
+		return batch.Write()
+	})
+	if LatestSchemaVersion() != 2 {
+		t.Fatalf("latest schema version mismatch: have %d, want 2", LatestSchemaVersion())
+	}
+	db, _ := ethdb.NewMemDatabase()
+	WriteBlockChainVersion(db, BlockChainVersion)
+
+	// Dry runs must not modify the database
+	if err := MigrateDatabase(db, true); err != nil {
+		t.Fatalf("dry run failed: %v", err)
+	}
+	if keys := len(db.Keys()) - 1; keys != 0 {
+		t.Errorf("dry run wrote %d entries", keys)
+	}
+	if len(PendingMigrations(db)) != 2 {
+		t.Errorf("pending migrations mismatch after dry run: have %d, want 2", len(PendingMigrations(db)))
+	}
+	// Crash halfway through the second migration, then resume
+	runs = nil
+	if err := MigrateDatabase(db, false); err == nil {
+		t.Fatalf("crashing migration succeeded")
+	}
+	if version := GetSchemaVersion(db); version != 1 {
+		t.Errorf("schema version mismatch after crash: have %d, want 1", version)
+	}
+	crash = false
+	if err := MigrateDatabase(db, false); err != nil {
+		t.Fatalf("failed to resume migration: %v", err)
+	}
+	if !reflect.DeepEqual(runs, []uint64{1, 2, 2}) {
+		t.Errorf("migration runs mismatch: have %v, want [1 2 2]", runs)
+	}
+	if version := GetSchemaVersion(db); version != 2 {
+		t.Errorf("schema version mismatch: have %d, want 2", version)
+	}
+	// Migrations must not touch the blockchain version, which would force a resync
+	if version := GetBlockChainVersion(db); version != BlockChainVersion {
+		t.Errorf("blockchain version mismatch: have %d, want %d", version, BlockChainVersion)
+	}
+	for i := 0; i < 8; i++ {
+		if has, _ := db.Has([]byte(fmt.Sprintf("old-%d", i))); has {
+			t.Errorf("entry %d: not migrated", i)
+		}
+		if value, _ := db.Get([]byte(fmt.Sprintf("new-%d", i))); !bytes.Equal(value, []byte{byte(i)}) {
+			t.Errorf("entry %d: migrated value mismatch: have %x", i, value)
+		}
+	}
+	if has, _ := db.Has(migrationMarkerKey); has {
+		t.Errorf("migration marker retained")
+	}
+	// New databases start at the latest version
+	fresh, _ := ethdb.NewMemDatabase()
+	DefaultPPOWTestingGenesisBlock().MustCommit(fresh)
+	if pending := PendingMigrations(fresh); len(pending) != 0 {
+		t.Errorf("new database needs %d migrations", len(pending))
+	}
+}
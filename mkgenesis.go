@@ -0,0 +1,124 @@
+// Copyright 2018 combchain Foundation Ltd
+
+// +build none
+
+/*
+
+   The mkgenesis tool builds the genesis of a private network, creating the
+   signer keys in a keystore. It outputs the genesis JSON and its block hash.
+
+       go run mkgenesis.go -signers 3 -keystore keys -alloc 0x...=1000000 -fork byzantium=0
+
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/core"
+	"github.com/combchain/go-combchain/params"
+)
+
+// pairs collects repeated name=value flags.
+type pairs []string
+
+func (p *pairs) String() string     { return strings.Join(*p, ",") }
+func (p *pairs) Set(v string) error { *p = append(*p, v); return nil }
+
+func main() {
+	var (
+		engine     = flag.String("engine", core.EnginePPOW, "consensus engine (ppow, clique or faker)")
+		signers    = flag.Int("signers", 0, "number of signer keys to create")
+		keystore   = flag.String("keystore", "", "keystore directory of the signer keys")
+		password   = flag.String("password", "", "passphrase of the signer keys")
+		balance    = flag.String("balance", "0", "balance of every signer")
+		gasLimit   = flag.Uint64("gaslimit", params.GenesisGasLimit.Uint64(), "genesis gas limit")
+		chainID    = flag.Int64("chainid", 0, "chain ID, that of AllProtocolChanges if 0")
+		period     = flag.Uint64("period", 15, "seconds between clique blocks")
+		out        = flag.String("out", "", "genesis JSON file, stdout if empty")
+		allocs     pairs
+		forks      pairs
+		extSigners pairs
+	)
+	flag.Var(&allocs, "alloc", "address=balance allocation, repeatable")
+	flag.Var(&forks, "fork", "name=block fork, repeatable")
+	flag.Var(&extSigners, "signer", "existing signer address, repeatable")
+	flag.Parse()
+
+	opts := core.GenesisOptions{
+		Engine:     *engine,
+		Period:     *period,
+		NewSigners: *signers,
+		Keystore:   *keystore,
+		Passphrase: *password,
+		Alloc:      make(core.GenesisAlloc),
+		Forks:      make(map[string]*big.Int),
+		GasLimit:   *gasLimit,
+	}
+	if *chainID != 0 {
+		config := *params.AllProtocolChanges
+		config.ChainId = big.NewInt(*chainID)
+		opts.Config = &config
+	}
+	for _, addr := range extSigners {
+		if !common.IsHexAddress(addr) {
+			fatalf("invalid signer %q", addr)
+		}
+		opts.Signers = append(opts.Signers, common.HexToAddress(addr))
+	}
+	var ok bool
+	if opts.SignerBalance, ok = new(big.Int).SetString(*balance, 0); !ok {
+		fatalf("invalid signer balance %q", *balance)
+	}
+	for _, pair := range allocs {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || !common.IsHexAddress(kv[0]) {
+			fatalf("invalid allocation %q", pair)
+		}
+		value, ok := new(big.Int).SetString(kv[1], 0)
+		if !ok {
+			fatalf("invalid allocation %q", pair)
+		}
+		opts.Alloc[common.HexToAddress(kv[0])] = core.GenesisAccount{Balance: value}
+	}
+	for _, pair := range forks {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			fatalf("invalid fork %q", pair)
+		}
+		block, ok := new(big.Int).SetString(kv[1], 0)
+		if !ok {
+			fatalf("invalid fork %q", pair)
+		}
+		opts.Forks[kv[0]] = block
+	}
+	build, err := core.BuildGenesis(opts)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	blob, err := json.MarshalIndent(build.Genesis, "", "  ")
+	if err != nil {
+		fatalf("%v", err)
+	}
+	if *out == "" {
+		fmt.Println(string(blob))
+	} else if err := ioutil.WriteFile(*out, blob, 0644); err != nil {
+		fatalf("%v", err)
+	}
+	for _, signer := range build.Signers {
+		fmt.Fprintf(os.Stderr, "signer %x\n", signer)
+	}
+	fmt.Fprintf(os.Stderr, "genesis %x\n", build.Hash)
+}
+
+func fatalf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,106 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+)
+
+// SetReceiptless sets whether block receipts are stored on import. Without
+// them, receipts are re-derived on demand by re-executing their block, which
+// needs the state of its parent. Blocks imported receiptless are marked in the
+// database, so their receipts stay re-derivable after a mode change.
+func (bc *BlockChain) SetReceiptless(receiptless bool) {
+	var flag int32
+	if receiptless {
+		flag = 1
+	}
+	atomic.StoreInt32(&bc.noReceipts, flag)
+}
+
+// Receiptless reports whether block receipts are re-derived instead of stored.
+func (bc *BlockChain) Receiptless() bool {
+	return atomic.LoadInt32(&bc.noReceipts) == 1
+}
+
+// GetReceiptsByHash retrieves the receipts of the block with the given hash,
+// re-deriving them if they aren't stored. It fails with ErrStateUnavailable
+// if the receipts have to be derived but the state of the parent is gone.
+func (bc *BlockChain) GetReceiptsByHash(hash common.Hash) (types.Receipts, error) {
+	if receipts, ok := bc.receiptsCache.Get(hash); ok {
+		return receipts.(types.Receipts), nil
+	}
+	number := bc.hc.GetBlockNumber(hash)
+	if number == missingNumber {
+		return nil, ErrUnknownBlock
+	}
+	if receipts := GetBlockReceipts(bc.chainDb, hash, number); receipts != nil {
+		return receipts, nil
+	}
+	block := bc.GetBlock(hash, number)
+	if block == nil {
+		return nil, ErrUnknownBlock
+	}
+	receipts, err := bc.deriveReceipts(block)
+	if err != nil {
+		return nil, err
+	}
+	bc.receiptsCache.Add(hash, receipts)
+	return receipts, nil
+}
+
+// GetReceipt retrieves the receipt of the transaction with the given hash
+// along with the hash, number and index of its block, re-deriving the
+// receipts of the block if they aren't stored.
+func (bc *BlockChain) GetReceipt(txHash common.Hash) (*types.Receipt, common.Hash, uint64, uint64, error) {
+	blockHash, number, index := GetTxLookupEntry(bc.chainDb, txHash)
+	if blockHash == (common.Hash{}) {
+		// Legacy databases store receipts by transaction hash only
+		if receipt, blockHash, number, index := GetReceipt(bc.chainDb, txHash); receipt != nil {
+			return receipt, blockHash, number, index, nil
+		}
+		return nil, common.Hash{}, 0, 0, ErrUnknownBlock
+	}
+	receipts, err := bc.GetReceiptsByHash(blockHash)
+	if err != nil {
+		return nil, common.Hash{}, 0, 0, err
+	}
+	if index >= uint64(len(receipts)) {
+		return nil, common.Hash{}, 0, 0, fmt.Errorf("receipt #%d of block #%d [%x…] missing", index, number, blockHash[:4])
+	}
+	return receipts[index], blockHash, number, index, nil
+}
+
+// deriveReceipts re-executes the block on the state of its parent to derive
+// its receipts, checking them against the receipt root of the header.
+func (bc *BlockChain) deriveReceipts(block *types.Block) (types.Receipts, error) {
+	parent := bc.GetBlock(block.ParentHash(), block.NumberU64()-1)
+	if parent == nil {
+		return nil, ErrUnknownBlock
+	}
+	statedb, err := state.New(parent.Root(), bc.stateDatabase())
+	if err != nil {
+		return nil, ErrStateUnavailable
+	}
+	// A fresh processor, the chain's one may be reporting diffs or witnesses
+	processor := NewStateProcessor(bc.config, bc, bc.engine)
+	receipts, _, _, err := processor.Process(block, statedb, bc.vmConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := statedb.Error(); err != nil {
+		// Partially pruned state
+		return nil, ErrStateUnavailable
+	}
+	if hash := types.DeriveSha(receipts); hash != block.ReceiptHash() {
+		return nil, fmt.Errorf("derived receipts of block #%d [%x…] mismatch: have root %x, want %x", block.NumberU64(), block.Hash().Bytes()[:4], hash, block.ReceiptHash())
+	}
+	log.Debug("Derived block receipts", "number", block.NumberU64(), "hash", block.Hash(), "txs", len(receipts))
+	return receipts, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/types"
+)
+
+// Tests that receipts are re-derived in receiptless mode, also after a restart
+// in normal mode, and that deriving them fails cleanly without the state of
+// the parent block.
+func TestReceiptlessChain(t *testing.T) {
+	fullChain, err := newTestChain(big.NewInt(1000000000000))
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer fullChain.stop()
+	leanChain, err := fullChain.sibling()
+	if err != nil {
+		t.Fatalf("failed to create sibling chain: %v", err)
+	}
+	defer leanChain.stop()
+
+	full, fullDb := fullChain.blockchain, fullChain.db
+	lean, leanDb := leanChain.blockchain, leanChain.db
+	lean.SetReceiptless(true)
+
+	chain := fullChain.generate(nil, 4, func(i int, gen *BlockGen) {
+		fullChain.send(gen, types.NewContractCreation(gen.TxNonce(fullChain.addr), new(big.Int), big.NewInt(100000), big.NewInt(1), counterCode))
+		fullChain.transfer(gen, common.Address{byte(i)})
+	})
+	if _, err := full.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	if _, err := lean.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain in receiptless mode: %v", err)
+	}
+	for _, block := range chain[:3] {
+		if GetBlockReceipts(leanDb, block.Hash(), block.NumberU64()) != nil {
+			t.Errorf("block %d: receipts stored in receiptless mode", block.NumberU64())
+		}
+		receipts, err := lean.GetReceiptsByHash(block.Hash())
+		if err != nil {
+			t.Fatalf("block %d: failed to derive receipts: %v", block.NumberU64(), err)
+		}
+		want := GetBlockReceipts(fullDb, block.Hash(), block.NumberU64())
+		if types.DeriveSha(receipts) != types.DeriveSha(want) {
+			t.Errorf("block %d: derived receipts mismatch", block.NumberU64())
+		}
+		for i, tx := range block.Transactions() {
+			receipt, hash, number, index, err := lean.GetReceipt(tx.Hash())
+			if err != nil || hash != block.Hash() || number != block.NumberU64() || index != uint64(i) {
+				t.Fatalf("block %d, tx %d: lookup mismatch: %x, %d, %d (%v)", block.NumberU64(), i, hash, number, index, err)
+			}
+			if receipt.GasUsed.Cmp(want[i].GasUsed) != 0 || receipt.ContractAddress != want[i].ContractAddress {
+				t.Errorf("block %d, tx %d: receipt mismatch: have %+v, want %+v", block.NumberU64(), i, receipt, want[i])
+			}
+		}
+	}
+	// Restart in normal mode, the omitted receipts must still be known as such
+	leanChain.stop()
+	if err := leanChain.open(); err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	lean = leanChain.blockchain
+	if report, err := lean.VerifyDatabase(false); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("receiptless blocks reported inconsistent: %v, %v", report, err)
+	}
+	for _, block := range chain {
+		if !HasOmittedReceipts(leanDb, block.Hash(), block.NumberU64()) {
+			t.Errorf("block %d: omitted receipts not marked", block.NumberU64())
+		}
+		if HasOmittedReceipts(fullDb, block.Hash(), block.NumberU64()) {
+			t.Errorf("block %d: stored receipts marked omitted", block.NumberU64())
+		}
+	}
+	tx := chain[0].Transactions()[0]
+	if receipt, _, _, _ := GetReceipt(leanDb, tx.Hash()); receipt != nil {
+		t.Errorf("omitted receipt found in the database")
+	}
+	if _, _, _, _, err := lean.GetReceipt(tx.Hash()); err != nil {
+		t.Errorf("failed to derive receipt after restart: %v", err)
+	}
+	// Drop the state of the parent of the last block
+	parent := chain[len(chain)-2]
+	leanDb.Delete(parent.Root().Bytes())
+	lean.stateDatabase().(interface {
+		Purge()
+	}).Purge()
+	if _, err := lean.GetReceiptsByHash(chain[len(chain)-1].Hash()); err != ErrStateUnavailable {
+		t.Errorf("receipts derived without parent state: %v", err)
+	}
+}
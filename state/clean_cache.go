@@ -0,0 +1,107 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+)
+
+// cleanCache is a memory limited LRU cache of trie nodes read from the
+// database, shared by all tries of a cachingDB. Nodes are keyed by their
+// hash, so cached nodes never go stale.
+type cleanCache struct {
+	lock  sync.Mutex
+	limit int // Maximum total size of the cached nodes
+	size  int // Total size of the cached nodes
+	items map[common.Hash]*list.Element
+	order *list.List // Cached nodes, most recently used first
+}
+
+type cleanEntry struct {
+	hash common.Hash
+	node []byte
+}
+
+func newCleanCache(limit int) *cleanCache {
+	return &cleanCache{
+		limit: limit,
+		items: make(map[common.Hash]*list.Element),
+		order: list.New(),
+	}
+}
+
+// get returns a copy of the cached node, so callers can't corrupt the cache.
+func (c *cleanCache) get(hash common.Hash) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	elem, ok := c.items[hash]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return common.CopyBytes(elem.Value.(*cleanEntry).node), true
+}
+
+// add caches the node, evicting the least recently used ones over the limit.
+func (c *cleanCache) add(hash common.Hash, node []byte) {
+	size := common.HashLength + len(node)
+	if size > c.limit {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.items[hash]; ok {
+		return
+	}
+	c.items[hash] = c.order.PushFront(&cleanEntry{hash: hash, node: common.CopyBytes(node)})
+	c.size += size
+
+	for c.size > c.limit {
+		entry := c.order.Remove(c.order.Back()).(*cleanEntry)
+		delete(c.items, entry.hash)
+		c.size -= common.HashLength + len(entry.node)
+		cleanNodeEvictCounter.Inc(1)
+	}
+}
+
+// purge drops all cached nodes.
+func (c *cleanCache) purge() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.items = make(map[common.Hash]*list.Element)
+	c.order.Init()
+	c.size = 0
+}
+
+// cleanReader is the trie database of a cachingDB with a clean cache. It
+// serves trie nodes from the cache and passes everything else, like trie
+// key preimages, to the database.
+type cleanReader struct {
+	ethdb.Database
+	cache *cleanCache
+}
+
+func (r *cleanReader) Get(key []byte) ([]byte, error) {
+	if len(key) != common.HashLength {
+		return r.Database.Get(key)
+	}
+	hash := common.BytesToHash(key)
+	if node, ok := r.cache.get(hash); ok {
+		cleanNodeHitCounter.Inc(1)
+		return node, nil
+	}
+	cleanNodeMissCounter.Inc(1)
+
+	node, err := r.Database.Get(key)
+	if err == nil {
+		r.cache.add(hash, node)
+	}
+	return node, err
+}
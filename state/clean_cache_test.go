@@ -0,0 +1,95 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+)
+
+// Tests that the clean cache stays within its memory limit, evicting the
+// least recently used nodes.
+func TestCleanCacheEviction(t *testing.T) {
+	entry := common.HashLength + 10
+	cache := newCleanCache(3 * entry)
+
+	for i := byte(1); i <= 3; i++ {
+		cache.add(common.Hash{i}, bytes.Repeat([]byte{i}, 10))
+	}
+	cache.get(common.Hash{1}) // Make 2 the least recently used
+	cache.add(common.Hash{4}, bytes.Repeat([]byte{4}, 10))
+
+	if cache.size != 3*entry {
+		t.Errorf("cache size mismatch: have %d, want %d", cache.size, 3*entry)
+	}
+	if _, ok := cache.get(common.Hash{2}); ok {
+		t.Errorf("least recently used node retained")
+	}
+	for _, i := range []byte{1, 3, 4} {
+		if node, ok := cache.get(common.Hash{i}); !ok || !bytes.Equal(node, bytes.Repeat([]byte{i}, 10)) {
+			t.Errorf("node %d: mismatch: have %x (%v)", i, node, ok)
+		}
+	}
+	// Returned nodes must not alias the cache
+	node, _ := cache.get(common.Hash{1})
+	node[0] = 0xff
+	if node, _ := cache.get(common.Hash{1}); node[0] != 1 {
+		t.Errorf("cached node modified through returned copy")
+	}
+	// Oversized nodes are not cached at all
+	cache.add(common.Hash{5}, make([]byte, 3*entry))
+	if _, ok := cache.get(common.Hash{5}); ok {
+		t.Errorf("oversized node cached")
+	}
+}
+
+// Tests that states read through the configured caches match the database.
+func TestCachedDatabase(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := New(common.Hash{}, NewDatabase(db))
+	for i := byte(0); i < 32; i++ {
+		addr := common.Address{i}
+		statedb.AddBalance(addr, big.NewInt(int64(i)+1))
+		statedb.SetState(addr, common.Hash{i}, common.Hash{i + 1})
+		statedb.SetCode(addr, []byte{0x60, i})
+	}
+	root, _ := statedb.CommitTo(db, true)
+
+	config := CacheConfig{CodeSizeCache: 16, CodeCache: 16, CleanCache: 4096}
+	sdb := NewDatabaseWithConfig(db, config, nil).(*cachingDB)
+	for round := 0; round < 2; round++ {
+		statedb, err := New(root, sdb)
+		if err != nil {
+			t.Fatalf("round %d: failed to open state: %v", round, err)
+		}
+		for i := byte(0); i < 32; i++ {
+			addr := common.Address{i}
+			if balance := statedb.GetBalance(addr); balance.Int64() != int64(i)+1 {
+				t.Errorf("round %d, account %x: balance mismatch: have %v", round, addr, balance)
+			}
+			if value := statedb.GetState(addr, common.Hash{i}); value != (common.Hash{i + 1}) {
+				t.Errorf("round %d, account %x: storage mismatch: have %x", round, addr, value)
+			}
+			if code := statedb.GetCode(addr); !bytes.Equal(code, []byte{0x60, i}) {
+				t.Errorf("round %d, account %x: code mismatch: have %x", round, addr, code)
+			}
+			if size := statedb.GetCodeSize(addr); size != 2 {
+				t.Errorf("round %d, account %x: code size mismatch: have %d", round, addr, size)
+			}
+		}
+		if sdb.clean.size == 0 || sdb.clean.size > config.CleanCache {
+			t.Errorf("round %d: clean cache size out of bounds: %d", round, sdb.clean.size)
+		}
+	}
+	if sdb.codeCache.Len() != config.CodeCache {
+		t.Errorf("code cache size mismatch: have %d, want %d", sdb.codeCache.Len(), config.CodeCache)
+	}
+	sdb.Purge()
+	if sdb.clean.size != 0 {
+		t.Errorf("clean cache not purged")
+	}
+}
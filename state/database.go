@@ -23,6 +23,7 @@ import (
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/combchain/go-combchain/common"
 	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/metrics"
 	"github.com/combchain/go-combchain/trie"
 )
 
@@ -38,6 +39,35 @@ const (
 	codeSizeCacheSize = 100000
 )
 
+var (
+	pastTrieHitCounter    = metrics.NewCounter("state/cache/tries/hits")
+	pastTrieMissCounter   = metrics.NewCounter("state/cache/tries/misses")
+	pastTrieEvictCounter  = metrics.NewCounter("state/cache/tries/evictions")
+	codeSizeHitCounter    = metrics.NewCounter("state/cache/codesize/hits")
+	codeSizeMissCounter   = metrics.NewCounter("state/cache/codesize/misses")
+	codeSizeEvictCounter  = metrics.NewCounter("state/cache/codesize/evictions")
+	codeHitCounter        = metrics.NewCounter("state/cache/code/hits")
+	codeMissCounter       = metrics.NewCounter("state/cache/code/misses")
+	codeEvictCounter      = metrics.NewCounter("state/cache/code/evictions")
+	cleanNodeHitCounter   = metrics.NewCounter("state/cache/clean/hits")
+	cleanNodeMissCounter  = metrics.NewCounter("state/cache/clean/misses")
+	cleanNodeEvictCounter = metrics.NewCounter("state/cache/clean/evictions")
+)
+
+// CacheConfig sets the sizes of the caches of a state database.
+type CacheConfig struct {
+	PastTries     int // Number of recently committed account tries kept in memory
+	CodeSizeCache int // Number of contract code sizes cached
+	CodeCache     int // Number of contract codes cached, 0 to disable
+	CleanCache    int // Memory in bytes for trie nodes shared by all tries, 0 to disable
+}
+
+// DefaultCacheConfig are the cache sizes of NewDatabase.
+var DefaultCacheConfig = CacheConfig{
+	PastTries:     maxPastTries,
+	CodeSizeCache: codeSizeCacheSize,
+}
+
 // Database wraps access to tries and contract code.
 type Database interface {
 	// Accessing tries:
@@ -61,20 +91,62 @@ type Trie interface {
 	Hash() common.Hash
 	NodeIterator(startKey []byte) trie.NodeIterator
 	GetKey([]byte) []byte // TODO(fjl): remove this when SecureTrie is removed
+	Prove(key []byte, fromLevel uint, proofDb trie.DatabaseWriter) error
 }
 
 // NewDatabase creates a backing store for state. The returned database is safe for
 // concurrent use and retains cached trie nodes in memory.
 func NewDatabase(db ethdb.Database) Database {
-	csc, _ := lru.New(codeSizeCacheSize)
-	return &cachingDB{db: db, codeSizeCache: csc}
+	return NewDatabaseWithConfig(db, DefaultCacheConfig, nil)
+}
+
+// NewDatabaseWithSnapshots creates a backing store for state like NewDatabase,
+// whose states read accounts and storage through the given snapshots.
+func NewDatabaseWithSnapshots(db ethdb.Database, snaps *Snapshots) Database {
+	return NewDatabaseWithConfig(db, DefaultCacheConfig, snaps)
+}
+
+// NewDatabaseWithConfig creates a backing store for state with the given
+// cache sizes, whose states read through the snapshots if not nil.
+func NewDatabaseWithConfig(db ethdb.Database, config CacheConfig, snaps *Snapshots) Database {
+	cdb := &cachingDB{db: db, nodes: db, maxPastTries: config.PastTries, snaps: snaps}
+	if config.CodeSizeCache > 0 {
+		cdb.codeSizeCache, _ = lru.NewWithEvict(config.CodeSizeCache, func(key, value interface{}) {
+			codeSizeEvictCounter.Inc(1)
+		})
+	}
+	if config.CodeCache > 0 {
+		cdb.codeCache, _ = lru.NewWithEvict(config.CodeCache, func(key, value interface{}) {
+			codeEvictCounter.Inc(1)
+		})
+	}
+	if config.CleanCache > 0 {
+		cdb.clean = newCleanCache(config.CleanCache)
+		cdb.nodes = &cleanReader{Database: db, cache: cdb.clean}
+	}
+	return cdb
 }
 
 type cachingDB struct {
 	db            ethdb.Database
+	nodes         trie.Database // Trie node reader, db or a cleanReader on it
 	mu            sync.Mutex
 	pastTries     []*trie.SecureTrie
-	codeSizeCache *lru.Cache
+	maxPastTries  int
+	codeSizeCache *lru.Cache  // nil if disabled
+	codeCache     *lru.Cache  // nil if disabled
+	clean         *cleanCache // nil if disabled
+	snaps         *Snapshots
+}
+
+// DiskDB returns the key-value store the tries and codes are read from.
+func (db *cachingDB) DiskDB() ethdb.Database {
+	return db.db
+}
+
+// Snapshots returns the state snapshots of the database, nil if it has none.
+func (db *cachingDB) Snapshots() *Snapshots {
+	return db.snaps
 }
 
 func (db *cachingDB) OpenTrie(root common.Hash) (Trie, error) {
@@ -83,10 +155,12 @@ func (db *cachingDB) OpenTrie(root common.Hash) (Trie, error) {
 
 	for i := len(db.pastTries) - 1; i >= 0; i-- {
 		if db.pastTries[i].Hash() == root {
+			pastTrieHitCounter.Inc(1)
 			return cachedTrie{db.pastTries[i].Copy(), db}, nil
 		}
 	}
-	tr, err := trie.NewSecure(root, db.db, MaxTrieCacheGen)
+	pastTrieMissCounter.Inc(1)
+	tr, err := trie.NewSecure(root, db.nodes, MaxTrieCacheGen)
 	if err != nil {
 		return nil, err
 	}
@@ -97,7 +171,11 @@ func (db *cachingDB) pushTrie(t *trie.SecureTrie) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if len(db.pastTries) >= maxPastTries {
+	if db.maxPastTries <= 0 {
+		return
+	}
+	if len(db.pastTries) >= db.maxPastTries {
+		pastTrieEvictCounter.Inc(1)
 		copy(db.pastTries, db.pastTries[1:])
 		db.pastTries[len(db.pastTries)-1] = t
 	} else {
@@ -105,8 +183,21 @@ func (db *cachingDB) pushTrie(t *trie.SecureTrie) {
 	}
 }
 
+// Purge drops the cached past tries and trie nodes, forcing them to be
+// reloaded from the database. It is used after trie nodes have been deleted
+// from it.
+func (db *cachingDB) Purge() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.pastTries = nil
+	if db.clean != nil {
+		db.clean.purge()
+	}
+}
+
 func (db *cachingDB) OpenStorageTrie(addrHash, root common.Hash) (Trie, error) {
-	return trie.NewSecure(root, db.db, 0)
+	return trie.NewSecure(root, db.nodes, 0)
 }
 
 func (db *cachingDB) CopyTrie(t Trie) Trie {
@@ -121,21 +212,34 @@ func (db *cachingDB) CopyTrie(t Trie) Trie {
 }
 
 func (db *cachingDB) ContractCode(addrHash, codeHash common.Hash) ([]byte, error) {
+	if db.codeCache != nil {
+		if cached, ok := db.codeCache.Get(codeHash); ok {
+			codeHitCounter.Inc(1)
+			return cached.([]byte), nil
+		}
+		codeMissCounter.Inc(1)
+	}
 	code, err := db.db.Get(codeHash[:])
 	if err == nil {
-		db.codeSizeCache.Add(codeHash, len(code))
+		if db.codeCache != nil {
+			db.codeCache.Add(codeHash, code)
+		}
+		if db.codeSizeCache != nil {
+			db.codeSizeCache.Add(codeHash, len(code))
+		}
 	}
 	return code, err
 }
 
 func (db *cachingDB) ContractCodeSize(addrHash, codeHash common.Hash) (int, error) {
-	if cached, ok := db.codeSizeCache.Get(codeHash); ok {
-		return cached.(int), nil
+	if db.codeSizeCache != nil {
+		if cached, ok := db.codeSizeCache.Get(codeHash); ok {
+			codeSizeHitCounter.Inc(1)
+			return cached.(int), nil
+		}
+		codeSizeMissCounter.Inc(1)
 	}
 	code, err := db.ContractCode(addrHash, codeHash)
-	if err == nil {
-		db.codeSizeCache.Add(codeHash, len(code))
-	}
 	return len(code), err
 }
 
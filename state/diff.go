@@ -0,0 +1,274 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/common/hexutil"
+)
+
+// StateDiff is the change of the state made by a transaction or a block,
+// with the values before and after the change. Accounts and storage slots
+// changed and restored in between are left out.
+type StateDiff struct {
+	Accounts map[common.Address]*AccountDiff `json:"accounts"`
+}
+
+// AccountDiff is the change of a single account. Unchanged fields are nil.
+type AccountDiff struct {
+	Existed          bool                      `json:"existed"` // Whether the account existed before
+	Exists           bool                      `json:"exists"`  // Whether the account exists after
+	Balance          *BalanceDiff              `json:"balance,omitempty"`
+	Nonce            *NonceDiff                `json:"nonce,omitempty"`
+	CodeHash         *HashDiff                 `json:"codeHash,omitempty"`
+	Storage          map[common.Hash]HashDiff  `json:"storage,omitempty"`          // Slots written by SetState
+	StorageByteArray map[common.Hash]BytesDiff `json:"storageByteArray,omitempty"` // Slots written by SetStateByteArray
+}
+
+type BalanceDiff struct {
+	From *big.Int `json:"from"`
+	To   *big.Int `json:"to"`
+}
+
+type NonceDiff struct {
+	From uint64 `json:"from"`
+	To   uint64 `json:"to"`
+}
+
+type HashDiff struct {
+	From common.Hash `json:"from"`
+	To   common.Hash `json:"to"`
+}
+
+type BytesDiff struct {
+	From hexutil.Bytes `json:"from"`
+	To   hexutil.Bytes `json:"to"`
+}
+
+// NewStateDiff creates an empty state diff.
+func NewStateDiff() *StateDiff {
+	return &StateDiff{Accounts: make(map[common.Address]*AccountDiff)}
+}
+
+// Merge adds the changes of next, made after the ones of d, to d.
+func (d *StateDiff) Merge(next *StateDiff) {
+	for addr, nd := range next.Accounts {
+		ad := d.Accounts[addr]
+		if ad == nil {
+			ad = &AccountDiff{Existed: nd.Existed}
+			d.Accounts[addr] = ad
+		}
+		ad.Exists = nd.Exists
+		if nd.Balance != nil {
+			from := nd.Balance.From
+			if ad.Balance != nil {
+				from = ad.Balance.From
+			}
+			ad.Balance = &BalanceDiff{From: from, To: nd.Balance.To}
+			if from.Cmp(nd.Balance.To) == 0 {
+				ad.Balance = nil
+			}
+		}
+		if nd.Nonce != nil {
+			from := nd.Nonce.From
+			if ad.Nonce != nil {
+				from = ad.Nonce.From
+			}
+			ad.Nonce = &NonceDiff{From: from, To: nd.Nonce.To}
+			if from == nd.Nonce.To {
+				ad.Nonce = nil
+			}
+		}
+		if nd.CodeHash != nil {
+			from := nd.CodeHash.From
+			if ad.CodeHash != nil {
+				from = ad.CodeHash.From
+			}
+			ad.CodeHash = &HashDiff{From: from, To: nd.CodeHash.To}
+			if from == nd.CodeHash.To {
+				ad.CodeHash = nil
+			}
+		}
+		for key, sd := range nd.Storage {
+			if ad.Storage == nil {
+				ad.Storage = make(map[common.Hash]HashDiff)
+			}
+			if prev, ok := ad.Storage[key]; ok {
+				sd.From = prev.From
+			}
+			if sd.From == sd.To {
+				delete(ad.Storage, key)
+			} else {
+				ad.Storage[key] = sd
+			}
+		}
+		for key, sd := range nd.StorageByteArray {
+			if ad.StorageByteArray == nil {
+				ad.StorageByteArray = make(map[common.Hash]BytesDiff)
+			}
+			if prev, ok := ad.StorageByteArray[key]; ok {
+				sd.From = prev.From
+			}
+			if bytes.Equal(sd.From, sd.To) {
+				delete(ad.StorageByteArray, key)
+			} else {
+				ad.StorageByteArray[key] = sd
+			}
+		}
+		if !ad.changed() {
+			delete(d.Accounts, addr)
+		}
+	}
+}
+
+func (ad *AccountDiff) changed() bool {
+	return ad.Existed != ad.Exists || ad.Balance != nil || ad.Nonce != nil || ad.CodeHash != nil ||
+		len(ad.Storage) > 0 || len(ad.StorageByteArray) > 0
+}
+
+// diffRecorder collects the values of the accounts and storage slots before
+// their first change, as reported by the journal entries.
+type diffRecorder struct {
+	accounts map[common.Address]accountValues
+	storage  map[common.Address]map[common.Hash]common.Hash
+	arrays   map[common.Address]map[common.Hash][]byte
+}
+
+type accountValues struct {
+	exists   bool
+	balance  *big.Int
+	nonce    uint64
+	codeHash common.Hash
+}
+
+func newAccountValues(obj *stateObject) accountValues {
+	if obj == nil {
+		return accountValues{balance: new(big.Int)}
+	}
+	return accountValues{
+		exists:   true,
+		balance:  new(big.Int).Set(obj.Balance()),
+		nonce:    obj.Nonce(),
+		codeHash: common.BytesToHash(obj.CodeHash()),
+	}
+}
+
+// StartDiff starts recording the changes made to the state, until StopDiff.
+// Changes merged from another state by Merge are not recorded.
+func (self *StateDB) StartDiff() {
+	self.diff = &diffRecorder{
+		accounts: make(map[common.Address]accountValues),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+		arrays:   make(map[common.Address]map[common.Hash][]byte),
+	}
+}
+
+// StopDiff ends the recording started by StartDiff and returns the changes
+// made in between, nil if not recording.
+func (self *StateDB) StopDiff() *StateDiff {
+	d := self.diff
+	if d == nil {
+		return nil
+	}
+	self.diff = nil
+
+	diff := NewStateDiff()
+	for addr, before := range d.accounts {
+		obj := self.getStateObject(addr)
+		after := newAccountValues(obj)
+
+		ad := &AccountDiff{Existed: before.exists, Exists: after.exists}
+		if before.balance.Cmp(after.balance) != 0 {
+			ad.Balance = &BalanceDiff{From: before.balance, To: after.balance}
+		}
+		if before.nonce != after.nonce {
+			ad.Nonce = &NonceDiff{From: before.nonce, To: after.nonce}
+		}
+		if before.codeHash != after.codeHash {
+			ad.CodeHash = &HashDiff{From: before.codeHash, To: after.codeHash}
+		}
+		for key, from := range d.storage[addr] {
+			var to common.Hash
+			if obj != nil {
+				to = obj.GetState(self.db, key)
+			}
+			if from != to {
+				if ad.Storage == nil {
+					ad.Storage = make(map[common.Hash]HashDiff)
+				}
+				ad.Storage[key] = HashDiff{From: from, To: to}
+			}
+		}
+		for key, from := range d.arrays[addr] {
+			var to []byte
+			if obj != nil {
+				to = common.CopyBytes(obj.GetStateByteArray(self.db, key))
+			}
+			if !bytes.Equal(from, to) {
+				if ad.StorageByteArray == nil {
+					ad.StorageByteArray = make(map[common.Hash]BytesDiff)
+				}
+				ad.StorageByteArray[key] = BytesDiff{From: from, To: to}
+			}
+		}
+		if ad.changed() {
+			diff.Accounts[addr] = ad
+		}
+	}
+	return diff
+}
+
+// appendJournal adds a state modification to the journal. It must be called
+// before the modification is applied.
+func (self *StateDB) appendJournal(entry journalEntry) {
+	self.journal = append(self.journal, entry)
+	if self.diff != nil {
+		self.recordDiff(entry)
+	}
+}
+
+// recordDiff saves the values the journal entry is about to change, unless
+// they have been changed before.
+func (self *StateDB) recordDiff(entry journalEntry) {
+	var addr common.Address
+	switch ch := entry.(type) {
+	case createObjectChange:
+		addr = *ch.account
+	case resetObjectChange:
+		addr = ch.prev.address
+	case suicideChange:
+		addr = *ch.account
+	case touchChange:
+		addr = *ch.account
+	case balanceChange:
+		addr = *ch.account
+	case nonceChange:
+		addr = *ch.account
+	case codeChange:
+		addr = *ch.account
+	case storageChange:
+		addr = *ch.account
+		if self.diff.storage[addr] == nil {
+			self.diff.storage[addr] = make(map[common.Hash]common.Hash)
+		}
+		if _, ok := self.diff.storage[addr][ch.key]; !ok {
+			self.diff.storage[addr][ch.key] = ch.prevalue
+		}
+	case storageByteArrayChange:
+		addr = *ch.account
+		if self.diff.arrays[addr] == nil {
+			self.diff.arrays[addr] = make(map[common.Hash][]byte)
+		}
+		if _, ok := self.diff.arrays[addr][ch.key]; !ok {
+			self.diff.arrays[addr][ch.key] = common.CopyBytes(ch.prevalue)
+		}
+	default:
+		return
+	}
+	if _, ok := self.diff.accounts[addr]; !ok {
+		self.diff.accounts[addr] = newAccountValues(self.getStateObject(addr))
+	}
+}
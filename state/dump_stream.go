@@ -0,0 +1,387 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/common/hexutil"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/trie"
+)
+
+// Kinds of records in a streamed state dump.
+const (
+	DumpKindRoot    = iota // Root of the dumped state, starts every dump
+	DumpKindAccount        // Account, followed by its storage slots
+	DumpKindStorage        // Storage slot of the preceding account
+)
+
+var (
+	// preimagePrefix is the key prefix the secure tries store preimages under.
+	preimagePrefix = []byte("secure-key-")
+
+	errDumpRootMismatch    = errors.New("dump of a different state")
+	errDumpStorageMismatch = errors.New("imported storage root mismatch")
+	errDumpOrphanStorage   = errors.New("storage record without account")
+	errDumpUnknownKind     = errors.New("unknown dump record kind")
+
+	// ErrDumpRootMismatch is returned if an imported state doesn't match the
+	// root of the dump.
+	ErrDumpRootMismatch = errors.New("imported state root mismatch")
+)
+
+// DumpRecord is a single record of a streamed state dump. Accounts and
+// storage slots are keyed by the hashes used in the secure tries, the keys
+// themselves are only included if their preimages are known. Storage values
+// are the raw trie values, which covers both the RLP encoded hashes written
+// by SetState and the byte arrays written by SetStateByteArray.
+type DumpRecord struct {
+	Kind    uint8         `json:"kind"`
+	Hash    common.Hash   `json:"hash"`              // State root, account address hash or storage key hash
+	Key     hexutil.Bytes `json:"key,omitempty"`     // Account address or storage key, if known
+	Nonce   uint64        `json:"nonce,omitempty"`   // Account nonce
+	Balance *big.Int      `json:"balance,omitempty"` // Account balance
+	Root    common.Hash   `json:"root"`              // Account storage root
+	Code    hexutil.Bytes `json:"code,omitempty"`    // Account contract code
+	Value   hexutil.Bytes `json:"value,omitempty"`   // Storage value
+}
+
+// DumpWriter writes the records of a streamed state dump.
+type DumpWriter interface {
+	WriteRecord(rec *DumpRecord) error
+}
+
+// DumpReader reads the records of a streamed state dump, returning io.EOF
+// at its end.
+type DumpReader interface {
+	ReadRecord() (*DumpRecord, error)
+}
+
+type jsonDumpWriter struct{ enc *json.Encoder }
+type jsonDumpReader struct{ dec *json.Decoder }
+type rlpDumpWriter struct{ w io.Writer }
+type rlpDumpReader struct{ s *rlp.Stream }
+
+// NewJSONDumpWriter creates a dump writer emitting one JSON record per line.
+func NewJSONDumpWriter(w io.Writer) DumpWriter { return &jsonDumpWriter{json.NewEncoder(w)} }
+
+// NewJSONDumpReader creates a dump reader of JSON records.
+func NewJSONDumpReader(r io.Reader) DumpReader { return &jsonDumpReader{json.NewDecoder(r)} }
+
+// NewRLPDumpWriter creates a dump writer emitting a stream of RLP records.
+func NewRLPDumpWriter(w io.Writer) DumpWriter { return &rlpDumpWriter{w} }
+
+// NewRLPDumpReader creates a dump reader of RLP records.
+func NewRLPDumpReader(r io.Reader) DumpReader { return &rlpDumpReader{rlp.NewStream(r, 0)} }
+
+func (w *jsonDumpWriter) WriteRecord(rec *DumpRecord) error { return w.enc.Encode(rec) }
+func (w *rlpDumpWriter) WriteRecord(rec *DumpRecord) error  { return rlp.Encode(w.w, rec) }
+
+func (r *jsonDumpReader) ReadRecord() (*DumpRecord, error) {
+	rec := new(DumpRecord)
+	if err := r.dec.Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+func (r *rlpDumpReader) ReadRecord() (*DumpRecord, error) {
+	rec := new(DumpRecord)
+	if err := r.s.Decode(rec); err != nil {
+		return nil, err
+	}
+	return rec, nil
+}
+
+// DumpCursor is a position in the dump of a state, the account and the
+// storage slot within it to continue from. The zero cursor is the start.
+type DumpCursor struct {
+	Account common.Hash
+	Storage common.Hash
+}
+
+// DumpTo streams the state, starting at the cursor, to w. It stops after
+// limit account and storage records, 0 for no limit, and returns the cursor
+// to resume from, nil if the dump is complete. Every chunk starts with the
+// state root and the account the cursor is in, so that chunks can be
+// imported independently. The repeated account doesn't count toward the
+// limit, so every chunk makes progress. Changes not yet committed are not
+// dumped.
+func (self *StateDB) DumpTo(w DumpWriter, cursor DumpCursor, limit int) (*DumpCursor, error) {
+	if err := w.WriteRecord(&DumpRecord{Kind: DumpKindRoot, Hash: self.trie.Hash()}); err != nil {
+		return nil, err
+	}
+	written := 0
+	it := trie.NewIterator(self.trie.NodeIterator(cursor.Account[:]))
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		if limit > 0 && written >= limit {
+			return &DumpCursor{Account: hash}, nil
+		}
+		var data Account
+		if err := rlp.DecodeBytes(it.Value, &data); err != nil {
+			return nil, err
+		}
+		rec := &DumpRecord{
+			Kind:    DumpKindAccount,
+			Hash:    hash,
+			Key:     self.trie.GetKey(it.Key),
+			Nonce:   data.Nonce,
+			Balance: data.Balance,
+			Root:    data.Root,
+		}
+		if codeHash := common.BytesToHash(data.CodeHash); codeHash != common.BytesToHash(emptyCodeHash) {
+			code, err := self.db.ContractCode(hash, codeHash)
+			if err != nil {
+				return nil, err
+			}
+			rec.Code = code
+		}
+		if err := w.WriteRecord(rec); err != nil {
+			return nil, err
+		}
+		if hash != cursor.Account {
+			written++
+		}
+		var start []byte
+		if hash == cursor.Account {
+			start = cursor.Storage[:]
+		}
+		st, err := self.db.OpenStorageTrie(hash, data.Root)
+		if err != nil {
+			return nil, err
+		}
+		sit := trie.NewIterator(st.NodeIterator(start))
+		for sit.Next() {
+			if limit > 0 && written >= limit {
+				return &DumpCursor{Account: hash, Storage: common.BytesToHash(sit.Key)}, nil
+			}
+			err := w.WriteRecord(&DumpRecord{
+				Kind:  DumpKindStorage,
+				Hash:  common.BytesToHash(sit.Key),
+				Key:   self.trie.GetKey(sit.Key),
+				Value: sit.Value,
+			})
+			if err != nil {
+				return nil, err
+			}
+			written++
+		}
+		if sit.Err != nil {
+			return nil, sit.Err
+		}
+	}
+	return nil, it.Err
+}
+
+// ImportProgress is the state of an interrupted import, from which it can be
+// resumed with NewStateImporter.
+type ImportProgress struct {
+	Root     common.Hash // Root of the imported state
+	Accounts common.Hash // Root of the accounts imported so far
+	Account  *DumpRecord // Account whose storage is being imported, nil if none
+	Storage  common.Hash // Root of its storage imported so far
+	Cursor   DumpCursor  // Position to resume the dump from
+}
+
+// StateImporter writes a streamed state dump into a database, building the
+// tries from the hashed keys, so the preimages of the keys are not needed.
+// Known preimages are stored along.
+type StateImporter struct {
+	db       ethdb.Database
+	root     common.Hash
+	accounts *trie.Trie
+	account  *DumpRecord
+	storage  *trie.Trie
+	cursor   DumpCursor
+}
+
+// NewStateImporter creates an importer into db, resuming from the progress
+// of an interrupted import if not nil.
+func NewStateImporter(db ethdb.Database, progress *ImportProgress) (*StateImporter, error) {
+	if progress == nil {
+		progress = new(ImportProgress)
+	}
+	imp := &StateImporter{
+		db:      db,
+		root:    progress.Root,
+		account: progress.Account,
+		cursor:  progress.Cursor,
+	}
+	var err error
+	if imp.accounts, err = trie.New(progress.Accounts, db); err != nil {
+		return nil, err
+	}
+	if imp.account != nil {
+		if imp.storage, err = trie.New(progress.Storage, db); err != nil {
+			return nil, err
+		}
+	}
+	return imp, nil
+}
+
+// Import writes the records read from r until its end.
+func (imp *StateImporter) Import(r DumpReader) error {
+	for {
+		rec, err := r.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := imp.importRecord(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func (imp *StateImporter) importRecord(rec *DumpRecord) error {
+	switch rec.Kind {
+	case DumpKindRoot:
+		if imp.root == (common.Hash{}) {
+			imp.root = rec.Hash
+		}
+		if imp.root != rec.Hash {
+			return errDumpRootMismatch
+		}
+
+	case DumpKindAccount:
+		// Resumed chunks repeat the account they start in
+		if imp.account != nil && imp.account.Hash == rec.Hash {
+			return nil
+		}
+		if err := imp.flushAccount(); err != nil {
+			return err
+		}
+		if len(rec.Code) > 0 {
+			if err := imp.db.Put(crypto.Keccak256(rec.Code), rec.Code); err != nil {
+				return err
+			}
+		}
+		if err := imp.writePreimage(rec.Hash, rec.Key); err != nil {
+			return err
+		}
+		storage, err := trie.New(common.Hash{}, imp.db)
+		if err != nil {
+			return err
+		}
+		imp.account, imp.storage = rec, storage
+		imp.cursor = DumpCursor{Account: rec.Hash}
+
+	case DumpKindStorage:
+		if imp.account == nil {
+			return errDumpOrphanStorage
+		}
+		if err := imp.storage.TryUpdate(rec.Hash[:], rec.Value); err != nil {
+			return err
+		}
+		if err := imp.writePreimage(rec.Hash, rec.Key); err != nil {
+			return err
+		}
+		imp.cursor = DumpCursor{Account: imp.account.Hash, Storage: incHash(rec.Hash)}
+
+	default:
+		return errDumpUnknownKind
+	}
+	return nil
+}
+
+// flushAccount writes the pending account with its storage into the account
+// trie, checking that the storage matches its root.
+func (imp *StateImporter) flushAccount() error {
+	if imp.account == nil {
+		return nil
+	}
+	rec := imp.account
+	root, err := imp.storage.CommitTo(imp.db)
+	if err != nil {
+		return err
+	}
+	if root != rec.Root && !(root == emptyTrieRoot && rec.Root == (common.Hash{})) {
+		return errDumpStorageMismatch
+	}
+	data := Account{
+		Nonce:    rec.Nonce,
+		Balance:  rec.Balance,
+		Root:     rec.Root,
+		CodeHash: emptyCodeHash,
+	}
+	if data.Balance == nil {
+		data.Balance = new(big.Int)
+	}
+	if len(rec.Code) > 0 {
+		data.CodeHash = crypto.Keccak256(rec.Code)
+	}
+	enc, err := rlp.EncodeToBytes(&data)
+	if err != nil {
+		return err
+	}
+	if err := imp.accounts.TryUpdate(rec.Hash[:], enc); err != nil {
+		return err
+	}
+	imp.account, imp.storage = nil, nil
+	imp.cursor = DumpCursor{Account: incHash(rec.Hash)}
+	return nil
+}
+
+func (imp *StateImporter) writePreimage(hash common.Hash, key []byte) error {
+	if len(key) == 0 {
+		return nil
+	}
+	return imp.db.Put(append(append([]byte{}, preimagePrefix...), hash[:]...), key)
+}
+
+// Progress writes the tries imported so far to the database and returns the
+// progress to resume the import from.
+func (imp *StateImporter) Progress() (*ImportProgress, error) {
+	progress := &ImportProgress{
+		Root:    imp.root,
+		Account: imp.account,
+		Cursor:  imp.cursor,
+	}
+	var err error
+	if progress.Accounts, err = imp.accounts.CommitTo(imp.db); err != nil {
+		return nil, err
+	}
+	if imp.storage != nil {
+		if progress.Storage, err = imp.storage.CommitTo(imp.db); err != nil {
+			return nil, err
+		}
+	}
+	return progress, nil
+}
+
+// Finish completes the import, returning the root of the imported state. It
+// fails if the root doesn't match the one of the dump.
+func (imp *StateImporter) Finish() (common.Hash, error) {
+	if err := imp.flushAccount(); err != nil {
+		return common.Hash{}, err
+	}
+	root, err := imp.accounts.CommitTo(imp.db)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if root != imp.root {
+		return root, ErrDumpRootMismatch
+	}
+	return root, nil
+}
+
+// incHash returns the hash following h, the position after it in a dump.
+func incHash(h common.Hash) common.Hash {
+	for i := len(h) - 1; i >= 0; i-- {
+		h[i]++
+		if h[i] != 0 {
+			break
+		}
+	}
+	return h
+}
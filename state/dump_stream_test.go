@@ -0,0 +1,116 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+)
+
+// Tests that a streamed dump imported in resumed chunks, or in one go, yields
+// the dumped state, with both storage kinds and the preimages of the keys.
+func TestDumpStream(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := New(common.Hash{}, NewDatabase(db))
+
+	for i := byte(0); i < 16; i++ {
+		addr := common.Address{i}
+		statedb.AddBalance(addr, big.NewInt(int64(i)+1))
+		statedb.SetNonce(addr, uint64(i))
+		if i%3 == 0 {
+			statedb.SetCode(addr, []byte{i, i, i})
+			for j := byte(0); j < i; j++ {
+				statedb.SetState(addr, common.Hash{j}, common.Hash{i, j})
+			}
+		}
+		if i%4 == 1 {
+			statedb.SetStateByteArray(addr, common.Hash{i}, bytes.Repeat([]byte{i}, 66))
+		}
+	}
+	root, _ := statedb.CommitTo(db, true)
+
+	// Dump and import in chunks, restarting the importer for every chunk and
+	// resuming the dump from its progress. Single record chunks must make
+	// progress too.
+	var chunkedDbs []ethdb.Database
+	for _, limit := range []int{1, 5} {
+		chunked, _ := ethdb.NewMemDatabase()
+		var (
+			cursor   = new(DumpCursor)
+			progress = new(ImportProgress)
+			chunks   int
+		)
+		for cursor != nil {
+			if chunks > 1000 {
+				t.Fatalf("limit %d: dump not progressing at %x", limit, progress.Cursor)
+			}
+			var (
+				buf bytes.Buffer
+				err error
+			)
+			if cursor, err = statedb.DumpTo(NewJSONDumpWriter(&buf), progress.Cursor, limit); err != nil {
+				t.Fatalf("limit %d: failed to dump chunk %d: %v", limit, chunks, err)
+			}
+			imp, err := NewStateImporter(chunked, progress)
+			if err != nil {
+				t.Fatalf("limit %d: failed to resume import: %v", limit, err)
+			}
+			if err := imp.Import(NewJSONDumpReader(&buf)); err != nil {
+				t.Fatalf("limit %d: failed to import chunk %d: %v", limit, chunks, err)
+			}
+			if cursor == nil {
+				if have, err := imp.Finish(); err != nil || have != root {
+					t.Fatalf("limit %d: chunked import root mismatch: have %x (%v), want %x", limit, have, err, root)
+				}
+			} else if progress, err = imp.Progress(); err != nil {
+				t.Fatalf("limit %d: failed to save import progress: %v", limit, err)
+			}
+			chunks++
+		}
+		if chunks < 2 {
+			t.Errorf("limit %d: dump not chunked", limit)
+		}
+		chunkedDbs = append(chunkedDbs, chunked)
+	}
+	// Dump and import in one go
+	var buf bytes.Buffer
+	if _, err := statedb.DumpTo(NewRLPDumpWriter(&buf), DumpCursor{}, 0); err != nil {
+		t.Fatalf("failed to dump state: %v", err)
+	}
+	whole, _ := ethdb.NewMemDatabase()
+	imp, _ := NewStateImporter(whole, nil)
+	if err := imp.Import(NewRLPDumpReader(&buf)); err != nil {
+		t.Fatalf("failed to import state: %v", err)
+	}
+	if have, err := imp.Finish(); err != nil || have != root {
+		t.Fatalf("import root mismatch: have %x (%v), want %x", have, err, root)
+	}
+	// The imported state must be readable, including byte array scans
+	for _, db := range append(chunkedDbs, whole) {
+		imported, err := New(root, NewDatabase(db))
+		if err != nil {
+			t.Fatalf("failed to open imported state: %v", err)
+		}
+		if have, want := imported.GetState(common.Address{9}, common.Hash{4}), (common.Hash{9, 4}); have != want {
+			t.Errorf("imported storage mismatch: have %x, want %x", have, want)
+		}
+		if have := imported.GetCode(common.Address{6}); !bytes.Equal(have, []byte{6, 6, 6}) {
+			t.Errorf("imported code mismatch: have %x", have)
+		}
+		var values [][]byte
+		imported.ForEachStorageByteArray(common.Address{5}, func(key common.Hash, value []byte) bool {
+			if key != (common.Hash{5}) {
+				t.Errorf("imported byte array key mismatch: have %x, want %x", key, common.Hash{5})
+			}
+			values = append(values, value)
+			return true
+		})
+		if len(values) != 1 || !bytes.Equal(values[0], bytes.Repeat([]byte{5}, 66)) {
+			t.Errorf("imported byte arrays mismatch: have %x", values)
+		}
+	}
+}
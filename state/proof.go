@@ -0,0 +1,130 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"errors"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/trie"
+)
+
+var (
+	// ErrInvalidProof is returned if a proof doesn't match the root it is
+	// verified against.
+	ErrInvalidProof = errors.New("invalid merkle proof")
+)
+
+// ProofList is a Merkle proof, the encoded trie nodes on the path from the
+// root to a key. It can be used as the proof database of trie.Prove.
+type ProofList [][]byte
+
+// Put implements trie.DatabaseWriter.
+func (n *ProofList) Put(key []byte, value []byte) error {
+	*n = append(*n, common.CopyBytes(value))
+	return nil
+}
+
+// AccountProof proves an account, or its absence, in the state trie and
+// the given storage slots, or their absence, in its storage trie.
+type AccountProof struct {
+	Address common.Address
+	Proof   ProofList // Account trie nodes on the path to the account
+	Storage []StorageProof
+}
+
+// StorageProof proves a storage slot of an account. It covers both storage
+// kinds, the proven value is the RLP encoded hash written by SetState or the
+// raw bytes written by SetStateByteArray.
+type StorageProof struct {
+	Key   common.Hash
+	Proof ProofList // Storage trie nodes on the path to the slot
+}
+
+// GetProof returns the Merkle proof of the account and the given storage
+// slots. Changes not yet finalised, e.g. by IntermediateRoot, are not
+// reflected in the account proof.
+func (self *StateDB) GetProof(addr common.Address, keys []common.Hash) (*AccountProof, error) {
+	proof := &AccountProof{Address: addr}
+	if err := self.trie.Prove(addr[:], 0, &proof.Proof); err != nil {
+		return nil, err
+	}
+	st := self.StorageTrie(addr)
+	if st == nil {
+		return proof, nil
+	}
+	for _, key := range keys {
+		storage := StorageProof{Key: key}
+		if err := st.Prove(key[:], 0, &storage.Proof); err != nil {
+			return nil, err
+		}
+		proof.Storage = append(proof.Storage, storage)
+	}
+	return proof, self.Error()
+}
+
+// Verify checks the proof against a state root. It returns the proven
+// account, nil if it doesn't exist, and the raw values of the proven storage
+// slots in the order of p.Storage, nil for empty ones.
+func (p *AccountProof) Verify(root common.Hash) (*Account, [][]byte, error) {
+	account, err := VerifyAccountProof(root, p.Address, p.Proof)
+	if err != nil {
+		return nil, nil, err
+	}
+	if account == nil {
+		if len(p.Storage) > 0 {
+			return nil, nil, ErrInvalidProof
+		}
+		return nil, nil, nil
+	}
+	values := make([][]byte, len(p.Storage))
+	for i, storage := range p.Storage {
+		if values[i], err = VerifyStorageProof(account.Root, storage.Key, storage.Proof); err != nil {
+			return nil, nil, err
+		}
+	}
+	return account, values, nil
+}
+
+// VerifyAccountProof checks the proof of an account against a state root,
+// returning the account, nil if the proof shows it doesn't exist.
+func VerifyAccountProof(root common.Hash, addr common.Address, proof ProofList) (*Account, error) {
+	enc, err := verifyProof(root, addr[:], proof)
+	if err != nil || enc == nil {
+		return nil, err
+	}
+	account := new(Account)
+	if err := rlp.DecodeBytes(enc, account); err != nil {
+		return nil, ErrInvalidProof
+	}
+	return account, nil
+}
+
+// VerifyStorageProof checks the proof of a storage slot against the storage
+// root of its account, returning the raw value, nil if the slot is empty.
+func VerifyStorageProof(storageRoot common.Hash, key common.Hash, proof ProofList) ([]byte, error) {
+	return verifyProof(storageRoot, key[:], proof)
+}
+
+// verifyProof checks the proof of a secure trie key against the trie root.
+func verifyProof(root common.Hash, key []byte, proof ProofList) ([]byte, error) {
+	// An empty trie has no root node to prove against
+	if root == emptyTrieRoot || root == (common.Hash{}) {
+		if len(proof) > 0 {
+			return nil, ErrInvalidProof
+		}
+		return nil, nil
+	}
+	db, _ := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	value, err, _ := trie.VerifyProof(root, crypto.Keccak256(key), db)
+	if err != nil {
+		return nil, ErrInvalidProof
+	}
+	return value, nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+)
+
+// Tests that account and storage proofs of both storage kinds verify against
+// the state root, prove absence, and fail against a different root.
+func TestProofs(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := New(common.Hash{}, NewDatabase(db))
+
+	var (
+		addr    = common.Address{0x01}
+		missing = common.Address{0x02}
+		slot    = common.Hash{0x0a}
+		array   = common.Hash{0x0b}
+		empty   = common.Hash{0x0c}
+	)
+	statedb.AddBalance(addr, big.NewInt(42))
+	statedb.SetState(addr, slot, common.Hash{0xff})
+	statedb.SetStateByteArray(addr, array, []byte("one-time address"))
+	for i := byte(0); i < 64; i++ {
+		statedb.AddBalance(common.Address{0x10, i}, big.NewInt(int64(i)+1))
+	}
+	root, _ := statedb.CommitTo(db, true)
+
+	proof, err := statedb.GetProof(addr, []common.Hash{slot, array, empty})
+	if err != nil {
+		t.Fatalf("failed to create proof: %v", err)
+	}
+	account, values, err := proof.Verify(root)
+	if err != nil {
+		t.Fatalf("failed to verify proof: %v", err)
+	}
+	if account == nil || account.Balance.Cmp(big.NewInt(42)) != 0 {
+		t.Fatalf("proven account mismatch: have %v", account)
+	}
+	if want, _ := rlp.EncodeToBytes([]byte{0xff}); !bytes.Equal(values[0], want) {
+		t.Errorf("proven slot mismatch: have %x, want %x", values[0], want)
+	}
+	if !bytes.Equal(values[1], []byte("one-time address")) {
+		t.Errorf("proven byte array mismatch: have %q", values[1])
+	}
+	if values[2] != nil {
+		t.Errorf("proven empty slot mismatch: have %x, want nil", values[2])
+	}
+	// Absent accounts must be provable
+	proof, err = statedb.GetProof(missing, []common.Hash{slot})
+	if err != nil {
+		t.Fatalf("failed to create absence proof: %v", err)
+	}
+	if account, _, err := proof.Verify(root); err != nil || account != nil {
+		t.Errorf("absence proof mismatch: have %v (%v), want nil", account, err)
+	}
+	// Proofs must not verify against another state
+	statedb.AddBalance(addr, big.NewInt(1))
+	other, _ := statedb.CommitTo(db, true)
+
+	proof, _ = statedb.GetProof(addr, nil)
+	if _, _, err := proof.Verify(root); err != ErrInvalidProof {
+		t.Errorf("proof verified against stale root: %v", err)
+	}
+	if _, _, err := proof.Verify(other); err != nil {
+		t.Errorf("failed to verify proof: %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"math/big"
+
+	"github.com/combchain/go-combchain/common"
+)
+
+// RWSet records the accounts read and written by a state transition. It is
+// used to detect conflicts between transactions executed speculatively on
+// independent copies of the same state.
+//
+// Accounts are tracked as a whole, accessing any storage slot counts as an
+// access to the owning account. Balance updates of existing accounts that
+// are not preceded by a read of the account commute with the updates of
+// other transactions. They are kept as deltas instead of writes, so that the
+// fee payments to the coinbase don't serialise every block.
+type RWSet struct {
+	reads  map[common.Address]struct{}
+	writes map[common.Address]struct{}
+	deltas map[common.Address]*big.Int // balance before the first blind update
+}
+
+// NewRWSet creates an empty read/write set.
+func NewRWSet() *RWSet {
+	return &RWSet{
+		reads:  make(map[common.Address]struct{}),
+		writes: make(map[common.Address]struct{}),
+		deltas: make(map[common.Address]*big.Int),
+	}
+}
+
+func (rw *RWSet) read(addr common.Address) {
+	rw.reads[addr] = struct{}{}
+}
+
+func (rw *RWSet) write(addr common.Address) {
+	rw.reads[addr] = struct{}{}
+	rw.writes[addr] = struct{}{}
+}
+
+// update records a balance change of addr not preceded by a read. Changes to
+// missing or empty accounts may create or delete the account, they are
+// recorded as writes.
+func (rw *RWSet) update(obj *stateObject, addr common.Address) {
+	if _, ok := rw.reads[addr]; ok {
+		rw.writes[addr] = struct{}{}
+		return
+	}
+	if _, ok := rw.deltas[addr]; ok {
+		return
+	}
+	if obj == nil || obj.empty() {
+		rw.write(addr)
+		return
+	}
+	rw.deltas[addr] = new(big.Int).Set(obj.Balance())
+}
+
+// finish settles the deltas once the transition is complete. Deltas of
+// accounts that were read afterwards or deleted are turned into writes.
+func (rw *RWSet) finish(db *StateDB) {
+	for addr := range rw.deltas {
+		if _, ok := rw.reads[addr]; ok {
+			rw.writes[addr] = struct{}{}
+			delete(rw.deltas, addr)
+			continue
+		}
+		if obj := db.stateObjects[addr]; obj == nil || obj.deleted {
+			rw.write(addr)
+			delete(rw.deltas, addr)
+		}
+	}
+}
+
+// Conflicts reports whether the recorded transition accessed any of the
+// written accounts, meaning it may have observed an outdated state.
+func (rw *RWSet) Conflicts(written map[common.Address]struct{}) bool {
+	for addr := range rw.reads {
+		if _, ok := written[addr]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// AddWrites adds every account modified by the recorded transition to written.
+func (rw *RWSet) AddWrites(written map[common.Address]struct{}) {
+	for addr := range rw.writes {
+		written[addr] = struct{}{}
+	}
+	for addr := range rw.deltas {
+		written[addr] = struct{}{}
+	}
+}
+
+// StartRecording makes the state record the accounts accessed through it
+// until StopRecording is called.
+func (self *StateDB) StartRecording() {
+	self.access = NewRWSet()
+}
+
+// StopRecording ends the recording started by StartRecording and returns the
+// accounts accessed in between.
+func (self *StateDB) StopRecording() *RWSet {
+	rw := self.access
+	self.access = nil
+	if rw != nil {
+		rw.finish(self)
+	}
+	return rw
+}
+
+func (self *StateDB) recordRead(addr common.Address) {
+	if self.access != nil {
+		self.access.read(addr)
+	}
+}
+
+func (self *StateDB) recordWrite(addr common.Address) {
+	if self.access != nil {
+		self.access.write(addr)
+	}
+}
+
+func (self *StateDB) recordUpdate(addr common.Address) {
+	if self.access != nil {
+		self.access.update(self.getStateObject(addr), addr)
+	}
+}
+
+// Merge applies the changes recorded in rw, made by a transition executed on
+// src, to the state. The state must not have been modified since src was
+// copied from it in any account rw accessed, see RWSet.Conflicts.
+//
+// The logs of the transition are added under the current transaction hash,
+// set by Prepare. Merge cannot be reverted to a snapshot.
+func (self *StateDB) Merge(src *StateDB, rw *RWSet) {
+	for addr := range rw.writes {
+		if _, dirty := src.stateObjectsDirty[addr]; !dirty {
+			continue
+		}
+		obj := src.stateObjects[addr]
+		if obj == nil {
+			continue
+		}
+		self.stateObjects[addr] = obj.deepCopy(self, self.MarkStateObjectDirty)
+		self.stateObjectsDirty[addr] = struct{}{}
+		self.mergeSnapshotChanges(src, obj.addrHash)
+	}
+	for addr, origin := range rw.deltas {
+		delta := new(big.Int).Sub(src.stateObjects[addr].Balance(), origin)
+		switch delta.Sign() {
+		case 1:
+			self.GetOrNewStateObject(addr).AddBalance(delta)
+		case -1:
+			self.GetOrNewStateObject(addr).SubBalance(delta.Neg(delta))
+		}
+	}
+	for _, log := range src.logs[src.thash] {
+		self.AddLog(log)
+	}
+	for hash, preimage := range src.preimages {
+		self.AddPreimage(hash, preimage)
+	}
+}
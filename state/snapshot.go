@@ -0,0 +1,705 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/trie"
+)
+
+var (
+	snapshotRootKey       = []byte("SnapshotRoot")
+	snapshotAccountPrefix = []byte("sa") // snapshotAccountPrefix + account hash -> account
+	snapshotStoragePrefix = []byte("ss") // snapshotStoragePrefix + account hash + storage hash -> storage value
+
+	// ErrSnapshotStale is returned by a snapshot that has been flattened into
+	// the disk layer or dropped. Its content must be read from the trie.
+	ErrSnapshotStale = errors.New("snapshot stale")
+
+	// ErrSnapshotMissing is returned if no snapshot exists for a state root.
+	ErrSnapshotMissing = errors.New("snapshot missing")
+
+	// ErrSnapshotGenerating is returned by a snapshot read reaching the disk
+	// layer while it is regenerated. Its content must be read from the trie.
+	ErrSnapshotGenerating = errors.New("snapshot generating")
+
+	errSnapshotAborted = errors.New("snapshot generation aborted")
+
+	errSnapshotUnsupported = errors.New("database does not support key iteration")
+)
+
+// Snapshot is a flat view of the state at a given root. Accounts and storage
+// slots are keyed by the hash of their address and key, like in the secure
+// tries, and hold the same values as the trie leaves.
+type Snapshot interface {
+	// Root returns the state root the snapshot represents.
+	Root() common.Hash
+
+	// Account returns the RLP encoded account, nil if it doesn't exist.
+	Account(hash common.Hash) ([]byte, error)
+
+	// Storage returns the value of a storage slot, nil if it is empty.
+	Storage(accountHash, storageHash common.Hash) ([]byte, error)
+
+	// ForEachStorage calls fn with the non-empty storage slots of an account
+	// in key order, until fn returns false.
+	ForEachStorage(accountHash common.Hash, fn func(storageHash common.Hash, value []byte) bool) error
+}
+
+// snapLayer is a snapshot of the state at one root. The bottom layer is the
+// disk layer, whose content lives in the database. Every other layer holds
+// the changes of a single block on top of its parent.
+type snapLayer struct {
+	tree   *Snapshots
+	root   common.Hash
+	parent *snapLayer // nil for the disk layer
+	stale  bool       // set when the layer is flattened or dropped
+
+	destructs map[common.Hash]struct{}               // Accounts deleted in the block, applied before the changes
+	accounts  map[common.Hash][]byte                 // Changed accounts, nil if deleted
+	storage   map[common.Hash]map[common.Hash][]byte // Changed storage slots, nil if cleared
+}
+
+func (l *snapLayer) Root() common.Hash {
+	return l.root
+}
+
+func (l *snapLayer) Account(hash common.Hash) ([]byte, error) {
+	l.tree.lock.RLock()
+	defer l.tree.lock.RUnlock()
+
+	if l.stale {
+		return nil, ErrSnapshotStale
+	}
+	layer := l
+	for ; layer.parent != nil; layer = layer.parent {
+		if enc, ok := layer.accounts[hash]; ok {
+			return enc, nil
+		}
+		if _, ok := layer.destructs[hash]; ok {
+			return nil, nil
+		}
+	}
+	if l.tree.generating {
+		return nil, ErrSnapshotGenerating
+	}
+	enc, _ := l.tree.diskdb.Get(snapshotAccountKey(hash))
+	return enc, nil
+}
+
+func (l *snapLayer) Storage(accountHash, storageHash common.Hash) ([]byte, error) {
+	l.tree.lock.RLock()
+	defer l.tree.lock.RUnlock()
+
+	if l.stale {
+		return nil, ErrSnapshotStale
+	}
+	layer := l
+	for ; layer.parent != nil; layer = layer.parent {
+		if value, ok := layer.storage[accountHash][storageHash]; ok {
+			return value, nil
+		}
+		if _, ok := layer.destructs[accountHash]; ok {
+			return nil, nil
+		}
+	}
+	if l.tree.generating {
+		return nil, ErrSnapshotGenerating
+	}
+	value, _ := l.tree.diskdb.Get(snapshotStorageKey(accountHash, storageHash))
+	return value, nil
+}
+
+func (l *snapLayer) ForEachStorage(accountHash common.Hash, fn func(storageHash common.Hash, value []byte) bool) error {
+	l.tree.lock.RLock()
+
+	if l.stale {
+		l.tree.lock.RUnlock()
+		return ErrSnapshotStale
+	}
+	// Collect the slots from the newest layer down, stopping at deletions
+	slots := make(map[common.Hash][]byte)
+	layer, wiped := l, false
+	for ; layer.parent != nil && !wiped; layer = layer.parent {
+		for hash, value := range layer.storage[accountHash] {
+			if _, ok := slots[hash]; !ok {
+				slots[hash] = value
+			}
+		}
+		_, wiped = layer.destructs[accountHash]
+	}
+	var err error
+	if !wiped && l.tree.generating {
+		err = ErrSnapshotGenerating
+	} else if !wiped {
+		prefix := snapshotStorageKey(accountHash, common.Hash{})[:len(snapshotStoragePrefix)+common.HashLength]
+		err = forEachKey(l.tree.diskdb, prefix, len(prefix)+common.HashLength, func(key, value []byte) {
+			hash := common.BytesToHash(key[len(prefix):])
+			if _, ok := slots[hash]; !ok {
+				slots[hash] = common.CopyBytes(value)
+			}
+		})
+	}
+	l.tree.lock.RUnlock()
+	if err != nil {
+		return err
+	}
+	// Iterate in key order without the lock, fn may read the snapshot
+	hashes := make([]common.Hash, 0, len(slots))
+	for hash, value := range slots {
+		if len(value) > 0 {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+	for _, hash := range hashes {
+		if !fn(hash, slots[hash]) {
+			break
+		}
+	}
+	return nil
+}
+
+// descends reports whether the layer is built on top of base.
+func (l *snapLayer) descends(base *snapLayer) bool {
+	for layer := l; layer != nil; layer = layer.parent {
+		if layer == base {
+			return true
+		}
+	}
+	return false
+}
+
+// Snapshots is the tree of state snapshots. It keeps a disk layer persisted
+// in the database and one in-memory diff layer for every block committed on
+// top of it, including the blocks of side chains, until they are flattened
+// into the disk layer by Cap. The disk layer may be regenerated from the state
+// trie in the background, see Rebuild.
+type Snapshots struct {
+	diskdb ethdb.Database
+
+	lock   sync.RWMutex
+	layers map[common.Hash]*snapLayer // State root -> snapshot layer
+
+	generating bool          // Whether the disk layer is being regenerated
+	genAbort   chan struct{} // Closed to abort the running generation
+	genDone    chan struct{} // Closed when the running generation ends
+	genErr     error         // Error of the last generation
+}
+
+// NewSnapshots loads the snapshot persisted in the database. If it is missing
+// or doesn't represent the state at root, it is rebuilt from the state trie
+// before returning.
+func NewSnapshots(diskdb ethdb.Database, root common.Hash) (*Snapshots, error) {
+	s := &Snapshots{
+		diskdb: diskdb,
+		layers: make(map[common.Hash]*snapLayer),
+	}
+	if enc, _ := diskdb.Get(snapshotRootKey); common.BytesToHash(enc) == root && len(enc) == common.HashLength {
+		s.layers[root] = &snapLayer{tree: s, root: root}
+		return s, nil
+	}
+	s.Rebuild(root)
+	if err := s.wait(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Snapshot returns the snapshot of the state at root, nil if there is none.
+func (s *Snapshots) Snapshot(root common.Hash) Snapshot {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if layer := s.layers[root]; layer != nil {
+		return layer
+	}
+	return nil
+}
+
+// Update adds a layer for the state at root, changed from the state at
+// parentRoot by deleting the destructed accounts and setting the accounts and
+// storage slots.
+func (s *Snapshots) Update(root, parentRoot common.Hash, destructs map[common.Hash]struct{}, accounts map[common.Hash][]byte, storage map[common.Hash]map[common.Hash][]byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.layers[root]; ok {
+		return nil
+	}
+	parent := s.layers[parentRoot]
+	if parent == nil {
+		return ErrSnapshotMissing
+	}
+	s.layers[root] = &snapLayer{
+		tree:      s,
+		root:      root,
+		parent:    parent,
+		destructs: destructs,
+		accounts:  accounts,
+		storage:   storage,
+	}
+	return nil
+}
+
+// Cap flattens the layers below the layer at root into the disk layer, so that
+// at most keep diff layers remain beneath it. Layers of forks that do not
+// build on the new disk layer are dropped. While the disk layer is generated
+// nothing is flattened.
+func (s *Snapshots) Cap(root common.Hash, keep int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	bottom := s.layers[root]
+	if bottom == nil {
+		return ErrSnapshotMissing
+	}
+	if s.generating {
+		return nil
+	}
+	for i := 0; i < keep && bottom.parent != nil; i++ {
+		bottom = bottom.parent
+	}
+	if bottom.parent == nil {
+		return nil
+	}
+	var diffs []*snapLayer
+	for layer := bottom; layer.parent != nil; layer = layer.parent {
+		diffs = append(diffs, layer)
+	}
+	// Drop the root marker while writing, a crash forces a rebuild
+	if err := s.diskdb.Delete(snapshotRootKey); err != nil {
+		return err
+	}
+	for i := len(diffs) - 1; i >= 0; i-- {
+		if err := s.persist(diffs[i]); err != nil {
+			return err
+		}
+	}
+	if err := s.diskdb.Put(snapshotRootKey, bottom.root[:]); err != nil {
+		return err
+	}
+	diffs[len(diffs)-1].parent.stale = true
+	for _, layer := range diffs[1:] {
+		layer.stale = true
+	}
+	bottom.parent, bottom.destructs, bottom.accounts, bottom.storage = nil, nil, nil, nil
+
+	for root, layer := range s.layers {
+		if !layer.descends(bottom) {
+			layer.stale = true
+			delete(s.layers, root)
+		}
+	}
+	return nil
+}
+
+// persist writes the changes of a diff layer into the disk layer.
+func (s *Snapshots) persist(layer *snapLayer) error {
+	for hash := range layer.destructs {
+		if err := s.diskdb.Delete(snapshotAccountKey(hash)); err != nil {
+			return err
+		}
+		prefix := snapshotStorageKey(hash, common.Hash{})[:len(snapshotStoragePrefix)+common.HashLength]
+		if err := deleteKeys(s.diskdb, prefix, len(prefix)+common.HashLength); err != nil {
+			return err
+		}
+	}
+	for hash, enc := range layer.accounts {
+		if err := putOrDelete(s.diskdb, snapshotAccountKey(hash), enc); err != nil {
+			return err
+		}
+	}
+	for accountHash, slots := range layer.storage {
+		for hash, value := range slots {
+			if err := putOrDelete(s.diskdb, snapshotStorageKey(accountHash, hash), value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Rebuild drops all layers and regenerates the disk layer from the state trie
+// at root in the background, aborting a running generation. Until it is done,
+// reads reaching the disk layer fail with ErrSnapshotGenerating, and layers
+// added on top of it are kept but not flattened.
+func (s *Snapshots) Rebuild(root common.Hash) {
+	s.abortGeneration()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, layer := range s.layers {
+		layer.stale = true
+	}
+	s.layers = map[common.Hash]*snapLayer{root: {tree: s, root: root}}
+	s.generating, s.genErr = true, nil
+	s.genAbort, s.genDone = make(chan struct{}), make(chan struct{})
+
+	go s.generate(root, s.genAbort, s.genDone)
+}
+
+// Close aborts a running generation. The snapshot is regenerated when loaded
+// again.
+func (s *Snapshots) Close() {
+	s.abortGeneration()
+}
+
+// abortGeneration stops the running generation and waits for it to end.
+func (s *Snapshots) abortGeneration() {
+	s.lock.Lock()
+	abort, done := s.genAbort, s.genDone
+	s.genAbort, s.genDone = nil, nil
+	s.lock.Unlock()
+
+	if abort != nil {
+		close(abort)
+		<-done
+	}
+}
+
+// wait blocks until the running generation ends, returning its error.
+func (s *Snapshots) wait() error {
+	s.lock.RLock()
+	done := s.genDone
+	s.lock.RUnlock()
+
+	if done != nil {
+		<-done
+	}
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.genErr
+}
+
+// generate writes the disk layer of the state at root, then makes it readable.
+// If the generation fails all layers are dropped, so the next Cap reports the
+// snapshot missing.
+func (s *Snapshots) generate(root common.Hash, abort, done chan struct{}) {
+	defer close(done)
+
+	log.Info("Generating state snapshot", "root", root)
+	accounts, err := s.generateDisk(root, abort)
+	if err == errSnapshotAborted {
+		log.Info("Aborted state snapshot generation", "root", root)
+		return
+	}
+	if err == nil {
+		err = s.diskdb.Put(snapshotRootKey, root[:])
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.generating, s.genErr = false, err
+	if s.genDone == done {
+		s.genAbort, s.genDone = nil, nil
+	}
+	if err != nil {
+		log.Error("Failed to generate state snapshot", "root", root, "err", err)
+		for _, layer := range s.layers {
+			layer.stale = true
+		}
+		s.layers = make(map[common.Hash]*snapLayer)
+		return
+	}
+	log.Info("Generated state snapshot", "root", root, "accounts", accounts)
+}
+
+// generateDisk replaces the snapshot entries in the database with the accounts
+// and storage of the state trie at root, returning the number of accounts.
+func (s *Snapshots) generateDisk(root common.Hash, abort chan struct{}) (int, error) {
+	if err := s.diskdb.Delete(snapshotRootKey); err != nil {
+		return 0, err
+	}
+	if err := deleteKeys(s.diskdb, snapshotAccountPrefix, len(snapshotAccountPrefix)+common.HashLength); err != nil {
+		return 0, err
+	}
+	if err := deleteKeys(s.diskdb, snapshotStoragePrefix, len(snapshotStoragePrefix)+2*common.HashLength); err != nil {
+		return 0, err
+	}
+	tr, err := trie.NewSecure(root, s.diskdb, 0)
+	if err != nil {
+		return 0, err
+	}
+	var (
+		batch    = s.diskdb.NewBatch()
+		accounts int
+	)
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		select {
+		case <-abort:
+			return accounts, errSnapshotAborted
+		default:
+		}
+		accountHash := common.BytesToHash(it.Key)
+		if err := batch.Put(snapshotAccountKey(accountHash), it.Value); err != nil {
+			return accounts, err
+		}
+		var account Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			return accounts, err
+		}
+		if account.Root != emptyTrieRoot && account.Root != (common.Hash{}) {
+			st, err := trie.NewSecure(account.Root, s.diskdb, 0)
+			if err != nil {
+				return accounts, err
+			}
+			sit := trie.NewIterator(st.NodeIterator(nil))
+			for sit.Next() {
+				if err := batch.Put(snapshotStorageKey(accountHash, common.BytesToHash(sit.Key)), sit.Value); err != nil {
+					return accounts, err
+				}
+				if batch.ValueSize() >= ethdb.IdealBatchSize {
+					if err := batch.Write(); err != nil {
+						return accounts, err
+					}
+					batch = s.diskdb.NewBatch()
+				}
+			}
+			if sit.Err != nil {
+				return accounts, sit.Err
+			}
+		}
+		if batch.ValueSize() >= ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return accounts, err
+			}
+			batch = s.diskdb.NewBatch()
+		}
+		accounts++
+	}
+	if it.Err != nil {
+		return accounts, it.Err
+	}
+	return accounts, batch.Write()
+}
+
+func snapshotAccountKey(hash common.Hash) []byte {
+	return append(append([]byte{}, snapshotAccountPrefix...), hash[:]...)
+}
+
+func snapshotStorageKey(accountHash, storageHash common.Hash) []byte {
+	return append(append(append([]byte{}, snapshotStoragePrefix...), accountHash[:]...), storageHash[:]...)
+}
+
+func putOrDelete(db ethdb.Database, key, value []byte) error {
+	if len(value) == 0 {
+		return db.Delete(key)
+	}
+	return db.Put(key, value)
+}
+
+// forEachPrefix calls fn with every entry of the database whose key starts
+// with prefix. The key and value must not be retained after fn returns.
+func forEachPrefix(db ethdb.Database, prefix []byte, fn func(key, value []byte)) error {
+	// Iterate the key-value store of wrapping databases, like a freezer
+	if kv, ok := db.(interface {
+		KeyValueStore() ethdb.Database
+	}); ok {
+		db = kv.KeyValueStore()
+	}
+	switch db := db.(type) {
+	case *ethdb.LDBDatabase:
+		it := db.NewIterator()
+		defer it.Release()
+
+		for ok := it.Seek(prefix); ok && bytes.HasPrefix(it.Key(), prefix); ok = it.Next() {
+			fn(it.Key(), it.Value())
+		}
+		return it.Error()
+
+	case *ethdb.MemDatabase:
+		for _, key := range db.Keys() {
+			if bytes.HasPrefix(key, prefix) {
+				value, _ := db.Get(key)
+				fn(key, value)
+			}
+		}
+		return nil
+	}
+	return errSnapshotUnsupported
+}
+
+// forEachKey calls fn with every entry of the database whose key starts
+// with prefix and is keyLen bytes long. Checking the length keeps trie nodes
+// and code, stored under bare 32 byte hashes, from matching a short prefix.
+func forEachKey(db ethdb.Database, prefix []byte, keyLen int, fn func(key, value []byte)) error {
+	return forEachPrefix(db, prefix, func(key, value []byte) {
+		if len(key) == keyLen {
+			fn(key, value)
+		}
+	})
+}
+
+// deleteKeys removes every entry of the database whose key starts with
+// prefix and is keyLen bytes long.
+func deleteKeys(db ethdb.Database, prefix []byte, keyLen int) error {
+	var keys [][]byte
+	err := forEachKey(db, prefix, keyLen, func(key, value []byte) {
+		keys = append(keys, common.CopyBytes(key))
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// openSnapshot attaches the snapshot of the state at root, if there is one,
+// and clears the recorded changes.
+func (self *StateDB) openSnapshot(root common.Hash) {
+	self.snap = nil
+	if self.snaps != nil {
+		self.snap = self.snaps.Snapshot(root)
+	}
+	self.snapDestructs = make(map[common.Hash]struct{})
+	self.snapAccounts = make(map[common.Hash][]byte)
+	self.snapStorage = make(map[common.Hash]map[common.Hash][]byte)
+}
+
+// readSnapshot returns the snapshot to read the state from, nil if it must be
+// read from the tries, e.g. while recording a witness.
+func (self *StateDB) readSnapshot() Snapshot {
+	if self.witness != nil {
+		return nil
+	}
+	return self.snap
+}
+
+// commitSnapshot adds the recorded changes as the snapshot layer of the
+// state at root and attaches it.
+func (self *StateDB) commitSnapshot(root common.Hash) {
+	if self.snap == nil {
+		return
+	}
+	if err := self.snaps.Update(root, self.snap.Root(), self.snapDestructs, self.snapAccounts, self.snapStorage); err != nil {
+		log.Warn("Failed to update state snapshot", "root", root, "parent", self.snap.Root(), "err", err)
+	}
+	self.openSnapshot(root)
+}
+
+// copySnapshotChanges copies the recorded changes into state.
+func (self *StateDB) copySnapshotChanges(state *StateDB) {
+	state.snapDestructs = make(map[common.Hash]struct{}, len(self.snapDestructs))
+	for hash := range self.snapDestructs {
+		state.snapDestructs[hash] = struct{}{}
+	}
+	state.snapAccounts = make(map[common.Hash][]byte, len(self.snapAccounts))
+	for hash, enc := range self.snapAccounts {
+		state.snapAccounts[hash] = enc
+	}
+	state.snapStorage = make(map[common.Hash]map[common.Hash][]byte, len(self.snapStorage))
+	for hash, slots := range self.snapStorage {
+		state.snapStorage[hash] = make(map[common.Hash][]byte, len(slots))
+		for key, value := range slots {
+			state.snapStorage[hash][key] = value
+		}
+	}
+}
+
+// mergeSnapshotChanges replaces the recorded changes of the account with the
+// ones recorded by src.
+func (self *StateDB) mergeSnapshotChanges(src *StateDB, addrHash common.Hash) {
+	if self.snap == nil {
+		return
+	}
+	delete(self.snapDestructs, addrHash)
+	if _, ok := src.snapDestructs[addrHash]; ok {
+		self.snapDestructs[addrHash] = struct{}{}
+	}
+	delete(self.snapAccounts, addrHash)
+	if enc, ok := src.snapAccounts[addrHash]; ok {
+		self.snapAccounts[addrHash] = enc
+	}
+	delete(self.snapStorage, addrHash)
+	if slots, ok := src.snapStorage[addrHash]; ok {
+		self.snapStorage[addrHash] = make(map[common.Hash][]byte, len(slots))
+		for key, value := range slots {
+			self.snapStorage[addrHash][key] = value
+		}
+	}
+}
+
+// snapWipe records the deletion of the account replaced by a newly created
+// state object, before any of its own storage is recorded.
+func (self *StateDB) snapWipe(obj *stateObject) {
+	if obj.created && !obj.wiped {
+		self.snapDestructs[obj.addrHash] = struct{}{}
+		delete(self.snapStorage, obj.addrHash)
+		obj.wiped = true
+	}
+}
+
+func (self *StateDB) snapUpdateAccount(obj *stateObject, enc []byte) {
+	if self.snap == nil {
+		return
+	}
+	self.snapWipe(obj)
+	self.snapAccounts[obj.addrHash] = enc
+}
+
+func (self *StateDB) snapDeleteAccount(obj *stateObject) {
+	if self.snap == nil {
+		return
+	}
+	self.snapDestructs[obj.addrHash] = struct{}{}
+	self.snapAccounts[obj.addrHash] = nil
+	delete(self.snapStorage, obj.addrHash)
+}
+
+// snapUpdateStorage records the dirty storage of the object, in the encoding
+// updateTrie writes it to the storage trie. It must be called before the
+// storage is flushed.
+func (self *StateDB) snapUpdateStorage(obj *stateObject) {
+	if self.snap == nil {
+		return
+	}
+	self.snapWipe(obj)
+	if len(obj.dirtyStorage) == 0 && len(obj.dirtyStorageByteArray) == 0 {
+		return
+	}
+	slots := self.snapStorage[obj.addrHash]
+	if slots == nil {
+		slots = make(map[common.Hash][]byte)
+		self.snapStorage[obj.addrHash] = slots
+	}
+	for key, value := range obj.dirtyStorage {
+		var enc []byte
+		if (value != common.Hash{}) {
+			enc, _ = rlp.EncodeToBytes(bytes.TrimLeft(value[:], "\x00"))
+		}
+		slots[crypto.Keccak256Hash(key[:])] = enc
+	}
+	for key, value := range obj.dirtyStorageByteArray {
+		slots[crypto.Keccak256Hash(key[:])] = common.CopyBytes(value)
+	}
+}
+
+// forEachSnapshotStorage calls fn with the storage slots of the object in
+// the snapshot, using the trie preimages to recover the keys. It returns
+// false if the storage can't be read from the snapshot.
+func (self *StateDB) forEachSnapshotStorage(obj *stateObject, fn func(key common.Hash, value []byte) bool) bool {
+	snap := self.readSnapshot()
+	if snap == nil || obj.created {
+		return false
+	}
+	err := snap.ForEachStorage(obj.addrHash, func(hash common.Hash, value []byte) bool {
+		return fn(common.BytesToHash(self.trie.GetKey(hash[:])), value)
+	})
+	return err == nil
+}
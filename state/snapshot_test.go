@@ -0,0 +1,206 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/trie"
+)
+
+// Tests that the snapshot layers match the state tries across blocks, forks,
+// account recreation, flattening and rebuilds.
+func TestSnapshots(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	snaps, err := NewSnapshots(db, common.Hash{})
+	if err != nil {
+		t.Fatalf("failed to create snapshots: %v", err)
+	}
+	sdb := NewDatabaseWithSnapshots(db, snaps)
+
+	var (
+		addrs = []common.Address{{0x01}, {0x02}, {0x03}, {0x04}}
+		ota   = common.HexToHash("0x0a")
+	)
+	commit := func(parent common.Hash, modify func(*StateDB)) common.Hash {
+		statedb, err := New(parent, sdb)
+		if err != nil {
+			t.Fatalf("failed to open state %x: %v", parent, err)
+		}
+		if parent != (common.Hash{}) && statedb.snap == nil {
+			t.Fatalf("state %x opened without snapshot", parent)
+		}
+		modify(statedb)
+		root, err := statedb.CommitTo(db, true)
+		if err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+		return root
+	}
+	root1 := commit(common.Hash{}, func(statedb *StateDB) {
+		for i, addr := range addrs {
+			statedb.AddBalance(addr, big.NewInt(int64(100*(i+1))))
+			statedb.SetState(addr, common.Hash{byte(i)}, common.Hash{0x11})
+			statedb.SetState(addr, common.Hash{0xff}, common.Hash{byte(i + 1)})
+		}
+		statedb.SetStateByteArray(addrs[0], ota, []byte("one-time address"))
+	})
+	root2 := commit(root1, func(statedb *StateDB) {
+		// Clear and change slots, then recreate an account within the block
+		statedb.SetState(addrs[0], common.Hash{0x00}, common.Hash{})
+		statedb.SetState(addrs[1], common.Hash{0x01}, common.Hash{0x22})
+		statedb.Suicide(addrs[2])
+		statedb.Finalise(true)
+
+		statedb.CreateAccount(addrs[2])
+		statedb.SetState(addrs[2], common.Hash{0xee}, common.Hash{0x33})
+		statedb.AddBalance(addrs[2], big.NewInt(1))
+		statedb.Finalise(true)
+
+		statedb.Suicide(addrs[3])
+	})
+	root2b := commit(root1, func(statedb *StateDB) {
+		statedb.SetStateByteArray(addrs[0], ota, nil)
+		statedb.AddBalance(common.Address{0x05}, big.NewInt(500))
+	})
+	root3 := commit(root2, func(statedb *StateDB) {
+		statedb.SetState(addrs[2], common.Hash{0xef}, common.Hash{0x44})
+	})
+
+	for _, root := range []common.Hash{root1, root2, root2b, root3} {
+		checkSnapshot(t, db, snaps.Snapshot(root))
+	}
+	// Reads through the snapshot must match the ones through the trie
+	for _, root := range []common.Hash{root2, root2b} {
+		snapState, _ := New(root, sdb)
+		trieState, _ := New(root, NewDatabase(db))
+		for _, addr := range append(addrs, common.Address{0x05}) {
+			if have, want := snapState.GetBalance(addr), trieState.GetBalance(addr); have.Cmp(want) != 0 {
+				t.Errorf("root %x, account %x: balance mismatch: have %v, want %v", root, addr, have, want)
+			}
+			if have, want := snapState.GetState(addr, common.Hash{0xff}), trieState.GetState(addr, common.Hash{0xff}); have != want {
+				t.Errorf("root %x, account %x: storage mismatch: have %x, want %x", root, addr, have, want)
+			}
+		}
+		if have, want := collectByteArrays(snapState, addrs[0]), collectByteArrays(trieState, addrs[0]); !reflect.DeepEqual(have, want) {
+			t.Errorf("root %x: byte array storage mismatch: have %x, want %x", root, have, want)
+		}
+	}
+	// Flattening drops the other forks and stales the flattened layers
+	if err := snaps.Cap(root3, 1); err != nil {
+		t.Fatalf("failed to cap snapshots: %v", err)
+	}
+	if snaps.Snapshot(root2b) != nil {
+		t.Errorf("side chain snapshot retained")
+	}
+	if snaps.Snapshot(root1) != nil {
+		t.Errorf("flattened snapshot retained")
+	}
+	checkSnapshot(t, db, snaps.Snapshot(root2))
+	checkSnapshot(t, db, snaps.Snapshot(root3))
+
+	if err := snaps.Cap(root3, 0); err != nil {
+		t.Fatalf("failed to cap snapshots: %v", err)
+	}
+	checkSnapshot(t, db, snaps.Snapshot(root3))
+
+	// The persisted snapshot must be loaded, a different root rebuilt
+	loaded, err := NewSnapshots(db, root3)
+	if err != nil {
+		t.Fatalf("failed to load snapshots: %v", err)
+	}
+	checkSnapshot(t, db, loaded.Snapshot(root3))
+
+	rebuilt, err := NewSnapshots(db, root2b)
+	if err != nil {
+		t.Fatalf("failed to rebuild snapshots: %v", err)
+	}
+	checkSnapshot(t, db, rebuilt.Snapshot(root2b))
+
+	// Regenerating in the background leaves alone the hash keyed entries that
+	// share the snapshot prefixes
+	collisions := [][]byte{
+		append(common.CopyBytes(snapshotAccountPrefix), make([]byte, common.HashLength-len(snapshotAccountPrefix))...),
+		append(common.CopyBytes(snapshotStoragePrefix), make([]byte, common.HashLength-len(snapshotStoragePrefix))...),
+	}
+	for _, key := range collisions {
+		db.Put(key, []byte{0x01})
+	}
+	rebuilt.Rebuild(root3)
+	if err := rebuilt.wait(); err != nil {
+		t.Fatalf("failed to regenerate snapshot: %v", err)
+	}
+	checkSnapshot(t, db, rebuilt.Snapshot(root3))
+	for _, key := range collisions {
+		if has, _ := db.Has(key); !has {
+			t.Errorf("entry %x deleted by rebuild", key)
+		}
+	}
+}
+
+// checkSnapshot verifies that the snapshot holds exactly the accounts and
+// storage of its state trie.
+func checkSnapshot(t *testing.T, db ethdb.Database, snap Snapshot) {
+	if snap == nil {
+		t.Fatalf("snapshot missing")
+	}
+	tr, err := trie.NewSecure(snap.Root(), db, 0)
+	if err != nil {
+		t.Fatalf("failed to open state trie %x: %v", snap.Root(), err)
+	}
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		hash := common.BytesToHash(it.Key)
+		if enc, err := snap.Account(hash); err != nil || !bytes.Equal(enc, it.Value) {
+			t.Errorf("root %x, account %x: mismatch: have %x (%v), want %x", snap.Root(), hash, enc, err, it.Value)
+		}
+		var account Account
+		if err := rlp.DecodeBytes(it.Value, &account); err != nil {
+			t.Fatalf("failed to decode account: %v", err)
+		}
+		want := make(map[common.Hash][]byte)
+		st, _ := trie.NewSecure(account.Root, db, 0)
+		sit := trie.NewIterator(st.NodeIterator(nil))
+		for sit.Next() {
+			want[common.BytesToHash(sit.Key)] = sit.Value
+		}
+		have := make(map[common.Hash][]byte)
+		snap.ForEachStorage(hash, func(key common.Hash, value []byte) bool {
+			have[key] = value
+			return true
+		})
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("root %x, account %x: storage mismatch: have %x, want %x", snap.Root(), hash, have, want)
+		}
+		for key, value := range want {
+			if enc, err := snap.Storage(hash, key); err != nil || !bytes.Equal(enc, value) {
+				t.Errorf("root %x, account %x, slot %x: mismatch: have %x (%v), want %x", snap.Root(), hash, key, enc, err, value)
+			}
+		}
+	}
+	// Accounts deleted from the trie must be gone from the snapshot too
+	for _, addr := range []common.Address{{0x04}, {0x06}} {
+		hash := crypto.Keccak256Hash(addr[:])
+		if enc, _ := tr.TryGet(addr[:]); len(enc) == 0 {
+			if enc, err := snap.Account(hash); err != nil || enc != nil {
+				t.Errorf("root %x, account %x: deleted account present: %x (%v)", snap.Root(), addr, enc, err)
+			}
+		}
+	}
+}
+
+func collectByteArrays(statedb *StateDB, addr common.Address) map[common.Hash][]byte {
+	values := make(map[common.Hash][]byte)
+	statedb.ForEachStorageByteArray(addr, func(key common.Hash, value []byte) bool {
+		values[key] = value
+		return true
+	})
+	return values
+}
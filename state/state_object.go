@@ -107,6 +107,8 @@ type stateObject struct {
 	suicided  bool
 	touched   bool
 	deleted   bool
+	created   bool                      // created in this state, its storage is not in the snapshot
+	wiped     bool                      // deletion of the replaced account recorded for the snapshot
 	onDirty   func(addr common.Address) // Callback method to mark a state object newly dirty
 }
 
@@ -170,7 +172,7 @@ func (self *stateObject) markSuicided() {
 }
 
 func (c *stateObject) touch() {
-	c.db.journal = append(c.db.journal, touchChange{
+	c.db.appendJournal(touchChange{
 		account:   &c.address,
 		prev:      c.touched,
 		prevDirty: c.onDirty == nil,
@@ -201,7 +203,7 @@ func (self *stateObject) GetState(db Database, key common.Hash) common.Hash {
 		return value
 	}
 	// Load from DB in case it is missing.
-	enc, err := self.getTrie(db).TryGet(key[:])
+	enc, err := self.readStorage(db, key)
 	if err != nil {
 		self.setError(err)
 		return common.Hash{}
@@ -225,16 +227,27 @@ func (self *stateObject) GetStateByteArray(db Database, key common.Hash) []byte
 		return value
 	}
 	// Load from DB in case it is missing.
-	value, err := self.getTrie(db).TryGet(key[:])
+	value, err := self.readStorage(db, key)
 	if err == nil && len(value) != 0 {
 		self.cachedStorageByteArray[key] = value
 	}
 	return value
 }
 
+// readStorage loads the raw value of a storage slot, from the snapshot if
+// there is one, otherwise from the storage trie.
+func (self *stateObject) readStorage(db Database, key common.Hash) ([]byte, error) {
+	if snap := self.db.readSnapshot(); snap != nil && !self.created {
+		if value, err := snap.Storage(self.addrHash, crypto.Keccak256Hash(key[:])); err == nil {
+			return value, nil
+		}
+	}
+	return self.getTrie(db).TryGet(key[:])
+}
+
 // SetState updates a value in account storage.
 func (self *stateObject) SetState(db Database, key, value common.Hash) {
-	self.db.journal = append(self.db.journal, storageChange{
+	self.db.appendJournal(storageChange{
 		account:  &self.address,
 		key:      key,
 		prevalue: self.GetState(db, key),
@@ -253,7 +266,7 @@ func (self *stateObject) setState(key, value common.Hash) {
 }
 
 func (self *stateObject) SetStateByteArray(db Database, key common.Hash, value []byte) {
-	self.db.journal = append(self.db.journal, storageByteArrayChange{
+	self.db.appendJournal(storageByteArrayChange{
 		account:  &self.address,
 		key:      key,
 		prevalue: self.GetStateByteArray(db, key),
@@ -354,7 +367,7 @@ func (c *stateObject) SubBalance(amount *big.Int) {
 }
 
 func (self *stateObject) SetBalance(amount *big.Int) {
-	self.db.journal = append(self.db.journal, balanceChange{
+	self.db.appendJournal(balanceChange{
 		account: &self.address,
 		prev:    new(big.Int).Set(self.data.Balance),
 	})
@@ -379,12 +392,14 @@ func (self *stateObject) deepCopy(db *StateDB, onDirty func(addr common.Address)
 	}
 	stateObject.code = self.code
 	stateObject.dirtyStorage = self.dirtyStorage.Copy()
-	stateObject.cachedStorage = self.dirtyStorage.Copy()
+	stateObject.cachedStorage = self.cachedStorage.Copy()
 	stateObject.dirtyStorageByteArray = self.dirtyStorageByteArray.Copy()
 	stateObject.cachedStorageByteArray = self.cachedStorageByteArray.Copy()
 	stateObject.suicided = self.suicided
 	stateObject.dirtyCode = self.dirtyCode
 	stateObject.deleted = self.deleted
+	stateObject.created = self.created
+	stateObject.wiped = self.wiped
 	return stateObject
 }
 
@@ -415,7 +430,7 @@ func (self *stateObject) Code(db Database) []byte {
 
 func (self *stateObject) SetCode(codeHash common.Hash, code []byte) {
 	prevcode := self.Code(self.db.db)
-	self.db.journal = append(self.db.journal, codeChange{
+	self.db.appendJournal(codeChange{
 		account:  &self.address,
 		prevhash: self.CodeHash(),
 		prevcode: prevcode,
@@ -434,7 +449,7 @@ func (self *stateObject) setCode(codeHash common.Hash, code []byte) {
 }
 
 func (self *stateObject) SetNonce(nonce uint64) {
-	self.db.journal = append(self.db.journal, nonceChange{
+	self.db.appendJournal(nonceChange{
 		account: &self.address,
 		prev:    self.data.Nonce,
 	})
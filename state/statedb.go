@@ -77,6 +77,23 @@ type StateDB struct {
 	validRevisions []revision
 	nextRevisionId int
 
+	// Accounts accessed since StartRecording, nil if not recording.
+	access *RWSet
+
+	// Values changed since StartDiff, nil if not recording.
+	diff *diffRecorder
+
+	// Trie nodes and codes read since StartWitness, nil if not recording.
+	witness *witnessRecorder
+
+	// Snapshot of the state the trie was opened at, nil if there is none,
+	// and the changes to add as its next layer on commit.
+	snaps         *Snapshots
+	snap          Snapshot
+	snapDestructs map[common.Hash]struct{}
+	snapAccounts  map[common.Hash][]byte
+	snapStorage   map[common.Hash]map[common.Hash][]byte
+
 	lock sync.Mutex
 }
 
@@ -86,7 +103,7 @@ func New(root common.Hash, db Database) (*StateDB, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &StateDB{
+	sdb := &StateDB{
 		db:                db,
 		trie:              tr,
 		stateObjects:      make(map[common.Address]*stateObject),
@@ -94,7 +111,14 @@ func New(root common.Hash, db Database) (*StateDB, error) {
 		refund:            new(big.Int),
 		logs:              make(map[common.Hash][]*types.Log),
 		preimages:         make(map[common.Hash][]byte),
-	}, nil
+	}
+	if db, ok := db.(interface {
+		Snapshots() *Snapshots
+	}); ok {
+		sdb.snaps = db.Snapshots()
+	}
+	sdb.openSnapshot(root)
+	return sdb, nil
 }
 
 // setError remembers the first non-nil error it is called with.
@@ -124,12 +148,13 @@ func (self *StateDB) Reset(root common.Hash) error {
 	self.logs = make(map[common.Hash][]*types.Log)
 	self.logSize = 0
 	self.preimages = make(map[common.Hash][]byte)
+	self.openSnapshot(root)
 	self.clearJournalAndRefund()
 	return nil
 }
 
 func (self *StateDB) AddLog(log *types.Log) {
-	self.journal = append(self.journal, addLogChange{txhash: self.thash})
+	self.appendJournal(addLogChange{txhash: self.thash})
 
 	log.TxHash = self.thash
 	log.BlockHash = self.bhash
@@ -154,7 +179,7 @@ func (self *StateDB) Logs() []*types.Log {
 // AddPreimage records a SHA3 preimage seen by the VM.
 func (self *StateDB) AddPreimage(hash common.Hash, preimage []byte) {
 	if _, ok := self.preimages[hash]; !ok {
-		self.journal = append(self.journal, addPreimageChange{hash: hash})
+		self.appendJournal(addPreimageChange{hash: hash})
 		pi := make([]byte, len(preimage))
 		copy(pi, preimage)
 		self.preimages[hash] = pi
@@ -167,25 +192,28 @@ func (self *StateDB) Preimages() map[common.Hash][]byte {
 }
 
 func (self *StateDB) AddRefund(gas *big.Int) {
-	self.journal = append(self.journal, refundChange{prev: new(big.Int).Set(self.refund)})
+	self.appendJournal(refundChange{prev: new(big.Int).Set(self.refund)})
 	self.refund.Add(self.refund, gas)
 }
 
 // Exist reports whether the given account address exists in the state.
 // Notably this also returns true for suicided accounts.
 func (self *StateDB) Exist(addr common.Address) bool {
+	self.recordRead(addr)
 	return self.getStateObject(addr) != nil
 }
 
 // Empty returns whether the state object is either non-existent
 // or empty according to the EIP161 specification (balance = nonce = code = 0)
 func (self *StateDB) Empty(addr common.Address) bool {
+	self.recordRead(addr)
 	so := self.getStateObject(addr)
 	return so == nil || so.empty()
 }
 
 // Retrieve the balance from the given address or 0 if object not found
 func (self *StateDB) GetBalance(addr common.Address) *big.Int {
+	self.recordRead(addr)
 	stateObject := self.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Balance()
@@ -194,6 +222,7 @@ func (self *StateDB) GetBalance(addr common.Address) *big.Int {
 }
 
 func (self *StateDB) GetNonce(addr common.Address) uint64 {
+	self.recordRead(addr)
 	stateObject := self.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Nonce()
@@ -203,6 +232,7 @@ func (self *StateDB) GetNonce(addr common.Address) uint64 {
 }
 
 func (self *StateDB) GetCode(addr common.Address) []byte {
+	self.recordRead(addr)
 	stateObject := self.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.Code(self.db)
@@ -211,6 +241,7 @@ func (self *StateDB) GetCode(addr common.Address) []byte {
 }
 
 func (self *StateDB) GetCodeSize(addr common.Address) int {
+	self.recordRead(addr)
 	stateObject := self.getStateObject(addr)
 	if stateObject == nil {
 		return 0
@@ -226,6 +257,7 @@ func (self *StateDB) GetCodeSize(addr common.Address) int {
 }
 
 func (self *StateDB) GetCodeHash(addr common.Address) common.Hash {
+	self.recordRead(addr)
 	stateObject := self.getStateObject(addr)
 	if stateObject == nil {
 		return common.Hash{}
@@ -234,6 +266,7 @@ func (self *StateDB) GetCodeHash(addr common.Address) common.Hash {
 }
 
 func (self *StateDB) GetState(a common.Address, b common.Hash) common.Hash {
+	self.recordRead(a)
 	stateObject := self.getStateObject(a)
 	if stateObject != nil {
 		return stateObject.GetState(self.db, b)
@@ -242,6 +275,7 @@ func (self *StateDB) GetState(a common.Address, b common.Hash) common.Hash {
 }
 
 func (self *StateDB) GetStateByteArray(a common.Address, b common.Hash) []byte {
+	self.recordRead(a)
 	stateObject := self.getStateObject(a)
 	if stateObject != nil {
 		return stateObject.GetStateByteArray(self.db, b)
@@ -252,6 +286,7 @@ func (self *StateDB) GetStateByteArray(a common.Address, b common.Hash) []byte {
 // StorageTrie returns the storage trie of an account.
 // The return value is a copy and is nil for non-existent accounts.
 func (self *StateDB) StorageTrie(a common.Address) Trie {
+	self.recordRead(a)
 	stateObject := self.getStateObject(a)
 	if stateObject == nil {
 		return nil
@@ -261,6 +296,7 @@ func (self *StateDB) StorageTrie(a common.Address) Trie {
 }
 
 func (self *StateDB) HasSuicided(addr common.Address) bool {
+	self.recordRead(addr)
 	stateObject := self.getStateObject(addr)
 	if stateObject != nil {
 		return stateObject.suicided
@@ -274,6 +310,7 @@ func (self *StateDB) HasSuicided(addr common.Address) bool {
 
 // AddBalance adds amount to the account associated with addr
 func (self *StateDB) AddBalance(addr common.Address, amount *big.Int) {
+	self.recordUpdate(addr)
 	stateObject := self.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.AddBalance(amount)
@@ -282,6 +319,7 @@ func (self *StateDB) AddBalance(addr common.Address, amount *big.Int) {
 
 // SubBalance subtracts amount from the account associated with addr
 func (self *StateDB) SubBalance(addr common.Address, amount *big.Int) {
+	self.recordUpdate(addr)
 	stateObject := self.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SubBalance(amount)
@@ -289,6 +327,7 @@ func (self *StateDB) SubBalance(addr common.Address, amount *big.Int) {
 }
 
 func (self *StateDB) SetBalance(addr common.Address, amount *big.Int) {
+	self.recordWrite(addr)
 	stateObject := self.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetBalance(amount)
@@ -296,6 +335,7 @@ func (self *StateDB) SetBalance(addr common.Address, amount *big.Int) {
 }
 
 func (self *StateDB) SetNonce(addr common.Address, nonce uint64) {
+	self.recordWrite(addr)
 	stateObject := self.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetNonce(nonce)
@@ -303,6 +343,7 @@ func (self *StateDB) SetNonce(addr common.Address, nonce uint64) {
 }
 
 func (self *StateDB) SetCode(addr common.Address, code []byte) {
+	self.recordWrite(addr)
 	stateObject := self.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetCode(crypto.Keccak256Hash(code), code)
@@ -310,6 +351,7 @@ func (self *StateDB) SetCode(addr common.Address, code []byte) {
 }
 
 func (self *StateDB) SetState(addr common.Address, key common.Hash, value common.Hash) {
+	self.recordWrite(addr)
 	stateObject := self.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetState(self.db, key, value)
@@ -317,6 +359,7 @@ func (self *StateDB) SetState(addr common.Address, key common.Hash, value common
 }
 
 func (self *StateDB) SetStateByteArray(addr common.Address, key common.Hash, value []byte) {
+	self.recordWrite(addr)
 	stateObject := self.GetOrNewStateObject(addr)
 	if stateObject != nil {
 		stateObject.SetStateByteArray(self.db, key, value)
@@ -329,11 +372,12 @@ func (self *StateDB) SetStateByteArray(addr common.Address, key common.Hash, val
 // The account's state object is still available until the state is committed,
 // getStateObject will return a non-nil account after Suicide.
 func (self *StateDB) Suicide(addr common.Address) bool {
+	self.recordWrite(addr)
 	stateObject := self.getStateObject(addr)
 	if stateObject == nil {
 		return false
 	}
-	self.journal = append(self.journal, suicideChange{
+	self.appendJournal(suicideChange{
 		account:     &addr,
 		prev:        stateObject.suicided,
 		prevbalance: new(big.Int).Set(stateObject.Balance()),
@@ -356,6 +400,7 @@ func (self *StateDB) updateStateObject(stateObject *stateObject) {
 		panic(fmt.Errorf("can't encode object at %x: %v", addr[:], err))
 	}
 	self.setError(self.trie.TryUpdate(addr[:], data))
+	self.snapUpdateAccount(stateObject, data)
 }
 
 // deleteStateObject removes the given object from the state trie.
@@ -363,6 +408,7 @@ func (self *StateDB) deleteStateObject(stateObject *stateObject) {
 	stateObject.deleted = true
 	addr := stateObject.Address()
 	self.setError(self.trie.TryDelete(addr[:]))
+	self.snapDeleteAccount(stateObject)
 }
 
 // Retrieve a state object given my the address. Returns nil if not found.
@@ -375,8 +421,18 @@ func (self *StateDB) getStateObject(addr common.Address) (stateObject *stateObje
 		return obj
 	}
 
-	// Load the object from the database.
-	enc, err := self.trie.TryGet(addr[:])
+	// Load the object from the snapshot or the database.
+	var (
+		enc []byte
+		err error
+	)
+	snap := self.readSnapshot()
+	if snap != nil {
+		enc, err = snap.Account(crypto.Keccak256Hash(addr[:]))
+	}
+	if snap == nil || err != nil {
+		enc, err = self.trie.TryGet(addr[:])
+	}
 	if len(enc) == 0 {
 		self.setError(err)
 		return nil
@@ -416,11 +472,12 @@ func (self *StateDB) MarkStateObjectDirty(addr common.Address) {
 func (self *StateDB) createObject(addr common.Address) (newobj, prev *stateObject) {
 	prev = self.getStateObject(addr)
 	newobj = newObject(self, addr, Account{}, self.MarkStateObjectDirty)
+	newobj.created = true
 	newobj.setNonce(0) // sets the object to dirty
 	if prev == nil {
-		self.journal = append(self.journal, createObjectChange{account: &addr})
+		self.appendJournal(createObjectChange{account: &addr})
 	} else {
-		self.journal = append(self.journal, resetObjectChange{prev: prev})
+		self.appendJournal(resetObjectChange{prev: prev})
 	}
 	self.setStateObject(newobj)
 	return newobj, prev
@@ -437,6 +494,7 @@ func (self *StateDB) createObject(addr common.Address) (newobj, prev *stateObjec
 //
 // Carrying over the balance ensures that Ether doesn't disappear.
 func (self *StateDB) CreateAccount(addr common.Address) {
+	self.recordWrite(addr)
 	new, prev := self.createObject(addr)
 	if prev != nil {
 		new.setBalance(prev.data.Balance)
@@ -444,6 +502,7 @@ func (self *StateDB) CreateAccount(addr common.Address) {
 }
 
 func (db *StateDB) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	db.recordRead(addr)
 	so := db.getStateObject(addr)
 	if so == nil {
 		return
@@ -454,6 +513,16 @@ func (db *StateDB) ForEachStorage(addr common.Address, cb func(key, value common
 		cb(h, value)
 	}
 
+	ok := db.forEachSnapshotStorage(so, func(key common.Hash, value []byte) bool {
+		if _, ok := so.cachedStorage[key]; !ok {
+			_, content, _, _ := rlp.Split(value)
+			cb(key, common.BytesToHash(content))
+		}
+		return true
+	})
+	if ok {
+		return
+	}
 	it := trie.NewIterator(so.getTrie(db.db).NodeIterator(nil))
 	for it.Next() {
 		// ignore cached values
@@ -466,6 +535,7 @@ func (db *StateDB) ForEachStorage(addr common.Address, cb func(key, value common
 
 // cb is callback function. cb return true indicating like to continue, return false indicating stop
 func (db *StateDB) ForEachStorageByteArray(addr common.Address, cb func(key common.Hash, value []byte) bool) {
+	db.recordRead(addr)
 	so := db.getStateObject(addr)
 	if so == nil {
 		return
@@ -478,6 +548,15 @@ func (db *StateDB) ForEachStorageByteArray(addr common.Address, cb func(key comm
 		}
 	}
 
+	ok := db.forEachSnapshotStorage(so, func(key common.Hash, value []byte) bool {
+		if _, ok := so.cachedStorageByteArray[key]; !ok {
+			return cb(key, value)
+		}
+		return true
+	})
+	if ok {
+		return
+	}
 	it := trie.NewIterator(so.getTrie(db.db).NodeIterator(nil))
 	for it.Next() {
 		// ignore cached values
@@ -499,14 +578,19 @@ func (self *StateDB) Copy() *StateDB {
 	// Copy all the basic fields, initialize the memory ones
 	state := &StateDB{
 		db:                self.db,
-		trie:              self.trie,
+		trie:              self.db.CopyTrie(self.trie),
 		stateObjects:      make(map[common.Address]*stateObject, len(self.stateObjectsDirty)),
 		stateObjectsDirty: make(map[common.Address]struct{}, len(self.stateObjectsDirty)),
 		refund:            new(big.Int).Set(self.refund),
 		logs:              make(map[common.Hash][]*types.Log, len(self.logs)),
 		logSize:           self.logSize,
 		preimages:         make(map[common.Hash][]byte),
+		snaps:             self.snaps,
+		snap:              self.snap,
+		witness:           self.witness,
 	}
+	self.copySnapshotChanges(state)
+
 	// Copy the dirty states, logs, and preimages
 	for addr := range self.stateObjectsDirty {
 		state.stateObjects[addr] = self.stateObjects[addr].deepCopy(state, state.MarkStateObjectDirty)
@@ -566,6 +650,7 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 		if stateObject.suicided || (deleteEmptyObjects && stateObject.empty()) {
 			s.deleteStateObject(stateObject)
 		} else {
+			s.snapUpdateStorage(stateObject)
 			stateObject.updateRoot(s.db)
 			s.updateStateObject(stateObject)
 		}
@@ -638,6 +723,7 @@ func (s *StateDB) CommitTo(dbw trie.DatabaseWriter, deleteEmptyObjects bool) (ro
 				stateObject.dirtyCode = false
 			}
 			// Write any storage changes in the state object to its storage trie.
+			s.snapUpdateStorage(stateObject)
 			if err := stateObject.CommitTrie(s.db, dbw); err != nil {
 				return common.Hash{}, err
 			}
@@ -649,5 +735,8 @@ func (s *StateDB) CommitTo(dbw trie.DatabaseWriter, deleteEmptyObjects bool) (ro
 	// Write trie changes.
 	root, err = s.trie.CommitTo(dbw)
 	log.Debug("Trie cache stats after commit", "misses", trie.CacheMisses(), "unloads", trie.CacheUnloads())
+	if err == nil {
+		s.commitSnapshot(root)
+	}
 	return root, err
 }
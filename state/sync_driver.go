@@ -0,0 +1,369 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/trie"
+)
+
+var (
+	// syncProgressKey tracks the progress of an unfinished state sync.
+	syncProgressKey = []byte("StateSyncProgress")
+
+	// syncPendingPrefix + hash -> trie node downloaded by an unfinished state
+	// sync, waiting for its children before it can be committed.
+	syncPendingPrefix = []byte("sync-pending-")
+
+	// ErrSyncStalled is returned if a peer delivered none of the requested
+	// state data.
+	ErrSyncStalled = errors.New("state sync stalled")
+)
+
+const (
+	syncNodeBatch     = 384  // Number of trie nodes and codes requested at once
+	syncPreimageBatch = 1024 // Number of preimages requested at once
+)
+
+// SyncPeer is a source of state data for a StateSyncer.
+type SyncPeer interface {
+	// NodeData returns the trie nodes and contract codes with the given
+	// hashes in order, with nil or a short list for the unknown ones.
+	NodeData(hashes []common.Hash) ([][]byte, error)
+
+	// Preimages returns the trie keys hashing to the given hashes in order,
+	// with nil or a short list for the unknown ones.
+	Preimages(hashes []common.Hash) ([][]byte, error)
+}
+
+// SyncProgress is the progress of a state sync.
+type SyncProgress struct {
+	Root          common.Hash
+	NodesDone     uint64 // Trie nodes and codes committed
+	BytesDone     uint64 // Size of the committed trie nodes and codes
+	NodesPending  uint64 // Trie nodes and codes known but not committed yet
+	BytesPending  uint64 // Size of the downloaded nodes waiting for their children
+	PreimagesDone uint64 // Storage key preimages fetched
+}
+
+// ReadSyncProgress returns the progress of the unfinished state sync in the
+// database, if there is one.
+func ReadSyncProgress(db ethdb.Database) (*SyncProgress, bool) {
+	enc, err := db.Get(syncProgressKey)
+	if err != nil || len(enc) == 0 {
+		return nil, false
+	}
+	progress := new(SyncProgress)
+	if err := rlp.DecodeBytes(enc, progress); err != nil {
+		log.Warn("Failed to decode state sync progress", "err", err)
+		return nil, false
+	}
+	return progress, true
+}
+
+// StateSyncer downloads a state from peers into the database. Its progress,
+// including the nodes downloaded but not committed yet, is persisted after
+// every batch, so a syncer created for the same root after a crash resumes
+// without downloading the committed or pending data again.
+//
+// Trie sync doesn't transfer the preimages of the hashed trie keys. Storage
+// iterated by key, like the OTA byte-array storage, needs them, so they are
+// fetched for the storage of the accounts given to NewStateSyncer once the
+// tries are complete.
+type StateSyncer struct {
+	db       ethdb.Database
+	root     common.Hash
+	sched    *trie.TrieSync
+	accounts []common.Address // Accounts whose storage key preimages are fetched
+
+	progress SyncProgress
+	pending  map[common.Hash]int // Sizes of the persisted pending nodes
+	retry    []common.Hash       // Requested hashes not delivered yet
+}
+
+// NewStateSyncer creates a syncer of the state with the given root, resuming
+// the progress persisted in the database if it belongs to the same root.
+func NewStateSyncer(root common.Hash, db ethdb.Database, preimageAccounts []common.Address) (*StateSyncer, error) {
+	s := &StateSyncer{
+		db:       db,
+		root:     root,
+		sched:    NewStateSync(root, db),
+		accounts: preimageAccounts,
+		pending:  make(map[common.Hash]int),
+	}
+	s.progress.Root = root
+
+	if progress, ok := ReadSyncProgress(db); ok && progress.Root == root {
+		s.progress = *progress
+		var stale [][]byte
+		err := forEachPrefix(db, syncPendingPrefix, func(key, value []byte) {
+			if len(key) != len(syncPendingPrefix)+common.HashLength {
+				return
+			}
+			hash := common.BytesToHash(key[len(syncPendingPrefix):])
+			// Nodes committed just before a crash are left behind
+			if has, _ := db.Has(hash[:]); has {
+				stale = append(stale, common.CopyBytes(key))
+				return
+			}
+			s.pending[hash] = len(value)
+		})
+		if err != nil && err != errSnapshotUnsupported {
+			return nil, err
+		}
+		for _, key := range stale {
+			db.Delete(key)
+		}
+		log.Info("Resuming state sync", "root", root, "nodes", s.progress.NodesDone, "pending", len(s.pending))
+	} else {
+		// Drop the leftovers of a sync of another root
+		if err := deleteKeys(db, syncPendingPrefix, len(syncPendingPrefix)+common.HashLength); err != nil && err != errSnapshotUnsupported {
+			return nil, err
+		}
+	}
+	s.progress.BytesPending = 0
+	for _, size := range s.pending {
+		s.progress.BytesPending += uint64(size)
+	}
+	s.progress.NodesPending = uint64(s.sched.Pending())
+	return s, nil
+}
+
+// Progress returns the current progress of the sync.
+func (s *StateSyncer) Progress() SyncProgress {
+	return s.progress
+}
+
+// Sync downloads the missing state from the peer until it is complete. The
+// progress is passed to report, if not nil, after every batch. On errors the
+// sync can be continued with another peer or, after a restart, with a new
+// syncer.
+func (s *StateSyncer) Sync(peer SyncPeer, report func(SyncProgress)) error {
+	for {
+		hashes := s.retry
+		if len(hashes) < syncNodeBatch {
+			hashes = append(hashes, s.sched.Missing(syncNodeBatch-len(hashes))...)
+		}
+		s.retry = nil
+		if len(hashes) == 0 {
+			break
+		}
+		results, err := s.fetchNodes(peer, hashes)
+		if err != nil {
+			s.retry = hashes
+			return err
+		}
+		if len(results) == 0 {
+			s.retry = hashes
+			return ErrSyncStalled
+		}
+		if err := s.process(results); err != nil {
+			return err
+		}
+		if report != nil {
+			report(s.progress)
+		}
+	}
+	if err := s.syncPreimages(peer, report); err != nil {
+		return err
+	}
+	if err := s.db.Delete(syncProgressKey); err != nil {
+		return err
+	}
+	log.Info("State sync completed", "root", s.root, "nodes", s.progress.NodesDone, "bytes", s.progress.BytesDone, "preimages", s.progress.PreimagesDone)
+	return nil
+}
+
+// fetchNodes retrieves the requested nodes, from the persisted pending ones
+// if available, otherwise from the peer. Hashes not delivered are kept for
+// the next round.
+func (s *StateSyncer) fetchNodes(peer SyncPeer, hashes []common.Hash) ([]trie.SyncResult, error) {
+	var (
+		results []trie.SyncResult
+		remote  []common.Hash
+	)
+	for _, hash := range hashes {
+		if _, ok := s.pending[hash]; ok {
+			if data, err := s.db.Get(syncPendingKey(hash)); err == nil {
+				results = append(results, trie.SyncResult{Hash: hash, Data: data})
+				continue
+			}
+		}
+		remote = append(remote, hash)
+	}
+	if len(remote) == 0 {
+		return results, nil
+	}
+	data, err := peer.NodeData(remote)
+	if err != nil {
+		return nil, err
+	}
+	for i, hash := range remote {
+		if i < len(data) && len(data[i]) > 0 && crypto.Keccak256Hash(data[i]) == hash {
+			results = append(results, trie.SyncResult{Hash: hash, Data: data[i]})
+		} else {
+			s.retry = append(s.retry, hash)
+		}
+	}
+	if len(results) == 0 {
+		s.retry = nil
+	}
+	return results, nil
+}
+
+// process feeds the results to the scheduler and persists, in one batch, the
+// committed nodes, the ones still waiting for their children and the
+// progress.
+func (s *StateSyncer) process(results []trie.SyncResult) error {
+	if _, index, err := s.sched.Process(results); err != nil {
+		return fmt.Errorf("failed to process state sync result #%d: %v", index, err)
+	}
+	batch := &syncCommitter{Batch: s.db.NewBatch(), committed: make(map[common.Hash]int)}
+	if _, err := s.sched.Commit(batch); err != nil {
+		return err
+	}
+	for hash, size := range batch.committed {
+		s.progress.NodesDone++
+		s.progress.BytesDone += uint64(size)
+		if size, ok := s.pending[hash]; ok {
+			s.progress.BytesPending -= uint64(size)
+		}
+	}
+	for _, result := range results {
+		if _, ok := batch.committed[result.Hash]; ok {
+			continue
+		}
+		if _, ok := s.pending[result.Hash]; ok {
+			continue
+		}
+		if err := batch.Batch.Put(syncPendingKey(result.Hash), result.Data); err != nil {
+			return err
+		}
+		s.pending[result.Hash] = len(result.Data)
+		s.progress.BytesPending += uint64(len(result.Data))
+	}
+	s.progress.NodesPending = uint64(s.sched.Pending())
+	if err := s.writeProgress(batch.Batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	for hash := range batch.committed {
+		if _, ok := s.pending[hash]; ok {
+			delete(s.pending, hash)
+			if err := s.db.Delete(syncPendingKey(hash)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// syncPreimages fetches the missing preimages of the storage keys of the
+// configured accounts. The tries must be complete.
+func (s *StateSyncer) syncPreimages(peer SyncPeer, report func(SyncProgress)) error {
+	accounts, err := trie.NewSecure(s.root, s.db, 0)
+	if err != nil {
+		return err
+	}
+	for _, addr := range s.accounts {
+		enc, err := accounts.TryGet(addr[:])
+		if err != nil {
+			return err
+		}
+		if len(enc) == 0 {
+			continue
+		}
+		var data Account
+		if err := rlp.DecodeBytes(enc, &data); err != nil {
+			return err
+		}
+		storage, err := trie.New(data.Root, s.db)
+		if err != nil {
+			return err
+		}
+		var missing []common.Hash
+		it := trie.NewIterator(storage.NodeIterator(nil))
+		for it.Next() {
+			if has, _ := s.db.Has(preimageKey(it.Key)); !has {
+				missing = append(missing, common.BytesToHash(it.Key))
+			}
+		}
+		if it.Err != nil {
+			return it.Err
+		}
+		for len(missing) > 0 {
+			n := len(missing)
+			if n > syncPreimageBatch {
+				n = syncPreimageBatch
+			}
+			if err := s.fetchPreimages(peer, missing[:n]); err != nil {
+				return err
+			}
+			missing = missing[n:]
+			if report != nil {
+				report(s.progress)
+			}
+		}
+	}
+	return nil
+}
+
+// fetchPreimages retrieves and stores the preimages of the hashes, which
+// must all be delivered.
+func (s *StateSyncer) fetchPreimages(peer SyncPeer, hashes []common.Hash) error {
+	preimages, err := peer.Preimages(hashes)
+	if err != nil {
+		return err
+	}
+	batch := s.db.NewBatch()
+	for i, hash := range hashes {
+		if i >= len(preimages) || crypto.Keccak256Hash(preimages[i]) != hash {
+			return ErrSyncStalled
+		}
+		if err := batch.Put(preimageKey(hash[:]), preimages[i]); err != nil {
+			return err
+		}
+	}
+	s.progress.PreimagesDone += uint64(len(hashes))
+	if err := s.writeProgress(batch); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+func (s *StateSyncer) writeProgress(batch ethdb.Putter) error {
+	enc, err := rlp.EncodeToBytes(&s.progress)
+	if err != nil {
+		return err
+	}
+	return batch.Put(syncProgressKey, enc)
+}
+
+func syncPendingKey(hash common.Hash) []byte {
+	return append(append([]byte{}, syncPendingPrefix...), hash[:]...)
+}
+
+func preimageKey(hash []byte) []byte {
+	return append(append([]byte{}, preimagePrefix...), hash...)
+}
+
+// syncCommitter is the batch the scheduler commits into, recording the
+// sizes of the committed nodes.
+type syncCommitter struct {
+	ethdb.Batch
+	committed map[common.Hash]int
+}
+
+func (c *syncCommitter) Put(key []byte, value []byte) error {
+	c.committed[common.BytesToHash(key)] = len(value)
+	return c.Batch.Put(key, value)
+}
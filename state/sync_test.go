@@ -18,7 +18,9 @@ package state
 
 import (
 	"bytes"
+	"errors"
 	"math/big"
+	"reflect"
 	"testing"
 
 	"github.com/combchain/combchain/crypto"
@@ -351,3 +353,105 @@ func TestIncompleteStateSync(t *testing.T) {
 		dstDb.Put(key, value)
 	}
 }
+
+// syncTestPeer serves state data from a database, failing after a number of
+// node requests to simulate a crash.
+type syncTestPeer struct {
+	db     ethdb.Database
+	limit  int // Node requests to serve, 0 for unlimited
+	served map[common.Hash]int
+}
+
+func (p *syncTestPeer) NodeData(hashes []common.Hash) ([][]byte, error) {
+	if p.limit == 0 {
+		return nil, errors.New("peer dropped")
+	}
+	p.limit--
+
+	data := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		data[i], _ = p.db.Get(hash[:])
+		p.served[hash]++
+	}
+	return data, nil
+}
+
+func (p *syncTestPeer) Preimages(hashes []common.Hash) ([][]byte, error) {
+	preimages := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		preimages[i], _ = p.db.Get(append(common.CopyBytes(preimagePrefix), hash[:]...))
+	}
+	return preimages, nil
+}
+
+// Tests that a state sync interrupted by a failing peer resumes from its
+// persisted progress without downloading any node twice, and that the byte
+// array storage is iterable by key afterwards.
+func TestResumableStateSync(t *testing.T) {
+	srcMem, _ := ethdb.NewMemDatabase()
+	srcState, _ := New(common.Hash{}, NewDatabase(srcMem))
+	arrays := []common.Address{{0xa0}, {0xa1}}
+	for i := byte(0); i < 64; i++ {
+		addr := common.BytesToAddress([]byte{i})
+		srcState.AddBalance(addr, big.NewInt(int64(i)+1))
+		srcState.SetState(addr, common.Hash{i}, common.Hash{i + 1})
+		srcState.SetStateByteArray(arrays[i%2], common.Hash{i}, bytes.Repeat([]byte{i}, int(i)+1))
+	}
+	srcRoot, _ := srcState.CommitTo(srcMem, false)
+
+	dstDb, _ := ethdb.NewMemDatabase()
+	served := make(map[common.Hash]int)
+
+	syncer, err := NewStateSyncer(srcRoot, dstDb, arrays)
+	if err != nil {
+		t.Fatalf("failed to create syncer: %v", err)
+	}
+	if err := syncer.Sync(&syncTestPeer{db: srcMem, limit: 4, served: served}, nil); err == nil {
+		t.Fatalf("sync succeeded with failing peer")
+	}
+	interrupted := syncer.Progress()
+	if interrupted.NodesDone == 0 || interrupted.BytesPending == 0 {
+		t.Fatalf("no progress before interruption: %+v", interrupted)
+	}
+	if _, ok := ReadSyncProgress(dstDb); !ok {
+		t.Fatalf("progress not persisted")
+	}
+	// Resume with a new syncer, as after a restart
+	syncer, err = NewStateSyncer(srcRoot, dstDb, arrays)
+	if err != nil {
+		t.Fatalf("failed to resume syncer: %v", err)
+	}
+	resumed := syncer.Progress()
+	if resumed.NodesDone != interrupted.NodesDone || resumed.BytesDone != interrupted.BytesDone || resumed.BytesPending != interrupted.BytesPending {
+		t.Errorf("resumed progress mismatch: have %+v, want %+v", resumed, interrupted)
+	}
+	var reports int
+	if err := syncer.Sync(&syncTestPeer{db: srcMem, served: served}, func(SyncProgress) { reports++ }); err != nil {
+		t.Fatalf("failed to sync: %v", err)
+	}
+	if reports == 0 {
+		t.Errorf("no progress reported")
+	}
+	for hash, n := range served {
+		if n > 1 {
+			t.Errorf("node %x downloaded %d times", hash, n)
+		}
+	}
+	progress := syncer.Progress()
+	if progress.NodesDone != uint64(len(served)) || progress.NodesPending != 0 || progress.BytesPending != 0 {
+		t.Errorf("final progress mismatch: %+v, %d nodes served", progress, len(served))
+	}
+	if _, ok := ReadSyncProgress(dstDb); ok {
+		t.Errorf("progress retained after completion")
+	}
+	if err := checkStateConsistency(dstDb, srcRoot); err != nil {
+		t.Fatalf("inconsistent state: %v", err)
+	}
+	dstState, _ := New(srcRoot, NewDatabase(dstDb))
+	for _, addr := range arrays {
+		have, want := collectByteArrays(dstState, addr), collectByteArrays(srcState, addr)
+		if !reflect.DeepEqual(have, want) {
+			t.Errorf("account %x: byte arrays mismatch: have %x, want %x", addr, have, want)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/trie"
+)
+
+// StateView is a read-only view of a committed state. Unlike StateDB it is
+// safe for concurrent use and cheap to open, it has no journal or dirty
+// objects to copy. Tries are opened through the database, sharing its
+// caches, and accounts and storage are read from the snapshot if there is
+// one.
+type StateView struct {
+	db   Database
+	root common.Hash
+	snap Snapshot
+
+	trieLock sync.Mutex // Protects the account trie, which resolves nodes on reads
+	trie     Trie
+
+	lock     sync.RWMutex
+	accounts map[common.Address]*viewAccount // Accounts read so far
+	err      error                           // First error encountered
+}
+
+// viewAccount is an account read by a StateView.
+type viewAccount struct {
+	addrHash common.Hash
+	data     *Account // nil if the account doesn't exist
+
+	lock sync.Mutex // Protects the fields below
+	trie Trie       // Storage trie, opened on first use
+	code []byte     // Contract code, loaded on first use
+}
+
+// NewStateView opens a read-only view of the state with the given root.
+func NewStateView(root common.Hash, db Database) (*StateView, error) {
+	tr, err := db.OpenTrie(root)
+	if err != nil {
+		return nil, err
+	}
+	view := &StateView{
+		db:       db,
+		root:     root,
+		trie:     tr,
+		accounts: make(map[common.Address]*viewAccount),
+	}
+	if db, ok := db.(interface {
+		Snapshots() *Snapshots
+	}); ok && db.Snapshots() != nil {
+		view.snap = db.Snapshots().Snapshot(root)
+	}
+	return view, nil
+}
+
+// Fork returns a new mutable state on the viewed root, sharing the caches of
+// the view's database. It is meant for throwaway execution like call
+// simulations, which must not modify a shared state.
+func (v *StateView) Fork() (*StateDB, error) {
+	return New(v.root, v.db)
+}
+
+// Root returns the root of the viewed state.
+func (v *StateView) Root() common.Hash {
+	return v.root
+}
+
+// Error returns the first error encountered while reading the state.
+func (v *StateView) Error() error {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	return v.err
+}
+
+func (v *StateView) setError(err error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.err == nil {
+		v.err = err
+	}
+}
+
+// account returns the account at addr, loading it if not read before. Failed
+// reads aren't cached and return a missing account.
+func (v *StateView) account(addr common.Address) *viewAccount {
+	v.lock.RLock()
+	acc := v.accounts[addr]
+	v.lock.RUnlock()
+	if acc != nil {
+		return acc
+	}
+	acc = &viewAccount{addrHash: crypto.Keccak256Hash(addr[:])}
+
+	var (
+		enc []byte
+		err error
+	)
+	if v.snap != nil {
+		enc, err = v.snap.Account(acc.addrHash)
+	}
+	if v.snap == nil || err != nil {
+		v.trieLock.Lock()
+		enc, err = v.trie.TryGet(addr[:])
+		v.trieLock.Unlock()
+	}
+	if err != nil {
+		v.setError(err)
+		return acc
+	}
+	if len(enc) > 0 {
+		data := new(Account)
+		if err := rlp.DecodeBytes(enc, data); err != nil {
+			v.setError(fmt.Errorf("can't decode account %x: %v", addr, err))
+			return acc
+		}
+		acc.data = data
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	// Another reader may have loaded the account meanwhile
+	if loaded := v.accounts[addr]; loaded != nil {
+		return loaded
+	}
+	v.accounts[addr] = acc
+	return acc
+}
+
+// storageTrie returns the storage trie of the account, opening it on first
+// use. It must be called with acc.lock held.
+func (v *StateView) storageTrie(acc *viewAccount) Trie {
+	if acc.trie == nil {
+		tr, err := v.db.OpenStorageTrie(acc.addrHash, acc.data.Root)
+		if err != nil {
+			v.setError(fmt.Errorf("can't create storage trie: %v", err))
+			tr, _ = v.db.OpenStorageTrie(acc.addrHash, common.Hash{})
+		}
+		acc.trie = tr
+	}
+	return acc.trie
+}
+
+// readStorage loads the raw value of a storage slot, from the snapshot if
+// there is one, otherwise from the storage trie.
+func (v *StateView) readStorage(acc *viewAccount, key common.Hash) []byte {
+	if v.snap != nil {
+		if value, err := v.snap.Storage(acc.addrHash, crypto.Keccak256Hash(key[:])); err == nil {
+			return value
+		}
+	}
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	value, err := v.storageTrie(acc).TryGet(key[:])
+	if err != nil {
+		v.setError(err)
+	}
+	return value
+}
+
+// Exist reports whether the given account exists in the state.
+func (v *StateView) Exist(addr common.Address) bool {
+	return v.account(addr).data != nil
+}
+
+// Empty returns whether the account is either non-existent or empty
+// according to the EIP161 specification.
+func (v *StateView) Empty(addr common.Address) bool {
+	data := v.account(addr).data
+	if data == nil {
+		return true
+	}
+	emptyHash := common.Hash{}
+	return data.Nonce == 0 && data.Balance.Sign() == 0 &&
+		(bytes.Equal(data.CodeHash, emptyCodeHash) || bytes.Equal(data.CodeHash, emptyHash[:])) &&
+		(data.Root == emptyHash || data.Root == emptyTrieRoot)
+}
+
+// GetBalance returns the balance of the account, 0 if it doesn't exist. The
+// returned value must not be modified.
+func (v *StateView) GetBalance(addr common.Address) *big.Int {
+	if data := v.account(addr).data; data != nil {
+		return data.Balance
+	}
+	return common.Big0
+}
+
+func (v *StateView) GetNonce(addr common.Address) uint64 {
+	if data := v.account(addr).data; data != nil {
+		return data.Nonce
+	}
+	return 0
+}
+
+func (v *StateView) GetCodeHash(addr common.Address) common.Hash {
+	if data := v.account(addr).data; data != nil {
+		return common.BytesToHash(data.CodeHash)
+	}
+	return common.Hash{}
+}
+
+func (v *StateView) GetCode(addr common.Address) []byte {
+	acc := v.account(addr)
+	if acc.data == nil || bytes.Equal(acc.data.CodeHash, emptyCodeHash) {
+		return nil
+	}
+	acc.lock.Lock()
+	defer acc.lock.Unlock()
+
+	if acc.code == nil {
+		code, err := v.db.ContractCode(acc.addrHash, common.BytesToHash(acc.data.CodeHash))
+		if err != nil {
+			v.setError(fmt.Errorf("can't load code hash %x: %v", acc.data.CodeHash, err))
+			return nil
+		}
+		acc.code = code
+	}
+	return acc.code
+}
+
+func (v *StateView) GetCodeSize(addr common.Address) int {
+	acc := v.account(addr)
+	if acc.data == nil || bytes.Equal(acc.data.CodeHash, emptyCodeHash) {
+		return 0
+	}
+	size, err := v.db.ContractCodeSize(acc.addrHash, common.BytesToHash(acc.data.CodeHash))
+	if err != nil {
+		v.setError(err)
+	}
+	return size
+}
+
+// GetState returns a value in account storage.
+func (v *StateView) GetState(addr common.Address, key common.Hash) common.Hash {
+	acc := v.account(addr)
+	if acc.data == nil {
+		return common.Hash{}
+	}
+	var value common.Hash
+	if enc := v.readStorage(acc, key); len(enc) > 0 {
+		_, content, _, err := rlp.Split(enc)
+		if err != nil {
+			v.setError(err)
+		}
+		value.SetBytes(content)
+	}
+	return value
+}
+
+// GetStateByteArray returns a value written by SetStateByteArray.
+func (v *StateView) GetStateByteArray(addr common.Address, key common.Hash) []byte {
+	acc := v.account(addr)
+	if acc.data == nil {
+		return nil
+	}
+	return v.readStorage(acc, key)
+}
+
+// ForEachStorage calls cb with the storage slots of the account written by
+// SetState until it returns false.
+func (v *StateView) ForEachStorage(addr common.Address, cb func(key, value common.Hash) bool) {
+	v.forEachStorage(addr, func(key common.Hash, value []byte) bool {
+		_, content, _, _ := rlp.Split(value)
+		return cb(key, common.BytesToHash(content))
+	})
+}
+
+// ForEachStorageByteArray calls cb with the storage slots of the account
+// written by SetStateByteArray until it returns false.
+func (v *StateView) ForEachStorageByteArray(addr common.Address, cb func(key common.Hash, value []byte) bool) {
+	v.forEachStorage(addr, cb)
+}
+
+// forEachStorage iterates the raw storage slots of the account. No locks are
+// held while cb runs, so it may read the view.
+func (v *StateView) forEachStorage(addr common.Address, cb func(key common.Hash, value []byte) bool) {
+	acc := v.account(addr)
+	if acc.data == nil {
+		return
+	}
+	if v.snap != nil {
+		err := v.snap.ForEachStorage(acc.addrHash, func(hash common.Hash, value []byte) bool {
+			return cb(v.preimage(hash), value)
+		})
+		if err == nil {
+			return
+		}
+	}
+	acc.lock.Lock()
+	tr := v.db.CopyTrie(v.storageTrie(acc))
+	acc.lock.Unlock()
+
+	it := trie.NewIterator(tr.NodeIterator(nil))
+	for it.Next() {
+		if !cb(v.preimage(common.BytesToHash(it.Key)), it.Value) {
+			return
+		}
+	}
+	if it.Err != nil {
+		v.setError(it.Err)
+	}
+}
+
+// preimage returns the storage key of a hashed trie key.
+func (v *StateView) preimage(hash common.Hash) common.Hash {
+	v.trieLock.Lock()
+	defer v.trieLock.Unlock()
+
+	return common.BytesToHash(v.trie.GetKey(hash[:]))
+}
@@ -0,0 +1,110 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+)
+
+// Tests that state views read the committed state like a StateDB, from
+// many goroutines at once, with and without snapshots.
+func TestStateView(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	snaps, _ := NewSnapshots(db, common.Hash{})
+
+	for _, sdb := range []Database{NewDatabase(db), NewDatabaseWithSnapshots(db, snaps)} {
+		statedb, _ := New(common.Hash{}, sdb)
+		addrs := make([]common.Address, 16)
+		for i := range addrs {
+			addrs[i] = common.BytesToAddress([]byte{0x10, byte(i)})
+			statedb.AddBalance(addrs[i], big.NewInt(int64(1000*i+1)))
+			statedb.SetNonce(addrs[i], uint64(i))
+			statedb.SetState(addrs[i], common.Hash{0x01}, common.BytesToHash([]byte{byte(i + 1)}))
+			statedb.SetStateByteArray(addrs[i], common.Hash{0x02}, bytes.Repeat([]byte{byte(i)}, i+1))
+		}
+		statedb.SetCode(addrs[1], []byte{0x60, 0x00})
+		root, err := statedb.CommitTo(db, true)
+		if err != nil {
+			t.Fatalf("failed to commit state: %v", err)
+		}
+		trieState, _ := New(root, NewDatabase(db))
+
+		// Read the expected values up front, the StateDB isn't concurrent-safe
+		type account struct {
+			balance *big.Int
+			nonce   uint64
+			exist   bool
+			code    []byte
+			slot    common.Hash
+			array   []byte
+			arrays  map[common.Hash][]byte
+		}
+		all := append(addrs, common.Address{0xff})
+		want := make(map[common.Address]account)
+		for _, addr := range all {
+			want[addr] = account{
+				balance: trieState.GetBalance(addr),
+				nonce:   trieState.GetNonce(addr),
+				exist:   trieState.Exist(addr),
+				code:    trieState.GetCode(addr),
+				slot:    trieState.GetState(addr, common.Hash{0x01}),
+				array:   trieState.GetStateByteArray(addr, common.Hash{0x02}),
+				arrays:  collectByteArrays(trieState, addr),
+			}
+		}
+		view, err := NewStateView(root, sdb)
+		if err != nil {
+			t.Fatalf("failed to open view: %v", err)
+		}
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for _, addr := range all {
+					want := want[addr]
+					have := account{
+						balance: view.GetBalance(addr),
+						nonce:   view.GetNonce(addr),
+						exist:   view.Exist(addr),
+						code:    view.GetCode(addr),
+						slot:    view.GetState(addr, common.Hash{0x01}),
+						array:   view.GetStateByteArray(addr, common.Hash{0x02}),
+						arrays:  make(map[common.Hash][]byte),
+					}
+					view.ForEachStorageByteArray(addr, func(key common.Hash, value []byte) bool {
+						have.arrays[key] = value
+						return true
+					})
+					if !reflect.DeepEqual(have, want) {
+						t.Errorf("account %x: mismatch: have %+v, want %+v", addr, have, want)
+					}
+					if size := view.GetCodeSize(addr); size != len(want.code) {
+						t.Errorf("account %x: code size mismatch: have %d, want %d", addr, size, len(want.code))
+					}
+				}
+			}()
+		}
+		wg.Wait()
+
+		if err := view.Error(); err != nil {
+			t.Errorf("view read failed: %v", err)
+		}
+		// Forks are independent of the view
+		fork, err := view.Fork()
+		if err != nil {
+			t.Fatalf("failed to fork view: %v", err)
+		}
+		fork.AddBalance(addrs[0], big.NewInt(1))
+		if view.GetBalance(addrs[0]).Cmp(big.NewInt(1)) != 0 {
+			t.Errorf("fork modified the view")
+		}
+	}
+}
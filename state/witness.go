@@ -0,0 +1,192 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package state
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/trie"
+)
+
+var (
+	errWitnessUnsupported = errors.New("state database doesn't support witness recording")
+)
+
+// Witness holds the parts of a state read while executing on it: the trie
+// nodes, contract codes and trie key preimages. Executing the same
+// transactions on a database created by NewWitnessDatabase gives the same
+// results without the rest of the state.
+type Witness struct {
+	Root      common.Hash // Root of the state the witness was recorded on
+	Nodes     [][]byte    // Encoded trie nodes
+	Codes     [][]byte    // Contract codes
+	Preimages [][]byte    // Preimages of hashed trie keys
+}
+
+// NewWitnessDatabase creates a state database holding only the contents of
+// the witness. Reading state missing from it fails like reading a pruned
+// state. Changes committed to its states are kept in memory.
+func NewWitnessDatabase(witness *Witness) Database {
+	db, _ := ethdb.NewMemDatabase()
+	for _, node := range witness.Nodes {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	for _, code := range witness.Codes {
+		db.Put(crypto.Keccak256(code), code)
+	}
+	for _, preimage := range witness.Preimages {
+		db.Put(append(common.CopyBytes(preimagePrefix), crypto.Keccak256(preimage)...), preimage)
+	}
+	return NewDatabase(db)
+}
+
+// StartWitness starts recording the trie nodes, codes and preimages read
+// through the state, until StopWitness. It must be called on a freshly
+// opened state. While recording the state is read from the tries instead of
+// the snapshot, so that the witness covers every read.
+func (self *StateDB) StartWitness() error {
+	disk, ok := self.db.(interface {
+		DiskDB() ethdb.Database
+	})
+	if !ok {
+		return errWitnessUnsupported
+	}
+	root := self.trie.Hash()
+	rec := &witnessRecorder{
+		Database:  self.db,
+		disk:      disk.DiskDB(),
+		root:      root,
+		nodes:     make(map[common.Hash][]byte),
+		codes:     make(map[common.Hash][]byte),
+		preimages: make(map[common.Hash][]byte),
+	}
+	tr, err := rec.OpenTrie(root)
+	if err != nil {
+		return err
+	}
+	self.db, self.trie, self.witness = rec, tr, rec
+	return nil
+}
+
+// StopWitness ends the recording started by StartWitness and returns the
+// witness of the reads in between, nil if not recording. The nodes needed to
+// hash the state are only read by IntermediateRoot, which should be called
+// before.
+func (self *StateDB) StopWitness() *Witness {
+	rec := self.witness
+	if rec == nil {
+		return nil
+	}
+	self.witness = nil
+	return rec.witness()
+}
+
+// witnessRecorder is a state database recording the data read through it.
+// Tries are opened on the disk database without the caches of the wrapped
+// one, so that every node read is seen.
+type witnessRecorder struct {
+	Database // Wrapped database, used for writes
+
+	disk ethdb.Database
+	root common.Hash
+
+	lock      sync.Mutex
+	nodes     map[common.Hash][]byte
+	codes     map[common.Hash][]byte
+	preimages map[common.Hash][]byte
+}
+
+func (rec *witnessRecorder) OpenTrie(root common.Hash) (Trie, error) {
+	return trie.NewSecure(root, &witnessReader{rec}, MaxTrieCacheGen)
+}
+
+func (rec *witnessRecorder) OpenStorageTrie(addrHash, root common.Hash) (Trie, error) {
+	return trie.NewSecure(root, &witnessReader{rec}, 0)
+}
+
+func (rec *witnessRecorder) CopyTrie(t Trie) Trie {
+	if t, ok := t.(*trie.SecureTrie); ok {
+		return t.Copy()
+	}
+	return rec.Database.CopyTrie(t)
+}
+
+func (rec *witnessRecorder) ContractCode(addrHash, codeHash common.Hash) ([]byte, error) {
+	code, err := rec.Database.ContractCode(addrHash, codeHash)
+	if err == nil {
+		rec.lock.Lock()
+		rec.codes[codeHash] = code
+		rec.lock.Unlock()
+	}
+	return code, err
+}
+
+// ContractCodeSize reads the whole code, the verifier needs it to know the
+// size.
+func (rec *witnessRecorder) ContractCodeSize(addrHash, codeHash common.Hash) (int, error) {
+	code, err := rec.ContractCode(addrHash, codeHash)
+	return len(code), err
+}
+
+// witness assembles the recorded data in a deterministic order.
+func (rec *witnessRecorder) witness() *Witness {
+	rec.lock.Lock()
+	defer rec.lock.Unlock()
+
+	return &Witness{
+		Root:      rec.root,
+		Nodes:     sortedValues(rec.nodes),
+		Codes:     sortedValues(rec.codes),
+		Preimages: sortedValues(rec.preimages),
+	}
+}
+
+func sortedValues(values map[common.Hash][]byte) [][]byte {
+	hashes := make([]common.Hash, 0, len(values))
+	for hash := range values {
+		hashes = append(hashes, hash)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	list := make([][]byte, len(hashes))
+	for i, hash := range hashes {
+		list[i] = values[hash]
+	}
+	return list
+}
+
+// witnessReader is the trie database of a witnessRecorder, recording the
+// trie nodes and preimages read from the disk database.
+type witnessReader struct {
+	rec *witnessRecorder
+}
+
+func (r *witnessReader) Get(key []byte) ([]byte, error) {
+	value, err := r.rec.disk.Get(key)
+	if err != nil {
+		return value, err
+	}
+	r.rec.lock.Lock()
+	defer r.rec.lock.Unlock()
+
+	if len(key) == len(preimagePrefix)+common.HashLength && bytes.HasPrefix(key, preimagePrefix) {
+		r.rec.preimages[common.BytesToHash(key[len(preimagePrefix):])] = common.CopyBytes(value)
+	} else {
+		r.rec.nodes[common.BytesToHash(key)] = common.CopyBytes(value)
+	}
+	return value, nil
+}
+
+func (r *witnessReader) Has(key []byte) (bool, error) {
+	return r.rec.disk.Has(key)
+}
+
+func (r *witnessReader) Put(key []byte, value []byte) error {
+	return r.rec.disk.Put(key, value)
+}
@@ -0,0 +1,184 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+var (
+	// ErrUnknownBlock is returned by archive queries for blocks not in the chain.
+	ErrUnknownBlock = errors.New("unknown block")
+
+	// ErrStateUnavailable is returned by archive queries if the state of the
+	// block is missing or incomplete, e.g. because it has been pruned.
+	ErrStateUnavailable = errors.New("state unavailable")
+)
+
+// BlockID identifies a block by hash or, if Hash is zero, by its number in
+// the canonical chain.
+type BlockID struct {
+	Hash   common.Hash
+	Number uint64
+}
+
+// StateArchive answers state queries at historical blocks. The states opened
+// for the queries are kept in an LRU cache keyed by state root, so repeated
+// and range queries don't reopen the tries. The cached states are read-only
+// views, so queries run in parallel.
+type StateArchive struct {
+	bc     *BlockChain
+	states *lru.Cache // State root -> *state.StateView
+}
+
+// NewStateArchive creates an archive over the states of the block chain,
+// keeping up to cache states open.
+func NewStateArchive(bc *BlockChain, cache int) *StateArchive {
+	states, _ := lru.New(cache)
+	return &StateArchive{
+		bc:     bc,
+		states: states,
+	}
+}
+
+// BalanceAt returns the balance of the account at the given block.
+func (a *StateArchive) BalanceAt(addr common.Address, id BlockID) (*big.Int, error) {
+	var balance *big.Int
+	err := a.at(id, func(view *state.StateView) error {
+		balance = new(big.Int).Set(view.GetBalance(addr))
+		return nil
+	})
+	return balance, err
+}
+
+// NonceAt returns the nonce of the account at the given block.
+func (a *StateArchive) NonceAt(addr common.Address, id BlockID) (uint64, error) {
+	var nonce uint64
+	err := a.at(id, func(view *state.StateView) error {
+		nonce = view.GetNonce(addr)
+		return nil
+	})
+	return nonce, err
+}
+
+// CodeAt returns the contract code of the account at the given block.
+func (a *StateArchive) CodeAt(addr common.Address, id BlockID) ([]byte, error) {
+	var code []byte
+	err := a.at(id, func(view *state.StateView) error {
+		code = common.CopyBytes(view.GetCode(addr))
+		return nil
+	})
+	return code, err
+}
+
+// StorageAt returns the value of a storage slot of the account at the given
+// block.
+func (a *StateArchive) StorageAt(addr common.Address, key common.Hash, id BlockID) (common.Hash, error) {
+	var value common.Hash
+	err := a.at(id, func(view *state.StateView) error {
+		value = view.GetState(addr, key)
+		return nil
+	})
+	return value, err
+}
+
+// OTABalanceAt returns the balance of the one-time address at the given
+// block, zero if the OTA doesn't exist.
+func (a *StateArchive) OTABalanceAt(ota []byte, id BlockID) (*big.Int, error) {
+	ax, err := vm.GetAXFromcombAddr(ota)
+	if err != nil {
+		return nil, err
+	}
+	var balance *big.Int
+	err = a.at(id, func(view *state.StateView) (err error) {
+		balance, err = vm.GetOtaBalanceFromAX(view, ax)
+		return err
+	})
+	return balance, err
+}
+
+// BalanceRange returns the balances of the account at the canonical blocks
+// first to last, inclusive.
+func (a *StateArchive) BalanceRange(addr common.Address, first, last uint64) ([]*big.Int, error) {
+	var balances []*big.Int
+	err := a.ForEachState(first, last, func(header *types.Header, view *state.StateView) error {
+		balances = append(balances, new(big.Int).Set(view.GetBalance(addr)))
+		return nil
+	})
+	return balances, err
+}
+
+// StorageRange returns the values of a storage slot of the account at the
+// canonical blocks first to last, inclusive.
+func (a *StateArchive) StorageRange(addr common.Address, key common.Hash, first, last uint64) ([]common.Hash, error) {
+	var values []common.Hash
+	err := a.ForEachState(first, last, func(header *types.Header, view *state.StateView) error {
+		values = append(values, view.GetState(addr, key))
+		return nil
+	})
+	return values, err
+}
+
+// ForEachState calls fn with the state of every canonical block from first to
+// last, inclusive, stopping at the first error. The views are shared with
+// other queries, which may read them concurrently.
+func (a *StateArchive) ForEachState(first, last uint64, fn func(header *types.Header, view *state.StateView) error) error {
+	for number := first; number <= last; number++ {
+		header := a.bc.GetHeaderByNumber(number)
+		if header == nil {
+			return ErrUnknownBlock
+		}
+		err := a.query(header.Root, func(view *state.StateView) error {
+			return fn(header, view)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// at runs fn on the state of the identified block.
+func (a *StateArchive) at(id BlockID, fn func(*state.StateView) error) error {
+	var header *types.Header
+	if id.Hash != (common.Hash{}) {
+		header = a.bc.GetHeaderByHash(id.Hash)
+	} else {
+		header = a.bc.GetHeaderByNumber(id.Number)
+	}
+	if header == nil {
+		return ErrUnknownBlock
+	}
+	return a.query(header.Root, fn)
+}
+
+// query runs fn on the state with the given root, opening it if not cached.
+// States failing to load a trie node are evicted, as their answers may be
+// wrong.
+func (a *StateArchive) query(root common.Hash, fn func(*state.StateView) error) error {
+	var view *state.StateView
+	if cached, ok := a.states.Get(root); ok {
+		view = cached.(*state.StateView)
+	} else {
+		var err error
+		if view, err = a.bc.StateViewAt(root); err != nil {
+			return ErrStateUnavailable
+		}
+		a.states.Add(root, view)
+	}
+	if err := fn(view); err != nil {
+		return err
+	}
+	if view.Error() != nil {
+		a.states.Remove(root)
+		return ErrStateUnavailable
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/types"
+)
+
+// Tests that archive queries return the state of the requested block, both by
+// number and by hash, and fail cleanly for unknown blocks and pruned states.
+func TestStateArchive(t *testing.T) {
+	c, err := newTestChain(big.NewInt(1000000000))
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer c.stop()
+
+	var (
+		addr    = c.addr
+		dest    = common.HexToAddress("0xdeadbeef")
+		counter = crypto.CreateAddress(addr, 0)
+	)
+	// Deploy a counter in the first block, then transfer and count in every block
+	chain := c.generate(nil, 8, func(i int, gen *BlockGen) {
+		if i == 0 {
+			c.send(gen, types.NewContractCreation(gen.TxNonce(addr), new(big.Int), big.NewInt(100000), new(big.Int), counterCode))
+			return
+		}
+		c.transfer(gen, dest)
+		c.send(gen, types.NewTransaction(gen.TxNonce(addr), counter, new(big.Int), big.NewInt(100000), new(big.Int), nil))
+	})
+	if _, err := c.blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	archive := NewStateArchive(c.blockchain, 4)
+
+	for number := uint64(1); number <= 8; number++ {
+		byNumber, byHash := BlockID{Number: number}, BlockID{Hash: chain[number-1].Hash()}
+
+		want := big.NewInt(int64(1000 * (number - 1)))
+		for _, id := range []BlockID{byNumber, byHash} {
+			if balance, err := archive.BalanceAt(dest, id); err != nil || balance.Cmp(want) != 0 {
+				t.Errorf("block %d: balance mismatch: have %v (%v), want %v", number, balance, err, want)
+			}
+		}
+		if nonce, err := archive.NonceAt(addr, byNumber); err != nil || nonce != 2*number-1 {
+			t.Errorf("block %d: nonce mismatch: have %d (%v), want %d", number, nonce, err, 2*number-1)
+		}
+		if code, err := archive.CodeAt(counter, byHash); err != nil || !bytes.Equal(code, counterCode[12:]) {
+			t.Errorf("block %d: code mismatch: have %x (%v), want %x", number, code, err, counterCode[12:])
+		}
+		if value, err := archive.StorageAt(counter, common.Hash{}, byNumber); err != nil || value != common.BigToHash(big.NewInt(int64(number-1))) {
+			t.Errorf("block %d: storage mismatch: have %x (%v), want %d", number, value, err, number-1)
+		}
+	}
+	// Queries on the same states must be answerable in parallel
+	errc := make(chan error, 16)
+	for i := 0; i < cap(errc); i++ {
+		go func(number uint64) {
+			balance, err := archive.BalanceAt(dest, BlockID{Number: number})
+			if err == nil && balance.Cmp(big.NewInt(int64(1000*(number-1)))) != 0 {
+				err = fmt.Errorf("block %d: balance mismatch: have %v", number, balance)
+			}
+			errc <- err
+		}(uint64(i%8 + 1))
+	}
+	for i := 0; i < cap(errc); i++ {
+		if err := <-errc; err != nil {
+			t.Errorf("parallel query failed: %v", err)
+		}
+	}
+	balances, err := archive.BalanceRange(dest, 2, 6)
+	if err != nil {
+		t.Fatalf("failed to query balance range: %v", err)
+	}
+	for i, balance := range balances {
+		if want := big.NewInt(int64(1000 * (i + 1))); balance.Cmp(want) != 0 {
+			t.Errorf("block %d: ranged balance mismatch: have %v, want %v", i+2, balance, want)
+		}
+	}
+	if len(balances) != 5 {
+		t.Errorf("ranged balance count mismatch: have %d, want %d", len(balances), 5)
+	}
+	if _, err := archive.BalanceAt(dest, BlockID{Number: 9}); err != ErrUnknownBlock {
+		t.Errorf("unknown block error mismatch: have %v, want %v", err, ErrUnknownBlock)
+	}
+	// States dropped by the pruner must not be served
+	c.stop()
+	if _, err := NewStatePruner(c.db, 2, 0).Prune(); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	if err := c.open(); err != nil {
+		t.Fatalf("failed to reopen chain: %v", err)
+	}
+	archive = NewStateArchive(c.blockchain, 4)
+	if _, err := archive.BalanceAt(dest, BlockID{Number: 3}); err != ErrStateUnavailable {
+		t.Errorf("pruned state error mismatch: have %v, want %v", err, ErrStateUnavailable)
+	}
+	if _, err := archive.BalanceAt(dest, BlockID{Number: 8}); err != nil {
+		t.Errorf("failed to query retained state: %v", err)
+	}
+}
@@ -19,6 +19,7 @@ package core
 
 import (
 	"math/big"
+	"sync"
 
 	"github.com/combchain/go-combchain/common"
 	"github.com/combchain/go-combchain/consensus"
@@ -36,8 +37,30 @@ import (
 // StateProcessor implements Processor.
 type StateProcessor struct {
 	config *params.ChainConfig // Chain configuration options
-	bc     *BlockChain         // Canonical block chain
+	bc     processorChain      // Canonical block chain
 	engine consensus.Engine    // Consensus engine used for block rewards
+
+	lock      sync.RWMutex        // Protects the settings below, changed while blocks are processed
+	workers   int                 // Number of transactions executed speculatively in parallel
+	diffs     func(*BlockDiff)    // Receiver of the state diffs of processed blocks, nil if not recording
+	witnesses func(*BlockWitness) // Receiver of the witnesses of processed blocks, nil if not recording
+}
+
+// processorChain is the chain access needed to process blocks.
+type processorChain interface {
+	consensus.ChainReader
+
+	// Engine retrieves the chain's consensus engine.
+	Engine() consensus.Engine
+}
+
+// BlockDiff is the state diff of a processed block.
+type BlockDiff struct {
+	Hash         common.Hash        `json:"hash"`
+	Number       uint64             `json:"number"`
+	Transactions []*state.StateDiff `json:"transactions"` // Changes made by each transaction
+	Finalize     *state.StateDiff   `json:"finalize"`     // Changes made by the consensus engine, e.g. rewards
+	Diff         *state.StateDiff   `json:"diff"`         // Changes made by the whole block
 }
 
 // NewStateProcessor initialises a new StateProcessor.
@@ -49,6 +72,39 @@ func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consen
 	}
 }
 
+// SetWorkers sets the number of transactions executed speculatively in
+// parallel by Process. With fewer than two workers transactions are applied
+// strictly sequentially.
+func (p *StateProcessor) SetWorkers(workers int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.workers = workers
+}
+
+// SetDiffHandler makes Process record the changes every block makes to the
+// state and pass them to handler, nil to stop recording. Blocks are then
+// processed sequentially. The handler is called for every processed block,
+// including ones later rejected by validation.
+func (p *StateProcessor) SetDiffHandler(handler func(*BlockDiff)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.diffs = handler
+}
+
+// SetWitnessHandler makes Process record the state read by every block and
+// pass the witness needed to verify the block without the state to handler,
+// see VerifyWitness, nil to stop recording. Blocks are then processed
+// sequentially. The handler is called for every block processed without
+// error. The state passed to Process must be freshly opened.
+func (p *StateProcessor) SetWitnessHandler(handler func(*BlockWitness)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.witnesses = handler
+}
+
 // Process processes the state changes according to the Ethereum rules by running
 // the transaction messages using the statedb and applying any rewards to both
 // the processor (coinbase) and any included uncles.
@@ -56,14 +112,38 @@ func NewStateProcessor(config *params.ChainConfig, bc *BlockChain, engine consen
 // Process returns the receipts and logs accumulated during the process and
 // returns the amount of gas that was used in the process. If any of the
 // transactions failed to execute due to insufficient gas it will return an error.
+//
+// If more than one worker is configured, the transactions are executed
+// speculatively in parallel with the same results, see SetWorkers.
 func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg vm.Config) (types.Receipts, []*types.Log, *big.Int, error) {
+	// Settings changed meanwhile apply from the next block on
+	p.lock.RLock()
+	workers, diffs, witnesses := p.workers, p.diffs, p.witnesses
+	p.lock.RUnlock()
+
+	if workers > 1 && !cfg.Debug && diffs == nil && witnesses == nil && len(block.Transactions()) > 1 {
+		return p.processParallel(block, statedb, cfg, workers)
+	}
 	var (
 		receipts     types.Receipts
 		totalUsedGas = big.NewInt(0)
 		header       = block.Header()
 		allLogs      []*types.Log
 		gp           = new(GasPool).AddGas(block.GasLimit())
+		diff         *BlockDiff
+		bc           = p.bc
+		headers      *headerRecorder
 	)
+	if diffs != nil {
+		diff = &BlockDiff{Hash: block.Hash(), Number: block.NumberU64()}
+	}
+	if witnesses != nil {
+		if err := statedb.StartWitness(); err != nil {
+			return nil, nil, nil, err
+		}
+		headers = &headerRecorder{processorChain: p.bc, headers: make(map[common.Hash]*types.Header)}
+		bc = headers
+	}
 	// Mutate the the block and state according to any hard-fork specs
 	//if p.config.DAOForkSupport && p.config.DAOForkBlock != nil && p.config.DAOForkBlock.Cmp(block.Number()) == 0 {
 	//	misc.ApplyDAOHardFork(statedb)
@@ -71,7 +151,13 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 	// Iterate over and process the individual transactions
 	for i, tx := range block.Transactions() {
 		statedb.Prepare(tx.Hash(), block.Hash(), i)
-		receipt, _, err := ApplyTransaction(p.config, p.bc, nil, gp, statedb, header, tx, totalUsedGas, cfg)
+		if diff != nil {
+			statedb.StartDiff()
+		}
+		receipt, _, err := ApplyTransaction(p.config, bc, nil, gp, statedb, header, tx, totalUsedGas, cfg)
+		if diff != nil {
+			diff.Transactions = append(diff.Transactions, statedb.StopDiff())
+		}
 		if err != nil {
 			return nil, nil, nil, err
 		}
@@ -79,8 +165,29 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 		allLogs = append(allLogs, receipt.Logs...)
 	}
 	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
-	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles(), receipts)
+	if diff != nil {
+		statedb.StartDiff()
+	}
+	p.engine.Finalize(bc, header, statedb, block.Transactions(), block.Uncles(), receipts)
 
+	if diff != nil {
+		diff.Finalize = statedb.StopDiff()
+		diff.Diff = state.NewStateDiff()
+		for _, txDiff := range diff.Transactions {
+			diff.Diff.Merge(txDiff)
+		}
+		diff.Diff.Merge(diff.Finalize)
+		diffs(diff)
+	}
+	if headers != nil {
+		statedb.IntermediateRoot(true)
+		witnesses(&BlockWitness{
+			Hash:    block.Hash(),
+			Number:  block.NumberU64(),
+			State:   statedb.StopWitness(),
+			Headers: headers.list(),
+		})
+	}
 	return receipts, allLogs, totalUsedGas, nil
 }
 
@@ -88,7 +195,7 @@ func (p *StateProcessor) Process(block *types.Block, statedb *state.StateDB, cfg
 // and uses the input parameters for its environment. It returns the receipt
 // for the transaction, gas used and an error if the transaction failed,
 // indicating the block was invalid.
-func ApplyTransaction(config *params.ChainConfig, bc *BlockChain, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *big.Int, cfg vm.Config) (*types.Receipt, *big.Int, error) {
+func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *big.Int, cfg vm.Config) (*types.Receipt, *big.Int, error) {
 	msg, err := tx.AsMessage(types.MakeSigner(config, header.Number))
 	if err != nil {
 		return nil, nil, err
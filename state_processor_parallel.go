@@ -0,0 +1,147 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"math/big"
+	"sync"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/metrics"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+var (
+	speculativeHitCounter  = metrics.NewCounter("processor/speculative/hits")
+	speculativeMissCounter = metrics.NewCounter("processor/speculative/misses")
+)
+
+// speculation is the outcome of a transaction executed on a copy of the
+// state the block starts from, in isolation of the other transactions.
+type speculation struct {
+	state   *state.StateDB
+	access  *state.RWSet
+	receipt *types.Receipt
+	gas     *big.Int
+	err     error
+}
+
+// processParallel is the parallel counterpart of Process. All transactions
+// are first executed speculatively on copies of statedb by workers
+// goroutines. The results are then merged into statedb in block order. A
+// transaction that accessed an account modified by an earlier transaction
+// of the block, or that failed speculatively, is re-executed on statedb
+// instead, so the outcome is identical to sequential processing.
+func (p *StateProcessor) processParallel(block *types.Block, statedb *state.StateDB, cfg vm.Config, workers int) (types.Receipts, []*types.Log, *big.Int, error) {
+	var (
+		receipts     types.Receipts
+		totalUsedGas = big.NewInt(0)
+		header       = block.Header()
+		allLogs      []*types.Log
+		gp           = new(GasPool).AddGas(block.GasLimit())
+		specs        = p.speculate(block, statedb, cfg)
+		written      = make(map[common.Address]struct{})
+	)
+	for i, tx := range block.Transactions() {
+		statedb.Prepare(tx.Hash(), block.Hash(), i)
+
+		spec := specs[i]
+		if spec.err == nil && !spec.access.Conflicts(written) && gp.available(spec.required(tx)) {
+			speculativeHitCounter.Inc(1)
+
+			gp.SubGas(spec.gas)
+			statedb.Merge(spec.state, spec.access)
+			statedb.Finalise(true)
+			spec.access.AddWrites(written)
+
+			totalUsedGas.Add(totalUsedGas, spec.gas)
+
+			receipt := types.NewReceipt(nil, spec.receipt.Status == types.ReceiptStatusFailed, totalUsedGas)
+			receipt.TxHash = spec.receipt.TxHash
+			receipt.GasUsed = spec.receipt.GasUsed
+			receipt.ContractAddress = spec.receipt.ContractAddress
+			receipt.Logs = statedb.GetLogs(tx.Hash())
+			receipt.Bloom = types.CreateBloom(types.Receipts{receipt})
+
+			receipts = append(receipts, receipt)
+			allLogs = append(allLogs, receipt.Logs...)
+			continue
+		}
+		speculativeMissCounter.Inc(1)
+
+		statedb.StartRecording()
+		receipt, _, err := ApplyTransaction(p.config, p.bc, nil, gp, statedb, header, tx, totalUsedGas, cfg)
+		access := statedb.StopRecording()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		access.AddWrites(written)
+
+		receipts = append(receipts, receipt)
+		allLogs = append(allLogs, receipt.Logs...)
+	}
+	// Finalize the block, applying any consensus engine specific extras (e.g. block rewards)
+	p.engine.Finalize(p.bc, header, statedb, block.Transactions(), block.Uncles(), receipts)
+
+	return receipts, allLogs, totalUsedGas, nil
+}
+
+// speculate executes every transaction of the block on its own copy of
+// statedb, recording the accounts each one accesses.
+func (p *StateProcessor) speculate(block *types.Block, statedb *state.StateDB, cfg vm.Config) []*speculation {
+	var (
+		txs    = block.Transactions()
+		header = block.Header()
+		specs  = make([]*speculation, len(txs))
+		jobs   = make(chan int, len(txs))
+		wg     sync.WaitGroup
+	)
+	for i := range txs {
+		jobs <- i
+	}
+	close(jobs)
+
+	for w := 0; w < workers && w < len(txs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				view := statedb.Copy()
+				view.Prepare(txs[i].Hash(), block.Hash(), i)
+				view.StartRecording()
+
+				// The gas pool of the block is only known once the preceding
+				// transactions are merged, check it then.
+				gp := new(GasPool).AddGas(block.GasLimit())
+				receipt, gas, err := ApplyTransaction(p.config, p.bc, nil, gp, view, header, txs[i], new(big.Int), cfg)
+
+				specs[i] = &speculation{
+					state:   view,
+					access:  view.StopRecording(),
+					receipt: receipt,
+					gas:     gas,
+					err:     err,
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return specs
+}
+
+// required returns the amount of gas the block gas pool must provide for the
+// speculated transaction to be applied. Normal transactions buy their whole
+// gas limit upfront, privacy transactions only the gas they consume.
+func (spec *speculation) required(tx *types.Transaction) *big.Int {
+	if types.IsNormalTransaction(tx.Txtype()) {
+		return tx.Gas()
+	}
+	return spec.gas
+}
+
+// available reports whether the pool holds at least amount gas.
+func (gp *GasPool) available(amount *big.Int) bool {
+	return (*big.Int)(gp).Cmp(amount) >= 0
+}
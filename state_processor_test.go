@@ -0,0 +1,307 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"testing"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/consensus/ethash"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+// counterCode deploys a contract incrementing storage slot 0 and emitting an
+// empty log on every call.
+var counterCode = common.FromHex("600f600c600039600f6000f360005460010160005560006000a000")
+
+// Tests that processing generated chains with speculative parallel execution
+// yields exactly the receipts, logs, gas and state of sequential processing.
+func TestParallelProcessing(t *testing.T) {
+	for seed := int64(1); seed <= 4; seed++ {
+		testParallelProcessing(t, seed)
+	}
+}
+
+func testParallelProcessing(t *testing.T, seed int64) {
+	var (
+		keys  = make([]*ecdsa.PrivateKey, 8)
+		addrs = make([]common.Address, len(keys))
+		db, _ = ethdb.NewMemDatabase()
+		gspec = DefaultPPOWTestingGenesisBlock()
+		rnd   = rand.New(rand.NewSource(seed))
+	)
+	gspec.Alloc = make(GenesisAlloc)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		addrs[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+		gspec.Alloc[addrs[i]] = GenesisAccount{Balance: big.NewInt(1000000000000)}
+	}
+	genesis := gspec.MustCommit(db)
+	engine := ethash.NewFaker(db)
+	blockchain, _ := NewBlockChain(db, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+	chainEnv := NewChainEnv(gspec.Config, gspec, engine, blockchain, db)
+
+	// Mix independent transfers with transactions depending on each other
+	// through senders, recipients and the storage of a shared contract.
+	var (
+		signer  = types.NewEIP155Signer(gspec.Config.ChainId)
+		price   = big.NewInt(1)
+		counter = crypto.CreateAddress(addrs[0], 0)
+	)
+	chain, _ := chainEnv.GenerateChain(genesis, 8, func(i int, gen *BlockGen) {
+		if i == 0 {
+			tx, _ := types.SignTx(types.NewContractCreation(gen.TxNonce(addrs[0]), new(big.Int), big.NewInt(100000), price, counterCode), signer, keys[0])
+			gen.AddTx(tx)
+			return
+		}
+		for j := 0; j < 16; j++ {
+			from := rnd.Intn(len(keys))
+
+			var tx *types.Transaction
+			switch rnd.Intn(3) {
+			case 0:
+				to := common.BytesToAddress(crypto.Keccak256(big.NewInt(seed).Bytes(), []byte{byte(i), byte(j)}))
+				tx = types.NewTransaction(gen.TxNonce(addrs[from]), to, big.NewInt(1000), bigTxGas, price, nil)
+			case 1:
+				to := addrs[rnd.Intn(len(addrs))]
+				tx = types.NewTransaction(gen.TxNonce(addrs[from]), to, big.NewInt(1000), bigTxGas, price, nil)
+			case 2:
+				tx = types.NewTransaction(gen.TxNonce(addrs[from]), counter, new(big.Int), big.NewInt(100000), price, nil)
+			}
+			tx, _ = types.SignTx(tx, signer, keys[from])
+			gen.AddTx(tx)
+		}
+	})
+
+	sequential := NewStateProcessor(gspec.Config, blockchain, engine)
+	parallel := NewStateProcessor(gspec.Config, blockchain, engine)
+	parallel.SetWorkers(4)
+
+	parent := genesis
+	for _, block := range chain {
+		seqdb, _ := state.New(parent.Root(), state.NewDatabase(db))
+		pardb, _ := state.New(parent.Root(), state.NewDatabase(db))
+
+		seqReceipts, seqLogs, seqGas, err := sequential.Process(block, seqdb, vm.Config{})
+		if err != nil {
+			t.Fatalf("seed %d, block %d: sequential processing failed: %v", seed, block.NumberU64(), err)
+		}
+		parReceipts, parLogs, parGas, err := parallel.Process(block, pardb, vm.Config{})
+		if err != nil {
+			t.Fatalf("seed %d, block %d: parallel processing failed: %v", seed, block.NumberU64(), err)
+		}
+		if !reflect.DeepEqual(parReceipts, seqReceipts) {
+			t.Errorf("seed %d, block %d: receipts mismatch: have %v, want %v", seed, block.NumberU64(), parReceipts, seqReceipts)
+		}
+		if !reflect.DeepEqual(parLogs, seqLogs) {
+			t.Errorf("seed %d, block %d: logs mismatch: have %v, want %v", seed, block.NumberU64(), parLogs, seqLogs)
+		}
+		if parGas.Cmp(seqGas) != 0 {
+			t.Errorf("seed %d, block %d: gas used mismatch: have %v, want %v", seed, block.NumberU64(), parGas, seqGas)
+		}
+		if have, want := pardb.IntermediateRoot(true), seqdb.IntermediateRoot(true); have != want {
+			t.Errorf("seed %d, block %d: state root mismatch: have %x, want %x", seed, block.NumberU64(), have, want)
+		}
+		parent = block
+	}
+}
+
+// Tests that chains import blocks with parallel transaction execution by
+// default, validating to the same state as the sequentially built blocks.
+func TestParallelInsertChain(t *testing.T) {
+	c, err := newTestChain(big.NewInt(1000000000000))
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer c.stop()
+
+	if workers := c.blockchain.Processor().(*StateProcessor).workers; workers != runtime.NumCPU() {
+		t.Errorf("default workers mismatch: have %d, want %d", workers, runtime.NumCPU())
+	}
+	c.blockchain.SetProcessWorkers(4)
+
+	// Fund a few senders, then send from all of them in every block
+	keys := make([]*ecdsa.PrivateKey, 8)
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+	}
+	chain := c.generate(nil, 4, func(i int, gen *BlockGen) {
+		for j, key := range keys {
+			addr := crypto.PubkeyToAddress(key.PublicKey)
+			if i == 0 {
+				c.send(gen, types.NewTransaction(gen.TxNonce(c.addr), addr, big.NewInt(1000000000), bigTxGas, big.NewInt(1), nil))
+				continue
+			}
+			tx, _ := types.SignTx(types.NewTransaction(gen.TxNonce(addr), common.Address{byte(i), byte(j)}, big.NewInt(1000), bigTxGas, big.NewInt(1), nil), c.signer, key)
+			gen.AddTx(tx)
+		}
+	})
+	if _, err := c.blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain in parallel: %v", err)
+	}
+	if head := c.blockchain.CurrentBlock().Hash(); head != chain[len(chain)-1].Hash() {
+		t.Errorf("head mismatch: have %x, want %x", head, chain[len(chain)-1].Hash())
+	}
+}
+
+// Tests that the recorded state diffs contain exactly the changes made by the
+// transactions and the block rewards.
+func TestStateDiffs(t *testing.T) {
+	var (
+		key, _  = crypto.GenerateKey()
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		to      = common.Address{0xaa}
+		db, _   = ethdb.NewMemDatabase()
+		gspec   = DefaultPPOWTestingGenesisBlock()
+		funds   = big.NewInt(1000000000000)
+		counter = crypto.CreateAddress(addr, 0)
+	)
+	gspec.Alloc = GenesisAlloc{addr: {Balance: funds}}
+	genesis := gspec.MustCommit(db)
+	engine := ethash.NewFaker(db)
+	blockchain, _ := NewBlockChain(db, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+	chainEnv := NewChainEnv(gspec.Config, gspec, engine, blockchain, db)
+
+	signer := types.NewEIP155Signer(gspec.Config.ChainId)
+	chain, _ := chainEnv.GenerateChain(genesis, 2, func(i int, gen *BlockGen) {
+		var tx *types.Transaction
+		if i == 0 {
+			tx = types.NewContractCreation(gen.TxNonce(addr), new(big.Int), big.NewInt(100000), big.NewInt(1), counterCode)
+		} else {
+			tx = types.NewTransaction(gen.TxNonce(addr), to, big.NewInt(1000), bigTxGas, big.NewInt(1), nil)
+			tx, _ = types.SignTx(tx, signer, key)
+			gen.AddTx(tx)
+			tx = types.NewTransaction(gen.TxNonce(addr), counter, new(big.Int), big.NewInt(100000), big.NewInt(1), nil)
+		}
+		tx, _ = types.SignTx(tx, signer, key)
+		gen.AddTx(tx)
+	})
+
+	var diffs []*BlockDiff
+	processor := NewStateProcessor(gspec.Config, blockchain, engine)
+	processor.SetDiffHandler(func(diff *BlockDiff) { diffs = append(diffs, diff) })
+
+	parent := genesis
+	for _, block := range chain {
+		statedb, _ := state.New(parent.Root(), state.NewDatabase(db))
+		before := statedb.GetBalance(block.Coinbase())
+		if _, _, _, err := processor.Process(block, statedb, vm.Config{}); err != nil {
+			t.Fatalf("block %d: processing failed: %v", block.NumberU64(), err)
+		}
+		if root := statedb.IntermediateRoot(true); root != block.Root() {
+			t.Fatalf("block %d: state root mismatch: have %x, want %x", block.NumberU64(), root, block.Root())
+		}
+		diff := diffs[len(diffs)-1]
+		if diff.Hash != block.Hash() || len(diff.Transactions) != len(block.Transactions()) {
+			t.Fatalf("block %d: diff of wrong block: %x, %d transactions", block.NumberU64(), diff.Hash, len(diff.Transactions))
+		}
+		// The block diff must hold the final values of all changed accounts
+		for account, ad := range diff.Diff.Accounts {
+			if ad.Balance != nil && ad.Balance.To.Cmp(statedb.GetBalance(account)) != 0 {
+				t.Errorf("block %d, account %x: balance mismatch: have %v, want %v", block.NumberU64(), account, ad.Balance.To, statedb.GetBalance(account))
+			}
+		}
+		coinbase := diff.Diff.Accounts[block.Coinbase()]
+		if coinbase == nil || coinbase.Balance == nil || coinbase.Balance.From.Cmp(before) != 0 {
+			t.Errorf("block %d: coinbase reward missing from diff: %+v", block.NumberU64(), coinbase)
+		}
+		if diff.Finalize.Accounts[block.Coinbase()] == nil {
+			t.Errorf("block %d: coinbase reward missing from finalize diff", block.NumberU64())
+		}
+		parent = block
+	}
+	// Check the individual changes of the transfer and the counter call
+	txs := diffs[1].Transactions
+	if sender := txs[0].Accounts[addr]; sender == nil || sender.Nonce == nil || sender.Nonce.From != 1 || sender.Nonce.To != 2 {
+		t.Errorf("sender nonce change mismatch: %+v", sender)
+	}
+	if recipient := txs[0].Accounts[to]; recipient == nil || recipient.Existed || !recipient.Exists ||
+		recipient.Balance == nil || recipient.Balance.From.Sign() != 0 || recipient.Balance.To.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("recipient change mismatch: %+v", recipient)
+	}
+	slot := common.Hash{}
+	if contract := txs[1].Accounts[counter]; contract == nil ||
+		!reflect.DeepEqual(contract.Storage, map[common.Hash]state.HashDiff{slot: {From: common.Hash{}, To: common.BigToHash(big.NewInt(1))}}) {
+		t.Errorf("counter storage change mismatch: %+v", contract)
+	}
+	if _, ok := txs[1].Accounts[to]; ok {
+		t.Errorf("untouched account in diff")
+	}
+}
+
+// Tests that blocks can be verified against their recorded witnesses alone,
+// and that incomplete witnesses are rejected.
+func TestWitnesses(t *testing.T) {
+	var (
+		key, _  = crypto.GenerateKey()
+		addr    = crypto.PubkeyToAddress(key.PublicKey)
+		db, _   = ethdb.NewMemDatabase()
+		gspec   = DefaultPPOWTestingGenesisBlock()
+		counter = crypto.CreateAddress(addr, 0)
+	)
+	gspec.Alloc = GenesisAlloc{addr: {Balance: big.NewInt(1000000000000)}}
+	genesis := gspec.MustCommit(db)
+	engine := ethash.NewFaker(db)
+	blockchain, _ := NewBlockChain(db, gspec.Config, engine, vm.Config{})
+	defer blockchain.Stop()
+	chainEnv := NewChainEnv(gspec.Config, gspec, engine, blockchain, db)
+
+	signer := types.NewEIP155Signer(gspec.Config.ChainId)
+	chain, _ := chainEnv.GenerateChain(genesis, 3, func(i int, gen *BlockGen) {
+		var tx *types.Transaction
+		if i == 0 {
+			tx = types.NewContractCreation(gen.TxNonce(addr), new(big.Int), big.NewInt(100000), big.NewInt(1), counterCode)
+		} else {
+			tx = types.NewTransaction(gen.TxNonce(addr), common.Address{byte(i)}, big.NewInt(1000), bigTxGas, big.NewInt(1), nil)
+			tx, _ = types.SignTx(tx, signer, key)
+			gen.AddTx(tx)
+			tx = types.NewTransaction(gen.TxNonce(addr), counter, new(big.Int), big.NewInt(100000), big.NewInt(1), nil)
+		}
+		tx, _ = types.SignTx(tx, signer, key)
+		gen.AddTx(tx)
+	})
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	var witness *BlockWitness
+	processor := NewStateProcessor(gspec.Config, blockchain, engine)
+	processor.SetWitnessHandler(func(w *BlockWitness) { witness = w })
+
+	parent := genesis
+	for _, block := range chain {
+		statedb, _ := state.New(parent.Root(), state.NewDatabase(db))
+		if _, _, _, err := processor.Process(block, statedb, vm.Config{}); err != nil {
+			t.Fatalf("block %d: processing failed: %v", block.NumberU64(), err)
+		}
+		if witness == nil || witness.Hash != block.Hash() || len(witness.State.Nodes) == 0 {
+			t.Fatalf("block %d: witness missing", block.NumberU64())
+		}
+		if err := VerifyWitness(gspec.Config, engine, parent.Header(), block, witness); err != nil {
+			t.Errorf("block %d: valid witness rejected: %v", block.NumberU64(), err)
+		}
+		if block.NumberU64() > 1 && len(witness.State.Codes) != 1 {
+			t.Errorf("block %d: code count mismatch: have %d, want 1", block.NumberU64(), len(witness.State.Codes))
+		}
+		// The witness must not verify other blocks or without its nodes
+		if err := VerifyWitness(gspec.Config, engine, genesis.Header(), chain[len(chain)-1], witness); err != ErrWitnessMismatch {
+			t.Errorf("block %d: witness of another block accepted: %v", block.NumberU64(), err)
+		}
+		incomplete := *witness.State
+		incomplete.Nodes = incomplete.Nodes[1:]
+		if err := VerifyWitness(gspec.Config, engine, parent.Header(), block, &BlockWitness{Hash: witness.Hash, State: &incomplete}); err == nil {
+			t.Errorf("block %d: incomplete witness accepted", block.NumberU64())
+		}
+		parent = block
+	}
+}
@@ -0,0 +1,309 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/trie"
+	"github.com/combchain/go-combchain/types"
+)
+
+var (
+	errPruneUnsupported = errors.New("database does not support key iteration")
+	errPruneSyncing     = errors.New("cannot prune state during fast sync")
+
+	// pruneMarkPrefix + hash -> nothing, marks a live trie node or code while
+	// pruning. Leftovers of an interrupted run are dropped by the next one.
+	pruneMarkPrefix = []byte("prune-mark-")
+)
+
+// pruneMarkCache is the number of marks kept in memory before they are
+// flushed to the database, bounding the memory used for marking.
+const pruneMarkCache = 1 << 20
+
+// StatePruner garbage collects the trie nodes and contract code of historical
+// states. It keeps the states of all blocks, canonical or not, within the
+// last Retain block numbers, so that chain reorganisations inside the window
+// keep working. Older states are only kept for the canonical checkpoint
+// blocks every Checkpoint blocks, and for the genesis block.
+//
+// Pruning is an offline mark-and-sweep over the whole chain database, which
+// must not be in use by a running chain: every node reachable from a retained
+// state root is marked, every other content addressed entry, i.e. trie node
+// or code, is deleted. A pruned state is removed together with its root node,
+// so HasBlockAndState reports it as missing.
+type StatePruner struct {
+	db         ethdb.Database
+	Retain     uint64 // Number of recent block states to keep
+	Checkpoint uint64 // Interval of canonical states kept beyond the window, 0 for none
+}
+
+// NewStatePruner creates a pruner of the chain database db keeping the states
+// of the last retain blocks plus every checkpoint-th canonical block.
+func NewStatePruner(db ethdb.Database, retain, checkpoint uint64) *StatePruner {
+	return &StatePruner{
+		db:         db,
+		Retain:     retain,
+		Checkpoint: checkpoint,
+	}
+}
+
+// Prune deletes every state outside the retention window that is not a
+// checkpoint, returning the number of database entries removed. The chain
+// database must not be used by a running chain while pruning.
+func (p *StatePruner) Prune() (int, error) {
+	head := GetBlock(p.db, GetHeadBlockHash(p.db), GetBlockNumber(p.db, GetHeadBlockHash(p.db)))
+	if head == nil {
+		return 0, ErrUnknownBlock
+	}
+	// Nodes downloaded by an ongoing state sync are not reachable yet
+	if fast := GetBlockNumber(p.db, GetHeadFastBlockHash(p.db)); fast != missingNumber && fast > head.NumberU64() {
+		return 0, errPruneSyncing
+	}
+	start := time.Now()
+
+	if err := p.dropMarks(); err != nil {
+		return 0, err
+	}
+	roots, err := p.retained(head)
+	if err != nil {
+		return 0, err
+	}
+	marker := newPruneMarker(p.db)
+	for root := range roots {
+		if err := p.mark(root, marker); err != nil {
+			return 0, err
+		}
+	}
+	if err := marker.flush(); err != nil {
+		return 0, err
+	}
+	// Sweep the content addressed entries, other keys of the same length are
+	// no state
+	deleted := 0
+	err = forEachEntry(p.db, func(key, value []byte) error {
+		if len(key) != common.HashLength || crypto.Keccak256Hash(value) != common.BytesToHash(key) {
+			return nil
+		}
+		if marker.marked(common.BytesToHash(key)) {
+			return nil
+		}
+		deleted++
+		return p.db.Delete(common.CopyBytes(key))
+	})
+	if err != nil {
+		return deleted, err
+	}
+	if err := p.dropMarks(); err != nil {
+		return deleted, err
+	}
+	log.Info("Pruned historical state", "states", len(roots), "nodes", marker.count, "deleted", deleted, "elapsed", common.PrettyDuration(time.Since(start)))
+
+	return deleted, nil
+}
+
+// retained collects the state roots to keep with the given head block.
+func (p *StatePruner) retained(head *types.Block) (map[common.Hash]struct{}, error) {
+	number := head.NumberU64()
+	roots := map[common.Hash]struct{}{head.Root(): {}}
+	if genesis := GetHeader(p.db, GetCanonicalHash(p.db, 0), 0); genesis != nil {
+		roots[genesis.Root] = struct{}{}
+	}
+	// Blocks of all forks within the window, found through their header keys
+	err := forEachKey(p.db, func(key []byte) error {
+		if len(key) != len(headerPrefix)+8+common.HashLength || key[0] != headerPrefix[0] {
+			return nil
+		}
+		n := binary.BigEndian.Uint64(key[len(headerPrefix):])
+		if n+p.Retain <= number {
+			return nil
+		}
+		if header := GetHeader(p.db, common.BytesToHash(key[len(headerPrefix)+8:]), n); header != nil {
+			roots[header.Root] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Canonical checkpoints beyond the window
+	if p.Checkpoint > 0 {
+		for n := uint64(0); n <= number; n += p.Checkpoint {
+			if header := GetHeader(p.db, GetCanonicalHash(p.db, n), n); header != nil {
+				roots[header.Root] = struct{}{}
+			}
+		}
+	}
+	return roots, nil
+}
+
+// mark marks every trie node and contract code of the state with the given
+// root. States without a root node, like the ones of fast synced blocks, are
+// skipped.
+func (p *StatePruner) mark(root common.Hash, marker *pruneMarker) error {
+	return markTrie(p.db, root, marker, func(leaf []byte) error {
+		var account state.Account
+		if err := rlp.DecodeBytes(leaf, &account); err != nil {
+			return err
+		}
+		if err := marker.mark(common.BytesToHash(account.CodeHash)); err != nil {
+			return err
+		}
+		return markTrie(p.db, account.Root, marker, nil)
+	})
+}
+
+// dropMarks deletes the marks of the database.
+func (p *StatePruner) dropMarks() error {
+	var keys [][]byte
+	err := forEachKey(p.db, func(key []byte) error {
+		if bytes.HasPrefix(key, pruneMarkPrefix) {
+			keys = append(keys, common.CopyBytes(key))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := p.db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneMarker is the set of marked hashes, cached in memory up to
+// pruneMarkCache entries and persisted in the database beyond.
+type pruneMarker struct {
+	db    ethdb.Database
+	batch ethdb.Batch
+	cache map[common.Hash]struct{}
+	count int // Number of hashes marked
+}
+
+func newPruneMarker(db ethdb.Database) *pruneMarker {
+	return &pruneMarker{
+		db:    db,
+		batch: db.NewBatch(),
+		cache: make(map[common.Hash]struct{}),
+	}
+}
+
+// marked reports whether the hash has been marked.
+func (m *pruneMarker) marked(hash common.Hash) bool {
+	if _, ok := m.cache[hash]; ok {
+		return true
+	}
+	ok, _ := m.db.Has(append(append([]byte{}, pruneMarkPrefix...), hash[:]...))
+	return ok
+}
+
+// mark adds the hash to the set, flushing the cache once full.
+func (m *pruneMarker) mark(hash common.Hash) error {
+	if m.marked(hash) {
+		return nil
+	}
+	m.cache[hash] = struct{}{}
+	m.count++
+	if err := m.batch.Put(append(append([]byte{}, pruneMarkPrefix...), hash[:]...), nil); err != nil {
+		return err
+	}
+	if len(m.cache) >= pruneMarkCache {
+		return m.flush()
+	}
+	return nil
+}
+
+// flush persists the cached marks and empties the cache.
+func (m *pruneMarker) flush() error {
+	if err := m.batch.Write(); err != nil {
+		return err
+	}
+	m.batch = m.db.NewBatch()
+	m.cache = make(map[common.Hash]struct{})
+	return nil
+}
+
+// markTrie marks the nodes of the trie with the given root, calling onLeaf
+// for the value of every leaf. Subtries already marked are skipped, as their
+// content has been marked before.
+func markTrie(db ethdb.Database, root common.Hash, marker *pruneMarker, onLeaf func([]byte) error) error {
+	if marker.marked(root) {
+		return nil
+	}
+	if ok, _ := db.Has(root[:]); !ok {
+		return nil
+	}
+	tr, err := trie.New(root, db)
+	if err != nil {
+		return err
+	}
+	it := tr.NodeIterator(nil)
+	for descend := true; it.Next(descend); {
+		descend = true
+		if hash := it.Hash(); hash != (common.Hash{}) {
+			if marker.marked(hash) {
+				descend = false
+				continue
+			}
+			if err := marker.mark(hash); err != nil {
+				return err
+			}
+		}
+		if it.Leaf() && onLeaf != nil {
+			if err := onLeaf(it.LeafBlob()); err != nil {
+				return err
+			}
+		}
+	}
+	return it.Error()
+}
+
+// forEachKey calls fn with every key of the database. The key must not be
+// retained after fn returns.
+func forEachKey(db ethdb.Database, fn func(key []byte) error) error {
+	return forEachEntry(db, func(key, value []byte) error {
+		return fn(key)
+	})
+}
+
+// forEachEntry calls fn with every entry of the database. The key and value
+// must not be retained after fn returns.
+func forEachEntry(db ethdb.Database, fn func(key, value []byte) error) error {
+	if kv, ok := db.(*FreezerDB); ok {
+		db = kv.KeyValueStore()
+	}
+	switch db := db.(type) {
+	case *ethdb.LDBDatabase:
+		it := db.NewIterator()
+		defer it.Release()
+
+		for it.Next() {
+			if err := fn(it.Key(), it.Value()); err != nil {
+				return err
+			}
+		}
+		return it.Error()
+
+	case *ethdb.MemDatabase:
+		for _, key := range db.Keys() {
+			value, _ := db.Get(key)
+			if err := fn(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errPruneUnsupported
+}
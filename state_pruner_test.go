@@ -0,0 +1,69 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/consensus/ethash"
+	"github.com/combchain/go-combchain/params"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+// Tests that pruning keeps exactly the states of the retention window and the
+// checkpoints, and that reorgs within the window keep working afterwards.
+func TestStatePruning(t *testing.T) {
+	db, blockchain, err, chainEnv := newCanonical(32, true)
+	if err != nil {
+		t.Fatalf("failed to create pristine chain: %v", err)
+	}
+	// Create forks from inside and outside of the window before pruning
+	inside, _ := chainEnv.GenerateChain(blockchain.GetBlockByNumber(28), 6, func(i int, b *BlockGen) { b.OffsetTime(1) })
+	outside, _ := chainEnv.GenerateChain(blockchain.GetBlockByNumber(15), 20, func(i int, b *BlockGen) { b.OffsetTime(1) })
+
+	// Unrelated entries keyed like trie nodes must survive
+	foreign := common.Hash{0x01}
+	db.Put(foreign[:], []byte("not a trie node"))
+
+	blockchain.Stop()
+	pruner := NewStatePruner(db, 8, 10)
+	if _, err := pruner.Prune(); err != nil {
+		t.Fatalf("failed to prune state: %v", err)
+	}
+	if has, _ := db.Has(foreign[:]); !has {
+		t.Errorf("entry not holding state deleted")
+	}
+	blockchain, _ = NewBlockChain(db, params.TestChainConfig, ethash.NewFaker(db), vm.Config{})
+	defer blockchain.Stop()
+
+	for number := uint64(0); number <= 32; number++ {
+		block := blockchain.GetBlockByNumber(number)
+		want := number > 24 || number%10 == 0
+		if have := blockchain.HasBlockAndState(block.Hash()); have != want {
+			t.Errorf("block %d: state presence mismatch: have %v, want %v", number, have, want)
+		}
+	}
+	// The retained states must be complete
+	statedb, err := blockchain.State()
+	if err != nil {
+		t.Fatalf("failed to open head state: %v", err)
+	}
+	it := state.NewNodeIterator(statedb)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		t.Fatalf("head state incomplete after pruning: %v", it.Error)
+	}
+	// Reorgs onto a fork within the window must succeed, older forks are gone
+	if _, err := blockchain.InsertChain(inside); err != nil {
+		t.Fatalf("failed to reorg within the retention window: %v", err)
+	}
+	if head := blockchain.CurrentBlock().Hash(); head != inside[len(inside)-1].Hash() {
+		t.Errorf("head mismatch after reorg: have %x, want %x", head, inside[len(inside)-1].Hash())
+	}
+	if _, err := blockchain.InsertChain(outside); err == nil {
+		t.Errorf("imported fork of pruned state")
+	}
+}
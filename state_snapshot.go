@@ -0,0 +1,64 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"github.com/combchain/combchain/log"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/state"
+)
+
+// snapshotLayers is the number of recent block states kept as in-memory diff
+// layers on top of the persisted snapshot. Reorgs deeper than this make the
+// snapshot rebuild.
+const snapshotLayers = 128
+
+// EnableSnapshots makes the chain maintain a flat snapshot of the recent
+// states, which serves account and storage reads without walking the tries.
+// The snapshot of the head state is loaded from the database, or rebuilt from
+// the state trie if missing.
+func (bc *BlockChain) EnableSnapshots() error {
+	bc.chainmu.Lock()
+	defer bc.chainmu.Unlock()
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if bc.snaps != nil {
+		return nil
+	}
+	snaps, err := state.NewSnapshots(bc.chainDb, bc.currentBlock.Root())
+	if err != nil {
+		return err
+	}
+	bc.snaps = snaps
+	bc.stateCache.Store(state.NewDatabaseWithConfig(bc.chainDb, bc.cacheConfig, snaps))
+	return nil
+}
+
+// Snapshots returns the state snapshots of the chain, nil if not enabled.
+func (bc *BlockChain) Snapshots() *state.Snapshots {
+	bc.mu.RLock()
+	defer bc.mu.RUnlock()
+
+	return bc.snaps
+}
+
+// capSnapshots flattens the snapshot layers more than keep blocks below the
+// state at root into the persisted snapshot. If the state has no snapshot,
+// e.g. after a deep reorg, the snapshot is regenerated from its trie in the
+// background, the state being read from the tries meanwhile. It assumes the
+// chain mutex is held.
+func (bc *BlockChain) capSnapshots(root common.Hash, keep int) {
+	if bc.snaps == nil {
+		return
+	}
+	err := bc.snaps.Cap(root, keep)
+	if err == state.ErrSnapshotMissing {
+		log.Warn("State snapshot missing, regenerating", "root", root)
+		bc.snaps.Rebuild(root)
+		return
+	}
+	if err != nil {
+		log.Error("Failed to update state snapshot", "root", root, "err", err)
+	}
+}
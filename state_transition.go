@@ -83,6 +83,9 @@ type Message interface {
 	Data() []byte
 
 	TxType() uint64
+	ValidUntil() uint64
+	Sponsor() *common.Address
+	MultiSig() *types.MultiSigPolicy
 }
 
 // IntrinsicGas computes the 'intrinsic gas' for a message
@@ -149,6 +152,19 @@ func (st *StateTransition) from() vm.AccountRef {
 	return vm.AccountRef(f)
 }
 
+// payer returns the account paying for gas, which is the sponsor of a
+// sponsored message and the sender otherwise.
+func (st *StateTransition) payer() vm.AccountRef {
+	sponsor := st.msg.Sponsor()
+	if sponsor == nil {
+		return st.from()
+	}
+	if !st.state.Exist(*sponsor) {
+		st.state.CreateAccount(*sponsor)
+	}
+	return vm.AccountRef(*sponsor)
+}
+
 func (st *StateTransition) to() vm.AccountRef {
 	if st.msg == nil {
 		return vm.AccountRef{}
@@ -183,11 +199,11 @@ func (st *StateTransition) buyGas() error {
 	mgval := new(big.Int).Mul(mgas, st.gasPrice)
 
 	var (
-		state  = st.state
-		sender = st.from()
+		state = st.state
+		payer = st.payer()
 	)
 
-	if state.GetBalance(sender.Address()).Cmp(mgval) < 0 {
+	if state.GetBalance(payer.Address()).Cmp(mgval) < 0 {
 		return errInsufficientBalanceForGas
 	}
 
@@ -197,7 +213,7 @@ func (st *StateTransition) buyGas() error {
 	st.gas += mgas.Uint64()
 
 	st.initialGas.Set(mgas)
-	state.SubBalance(sender.Address(), mgval)
+	state.SubBalance(payer.Address(), mgval)
 	return nil
 }
 
@@ -214,6 +230,13 @@ func (st *StateTransition) preCheck() error {
 			return ErrNonceTooLow
 		}
 	}
+	// Record the policy of multi-signature senders on first use, before the
+	// account pays for anything
+	if policy := msg.MultiSig(); policy != nil {
+		if err := vm.RegisterMultiSigPolicy(st.state, policy); err != nil {
+			return err
+		}
+	}
 	return st.buyGas()
 }
 
@@ -221,7 +244,15 @@ func (st *StateTransition) preCheck() error {
 // including the required gas for the operation as well as the used gas. It returns an error if it
 // failed. An error indicates a consensus issue.
 func (st *StateTransition) TransitionDb() (ret []byte, requiredGas, usedGas *big.Int, failed bool, err error) {
-
+	// Make sure this transaction hasn't outlived its validity window, whatever its type
+	if until := st.msg.ValidUntil(); until != 0 && st.evm.BlockNumber.Cmp(new(big.Int).SetUint64(until)) > 0 {
+		return nil, nil, nil, false, ErrTxExpired
+	}
+	// Privacy transactions pay for gas with their stamps and are signed by a
+	// single key, a sponsor or multi-signature policy doesn't apply
+	if !types.IsNormalTransaction(st.msg.TxType()) && (st.msg.Sponsor() != nil || st.msg.MultiSig() != nil) {
+		return nil, nil, nil, false, ErrPrivacyTxEnvelope
+	}
 	if types.IsNormalTransaction(st.msg.TxType()) {
 		if err = st.preCheck(); err != nil {
 			return
@@ -313,18 +344,18 @@ func (st *StateTransition) TransitionDb() (ret []byte, requiredGas, usedGas *big
 }
 
 func (st *StateTransition) refundGas() {
-	// Return eth for remaining gas to the sender account,
+	// Return eth for remaining gas to the account that bought it,
 	// exchanged at the original rate.
-	sender := st.from() // err already checked
+	payer := st.payer()
 	remaining := new(big.Int).Mul(new(big.Int).SetUint64(st.gas), st.gasPrice)
-	st.state.AddBalance(sender.Address(), remaining)
+	st.state.AddBalance(payer.Address(), remaining)
 
 	// Apply refund counter, capped to half of the used gas.
 	uhalf := remaining.Div(st.gasUsed(), common.Big2)
 	refund := math.BigMin(uhalf, st.state.GetRefund())
 	st.gas += refund.Uint64()
 
-	st.state.AddBalance(sender.Address(), refund.Mul(refund, st.gasPrice))
+	st.state.AddBalance(payer.Address(), refund.Mul(refund, st.gasPrice))
 
 	// Also return remaining gas to the block gas counter so it is
 	// available for the next transaction.
@@ -361,7 +392,7 @@ type PrivacyTxInfo struct {
 	GasLeftSubRingSign uint64
 }
 
-func FetchPrivacyTxInfo(stateDB vm.StateDB, hashInput []byte, in []byte, gasPrice *big.Int) (info *PrivacyTxInfo, err error) {
+func FetchPrivacyTxInfo(stateDB vm.StateReader, hashInput []byte, in []byte, gasPrice *big.Int) (info *PrivacyTxInfo, err error) {
 	if len(in) < 4 {
 		return nil, vm.ErrInvalidRingSigned
 	}
@@ -411,7 +442,7 @@ func FetchPrivacyTxInfo(stateDB vm.StateDB, hashInput []byte, in []byte, gasPric
 	return
 }
 
-func ValidPrivacyTx(stateDB vm.StateDB, hashInput []byte, in []byte, gasPrice *big.Int,
+func ValidPrivacyTx(stateDB vm.StateReader, hashInput []byte, in []byte, gasPrice *big.Int,
 	intrGas *big.Int, txValue *big.Int, gasLimit *big.Int) error {
 	if intrGas == nil || intrGas.BitLen() > 64 {
 		return vm.ErrOutOfGas
@@ -0,0 +1,64 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/params"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+// privacyMessage is a privacy transaction message valid until a given block,
+// optionally sent from a multi-signature account.
+type privacyMessage struct {
+	types.Message
+	until  uint64
+	policy *types.MultiSigPolicy
+}
+
+func (m privacyMessage) TxType() uint64                  { return types.PRIVACY_TX }
+func (m privacyMessage) ValidUntil() uint64              { return m.until }
+func (m privacyMessage) MultiSig() *types.MultiSigPolicy { return m.policy }
+
+// Tests that the validity window is enforced on privacy transactions too.
+func TestPrivacyTxExpiry(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	to := common.Address{0x01}
+	msg := types.NewMessage(common.Address{0x02}, &to, 0, new(big.Int), big.NewInt(100000), new(big.Int), nil, true)
+	context := vm.Context{CanTransfer: CanTransfer, Transfer: Transfer, BlockNumber: big.NewInt(10)}
+
+	for _, test := range []struct {
+		until   uint64
+		expired bool
+	}{{9, true}, {10, false}, {0, false}} {
+		evm := vm.NewEVM(context, statedb.Copy(), params.TestChainConfig, vm.Config{})
+		_, _, _, err := ApplyMessage(evm, privacyMessage{msg, test.until, nil}, new(GasPool).AddGas(big.NewInt(1000000)))
+		if expired := err == ErrTxExpired; expired != test.expired {
+			t.Errorf("valid until %d: expiry mismatch: have %v (%v), want %v", test.until, expired, err, test.expired)
+		}
+	}
+}
+
+// Tests that privacy transactions from multi-signature accounts are rejected.
+func TestPrivacyTxMultiSig(t *testing.T) {
+	db, _ := ethdb.NewMemDatabase()
+	statedb, _ := state.New(common.Hash{}, state.NewDatabase(db))
+
+	to := common.Address{0x01}
+	policy := &types.MultiSigPolicy{Threshold: 1, Owners: []common.Address{{0x03}}}
+	msg := types.NewMessage(policy.Address(), &to, 0, new(big.Int), big.NewInt(100000), new(big.Int), nil, true)
+	context := vm.Context{CanTransfer: CanTransfer, Transfer: Transfer, BlockNumber: big.NewInt(10)}
+
+	evm := vm.NewEVM(context, statedb, params.TestChainConfig, vm.Config{})
+	if _, _, _, err := ApplyMessage(evm, privacyMessage{msg, 0, policy}, new(GasPool).AddGas(big.NewInt(1000000))); err != ErrPrivacyTxEnvelope {
+		t.Errorf("multi-signature privacy transaction error mismatch: have %v, want %v", err, ErrPrivacyTxEnvelope)
+	}
+}
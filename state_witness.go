@@ -0,0 +1,143 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/consensus"
+	"github.com/combchain/go-combchain/params"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+	"github.com/combchain/go-combchain/vm/evm"
+)
+
+var (
+	// ErrWitnessMismatch is returned if a witness was recorded for another
+	// block or parent state than the one it is verified with.
+	ErrWitnessMismatch = errors.New("witness doesn't match block")
+)
+
+// BlockWitness is the data needed to execute a block without the state of
+// its parent: the state read by the block and the ancestor headers read by
+// BLOCKHASH.
+type BlockWitness struct {
+	Hash    common.Hash
+	Number  uint64
+	State   *state.Witness
+	Headers []*types.Header
+}
+
+// VerifyWitness executes the block on its witness and checks the results
+// against the block header, including the post-state root. The parent header
+// must have been verified, the witness is checked against its state root.
+func VerifyWitness(config *params.ChainConfig, engine consensus.Engine, parent *types.Header, block *types.Block, witness *BlockWitness) error {
+	if witness.Hash != block.Hash() || block.ParentHash() != parent.Hash() {
+		return ErrWitnessMismatch
+	}
+	if witness.State == nil || witness.State.Root != parent.Root {
+		return ErrWitnessMismatch
+	}
+	statedb, err := state.New(parent.Root, state.NewWitnessDatabase(witness.State))
+	if err != nil {
+		return fmt.Errorf("incomplete witness: %v", err)
+	}
+	chain := &witnessChain{
+		config:  config,
+		engine:  engine,
+		current: parent,
+		headers: map[common.Hash]*types.Header{parent.Hash(): parent},
+	}
+	for _, header := range witness.Headers {
+		chain.headers[header.Hash()] = header
+	}
+	processor := &StateProcessor{config: config, bc: chain, engine: engine}
+	receipts, _, usedGas, err := processor.Process(block, statedb, vm.Config{})
+	if err != nil {
+		return err
+	}
+	if err := statedb.Error(); err != nil {
+		return fmt.Errorf("incomplete witness: %v", err)
+	}
+	validator := &BlockValidator{config: config, engine: engine}
+	return validator.ValidateState(block, nil, statedb, receipts, usedGas)
+}
+
+// headerRecorder records the headers read from the chain while processing a
+// block.
+type headerRecorder struct {
+	processorChain
+	headers map[common.Hash]*types.Header
+}
+
+func (r *headerRecorder) record(header *types.Header) *types.Header {
+	if header != nil {
+		r.headers[header.Hash()] = header
+	}
+	return header
+}
+
+func (r *headerRecorder) GetHeader(hash common.Hash, number uint64) *types.Header {
+	return r.record(r.processorChain.GetHeader(hash, number))
+}
+
+func (r *headerRecorder) GetHeaderByHash(hash common.Hash) *types.Header {
+	return r.record(r.processorChain.GetHeaderByHash(hash))
+}
+
+func (r *headerRecorder) GetHeaderByNumber(number uint64) *types.Header {
+	return r.record(r.processorChain.GetHeaderByNumber(number))
+}
+
+// list returns the recorded headers, newest first.
+func (r *headerRecorder) list() []*types.Header {
+	headers := make([]*types.Header, 0, len(r.headers))
+	for _, header := range r.headers {
+		headers = append(headers, header)
+	}
+	sort.Slice(headers, func(i, j int) bool { return headers[i].Number.Cmp(headers[j].Number) > 0 })
+	return headers
+}
+
+// witnessChain serves the headers of a witness to the block processing.
+// Headers are only found through their hashes, so unrelated headers in a
+// witness are never used.
+type witnessChain struct {
+	config  *params.ChainConfig
+	engine  consensus.Engine
+	current *types.Header // Parent of the verified block
+	headers map[common.Hash]*types.Header
+}
+
+func (c *witnessChain) Config() *params.ChainConfig               { return c.config }
+func (c *witnessChain) Engine() consensus.Engine                  { return c.engine }
+func (c *witnessChain) CurrentHeader() *types.Header              { return c.current }
+func (c *witnessChain) GetBlock(common.Hash, uint64) *types.Block { return nil }
+
+func (c *witnessChain) GetHeader(hash common.Hash, number uint64) *types.Header {
+	if header := c.headers[hash]; header != nil && header.Number.Uint64() == number {
+		return header
+	}
+	return nil
+}
+
+func (c *witnessChain) GetHeaderByHash(hash common.Hash) *types.Header {
+	return c.headers[hash]
+}
+
+// GetHeaderByNumber walks back from the parent, so only ancestors of the
+// verified block are returned.
+func (c *witnessChain) GetHeaderByNumber(number uint64) *types.Header {
+	for header := c.current; header != nil; header = c.headers[header.ParentHash] {
+		if header.Number.Uint64() == number {
+			return header
+		}
+		if header.Number.Uint64() < number {
+			break
+		}
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/combchain/combchain/log"
+)
+
+// txIndexBatch is the number of blocks (un)indexed between index tail updates.
+const txIndexBatch = 1000
+
+// TxIndexProgress is the state of the transaction lookup index.
+type TxIndexProgress struct {
+	Tail      uint64 // Oldest block whose transactions are indexed
+	Indexed   uint64 // Blocks whose transactions are indexed
+	Remaining uint64 // Blocks left to index or unindex to reach the limit
+}
+
+// SetTxLookupLimit sets the number of recent blocks whose transactions are
+// indexed for lookups by hash, 0 for all. The index is extended or trimmed
+// in the background.
+func (bc *BlockChain) SetTxLookupLimit(limit uint64) {
+	atomic.StoreUint64(&bc.txLookupLimit, limit)
+	select {
+	case bc.txIndexKick <- struct{}{}:
+	default:
+	}
+}
+
+// TxIndexProgress returns the progress of the transaction lookup index
+// towards the configured limit.
+func (bc *BlockChain) TxIndexProgress() TxIndexProgress {
+	head := bc.CurrentBlock().NumberU64()
+	tail := GetTxIndexTail(bc.chainDb)
+	want := txIndexTarget(head, atomic.LoadUint64(&bc.txLookupLimit))
+
+	progress := TxIndexProgress{Tail: tail}
+	if tail <= head {
+		progress.Indexed = head - tail + 1
+	}
+	if tail < want {
+		progress.Remaining = want - tail
+	} else {
+		progress.Remaining = tail - want
+	}
+	return progress
+}
+
+// ReindexTransactions rebuilds the lookup entries of the transactions of the
+// canonical blocks in the range [from, to]. The range must overlap or adjoin
+// the indexed blocks, which it extends. Blocks older than the limit are
+// unindexed again by the background indexer.
+func (bc *BlockChain) ReindexTransactions(from, to uint64) error {
+	if from > to {
+		return fmt.Errorf("reindex failed: first (%d) is greater than last (%d)", from, to)
+	}
+	bc.txIndexLock.Lock()
+	defer bc.txIndexLock.Unlock()
+
+	// Entries below the tail are only removed from the tail on, the indexer
+	// would never drop a detached range
+	tail := GetTxIndexTail(bc.chainDb)
+	if to+1 < tail {
+		return fmt.Errorf("reindex failed: last (%d) is detached from the index tail (%d)", to, tail)
+	}
+	batch := bc.chainDb.NewBatch()
+	for number := from; number <= to; number++ {
+		block := bc.GetBlockByNumber(number)
+		if block == nil {
+			return fmt.Errorf("reindex failed on #%d: not found", number)
+		}
+		if err := WriteTxLookupEntries(batch, block); err != nil {
+			return err
+		}
+	}
+	if from < tail {
+		WriteTxIndexTail(batch, from)
+	}
+	log.Info("Reindexed transactions", "from", from, "to", to)
+	return batch.Write()
+}
+
+// txIndexTarget returns the oldest block to index with the given head.
+func txIndexTarget(head, limit uint64) uint64 {
+	if limit == 0 || head < limit {
+		return 0
+	}
+	return head - limit + 1
+}
+
+// maintainTxIndex moves the transaction index tail along with the chain head
+// until the chain is stopped. Only one (un)indexing runs at a time, heads
+// arriving meanwhile are picked up by the next run.
+func (bc *BlockChain) maintainTxIndex() {
+	defer bc.wg.Done()
+
+	heads := make(chan ChainHeadEvent, 10)
+	sub := bc.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	var (
+		done    chan struct{} // Closed when the running indexing finishes, nil if none
+		pending bool          // Whether to run again after the running indexing
+		stop    = make(chan struct{})
+	)
+	run := func() {
+		done = make(chan struct{})
+		go func(done chan struct{}) {
+			defer close(done)
+			bc.indexTransactions(bc.CurrentBlock().NumberU64(), stop)
+		}(done)
+	}
+	run()
+	for {
+		select {
+		case <-heads:
+			pending = true
+		case <-bc.txIndexKick:
+			pending = true
+		case <-done:
+			done = nil
+		case <-bc.quit:
+			close(stop)
+			if done != nil {
+				<-done
+			}
+			return
+		}
+		if done == nil && pending {
+			pending = false
+			run()
+		}
+	}
+}
+
+// indexTransactions indexes or unindexes the transactions of old blocks
+// until the index tail matches the limit at the given head, or interrupted.
+func (bc *BlockChain) indexTransactions(head uint64, interrupt <-chan struct{}) {
+	want := txIndexTarget(head, atomic.LoadUint64(&bc.txLookupLimit))
+	for {
+		select {
+		case <-interrupt:
+			return
+		default:
+		}
+		bc.txIndexLock.Lock()
+		tail := GetTxIndexTail(bc.chainDb)
+		var err error
+		switch {
+		case tail < want:
+			err = bc.unindexBlocks(tail, want)
+		case tail > want:
+			err = bc.indexBlocks(want, tail)
+		}
+		bc.txIndexLock.Unlock()
+
+		if err != nil {
+			log.Error("Failed to update transaction index", "tail", tail, "target", want, "err", err)
+			return
+		}
+		if tail == want {
+			return
+		}
+	}
+}
+
+// indexBlocks indexes a batch of the blocks below the tail, down to first,
+// and moves the tail to the oldest one indexed.
+func (bc *BlockChain) indexBlocks(first, tail uint64) error {
+	if tail-first > txIndexBatch {
+		first = tail - txIndexBatch
+	}
+	batch := bc.chainDb.NewBatch()
+	for number := tail; number > first; number-- {
+		// Blocks above a rewound head are gone, there's nothing to index
+		if block := bc.GetBlockByNumber(number - 1); block != nil {
+			if err := WriteTxLookupEntries(batch, block); err != nil {
+				return err
+			}
+		}
+	}
+	WriteTxIndexTail(batch, first)
+	log.Debug("Indexed transactions", "from", first, "to", tail-1)
+	return batch.Write()
+}
+
+// unindexBlocks drops the lookup entries of a batch of the blocks from the
+// tail on, below last, and moves the tail past them.
+func (bc *BlockChain) unindexBlocks(tail, last uint64) error {
+	if last-tail > txIndexBatch {
+		last = tail + txIndexBatch
+	}
+	for number := tail; number < last; number++ {
+		if block := bc.GetBlockByNumber(number); block != nil {
+			DeleteTxLookupEntries(bc.chainDb, block)
+		}
+	}
+	log.Debug("Unindexed transactions", "from", tail, "to", last-1)
+	return WriteTxIndexTail(bc.chainDb, last)
+}
@@ -0,0 +1,76 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package core
+
+import (
+	"math/big"
+	"testing"
+	"time"
+)
+
+// Tests that the transaction index follows the configured limit in the
+// background and that ranges can be reindexed.
+func TestTxIndexLimit(t *testing.T) {
+	c, err := newTestChain(big.NewInt(1000000000000))
+	if err != nil {
+		t.Fatalf("failed to create chain: %v", err)
+	}
+	defer c.stop()
+
+	db, blockchain := c.db, c.blockchain
+	chain := c.generate(nil, 16, nil)
+	if _, err := blockchain.InsertChain(chain); err != nil {
+		t.Fatalf("failed to insert chain: %v", err)
+	}
+	// checkIndex waits for the index to reach the limit and checks which
+	// blocks have their transactions indexed.
+	checkIndex := func(tail uint64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for blockchain.TxIndexProgress().Remaining != 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("index not updated in time: %+v", blockchain.TxIndexProgress())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if progress := blockchain.TxIndexProgress(); progress.Tail != tail || progress.Indexed != 17-tail {
+			t.Errorf("index progress mismatch: have %+v, want tail %d", progress, tail)
+		}
+		for _, block := range chain {
+			tx, _, _, _ := GetTransaction(db, block.Transactions()[0].Hash())
+			if indexed := block.NumberU64() >= tail; (tx != nil) != indexed {
+				t.Errorf("block %d: lookup mismatch: have %v, want %v", block.NumberU64(), tx != nil, indexed)
+			}
+		}
+	}
+	checkIndex(0)
+
+	blockchain.SetTxLookupLimit(4)
+	checkIndex(13)
+
+	// Ranges detached from the index can't be tracked, so aren't reindexed
+	if err := blockchain.ReindexTransactions(2, 3); err == nil {
+		t.Errorf("detached range reindexed")
+	}
+	if tx, _, _, _ := GetTransaction(db, chain[1].Transactions()[0].Hash()); tx != nil {
+		t.Errorf("detached range indexed")
+	}
+	// Adjacent ones extend it, until trimmed back to the limit
+	if err := blockchain.ReindexTransactions(10, 12); err != nil {
+		t.Fatalf("failed to reindex adjacent range: %v", err)
+	}
+	if tail := GetTxIndexTail(db); tail != 10 {
+		t.Errorf("index tail mismatch: have %d, want 10", tail)
+	}
+	blockchain.SetTxLookupLimit(4)
+	checkIndex(13)
+
+	blockchain.SetTxLookupLimit(0)
+	checkIndex(0)
+
+	// Rebuild lost entries
+	DeleteTxLookupEntries(db, chain[4])
+	if err := blockchain.ReindexTransactions(5, 5); err != nil {
+		t.Fatalf("failed to reindex: %v", err)
+	}
+	checkIndex(0)
+}
@@ -262,7 +262,7 @@ func (l *txList) Add(tx *types.Transaction, priceBump uint64) (bool, *types.Tran
 	}
 	// Otherwise overwrite the old transaction with the current one
 	l.txs.Put(tx)
-	if cost := tx.Cost(); l.costcap.Cmp(cost) < 0 {
+	if cost := tx.SenderCost(); l.costcap.Cmp(cost) < 0 {
 		l.costcap = cost
 	}
 	if gas := tx.Gas(); l.gascap.Cmp(gas) < 0 {
@@ -297,7 +297,7 @@ func (l *txList) Filter(costLimit, gasLimit *big.Int) (types.Transactions, types
 
 	// Filter out all the transactions above the account's funds
 	removed := l.txs.Filter(func(tx *types.Transaction) bool {
-		return types.IsNormalTransaction(tx.Txtype()) && (tx.Cost().Cmp(costLimit) > 0 || tx.Gas().Cmp(gasLimit) > 0)
+		return types.IsNormalTransaction(tx.Txtype()) && (tx.SenderCost().Cmp(costLimit) > 0 || tx.Gas().Cmp(gasLimit) > 0)
 	})
 
 	// If the list was strict, filter anything above the lowest nonce
@@ -316,7 +316,7 @@ func (l *txList) Filter(costLimit, gasLimit *big.Int) (types.Transactions, types
 }
 
 // InvalidPrivacyTx remove invalidate privacy transactions
-func (l *txList) InvalidPrivacyTx(stateDB vm.StateDB, signer types.Signer, gasLimit *big.Int) types.Transactions {
+func (l *txList) InvalidPrivacyTx(stateDB vm.StateReader, signer types.Signer, gasLimit *big.Int) types.Transactions {
 	removed := l.txs.Filter(func(tx *types.Transaction) bool {
 		if types.IsNormalTransaction(tx.Txtype()) {
 			return false
@@ -18,6 +18,7 @@
 package core
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"math"
@@ -64,6 +65,10 @@ var (
 	// is higher than the balance of the user's account.
 	ErrInsufficientFunds = errors.New("insufficient funds for gas * price + value")
 
+	// ErrInsufficientSponsorFunds is returned if the sponsor of a transaction
+	// can't pay for its gas on top of the other pooled transactions it sponsors.
+	ErrInsufficientSponsorFunds = errors.New("insufficient sponsor funds for gas * price")
+
 	// ErrIntrinsicGas is returned if the transaction is specified to use less gas
 	// than required to start the invocation.
 	ErrIntrinsicGas = errors.New("intrinsic gas too low")
@@ -106,6 +111,8 @@ var (
 	// General tx metrics
 	invalidTxCounter     = metrics.NewCounter("txpool/invalid")
 	underpricedTxCounter = metrics.NewCounter("txpool/underpriced")
+	expiredTxCounter     = metrics.NewCounter("txpool/expired")
+	unsponsoredCounter   = metrics.NewCounter("txpool/unsponsored") // Dropped due to sponsor out-of-funds
 )
 
 // blockChain provides the state of blockchain and current gas limit to do
@@ -191,8 +198,10 @@ type TxPool struct {
 	mu           sync.RWMutex
 
 	currentState  *state.StateDB      // Current state in the blockchain head
+	currentReader vm.StateReader      // Read-only access to the current state, used for validation
 	pendingState  *state.ManagedState // Pending state tracking virtual nonces
 	currentMaxGas *big.Int            // Current gas limit for transaction caps
+	currentNumber *big.Int            // Current head number for transaction expiry checks
 
 	locals  *accountSet // Set of local transaction to exepmt from evicion rules
 	journal *txJournal  // Journal of local transaction to back up to disk
@@ -203,6 +212,8 @@ type TxPool struct {
 	all     map[common.Hash]*types.Transaction // All transactions to allow lookups
 	priced  *txPricedList                      // All transactions sorted by price
 
+	sponsored map[common.Address]map[common.Hash]*types.Transaction // Sponsored transactions by sponsor, pruned lazily
+
 	wg sync.WaitGroup // for shutdown sync
 
 	homestead bool
@@ -224,6 +235,7 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		queue:       make(map[common.Address]*txList),
 		beats:       make(map[common.Address]time.Time),
 		all:         make(map[common.Hash]*types.Transaction),
+		sponsored:   make(map[common.Address]map[common.Hash]*types.Transaction),
 		chainHeadCh: make(chan ChainHeadEvent, chainHeadChanSize),
 		gasPrice:    new(big.Int).SetUint64(config.PriceLimit),
 	}
@@ -405,8 +417,24 @@ func (pool *TxPool) reset(oldHead, newHead *types.Header) {
 		return
 	}
 	pool.currentState = statedb
+	pool.currentReader = statedb
+
+	// Validate against a concurrent-safe view if the chain provides one
+	if chain, ok := pool.chain.(interface {
+		StateViewAt(root common.Hash) (*state.StateView, error)
+	}); ok {
+		if view, err := chain.StateViewAt(newHead.Root); err == nil {
+			pool.currentReader = view
+		} else {
+			log.Warn("Failed to open txpool state view", "err", err)
+		}
+	}
 	pool.pendingState = state.ManageState(statedb)
 	pool.currentMaxGas = newHead.GasLimit
+	pool.currentNumber = newHead.Number
+
+	// Drop any transactions that can no longer make it into the next block
+	pool.removeExpired()
 
 	// Inject any transactions discarded due to reorgs
 	log.Debug("Reinjecting stale transactions", "count", len(reinject))
@@ -569,6 +597,10 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	if pool.currentMaxGas.Cmp(tx.Gas()) < 0 {
 		return ErrGasLimit
 	}
+	// Ensure the transaction can still be included in the next block
+	if tx.Expired(new(big.Int).Add(pool.currentNumber, common.Big1)) {
+		return ErrTxExpired
+	}
 	// Make sure the transaction is signed properly
 	from, err := types.Sender(pool.signer, tx)
 	if err != nil {
@@ -580,14 +612,30 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 		return ErrUnderpriced
 	}
 	// Ensure the transaction adheres to nonce ordering
-	if pool.currentState.GetNonce(from) > tx.Nonce() {
+	if pool.currentReader.GetNonce(from) > tx.Nonce() {
 		return ErrNonceTooLow
 	}
 	// Transactor should have enough funds to cover the costs
-	// cost == V + GP * GL
-	if types.IsNormalTransaction(tx.Txtype()) && pool.currentState.GetBalance(from).Cmp(tx.Cost()) < 0 {
+	// cost == V + GP * GL, or just V if a sponsor pays for gas
+	if types.IsNormalTransaction(tx.Txtype()) && pool.currentReader.GetBalance(from).Cmp(tx.SenderCost()) < 0 {
 		return ErrInsufficientFunds
 	}
+	// Privacy transactions pay for gas with their stamps, signed by the sender
+	if !types.IsNormalTransaction(tx.Txtype()) && (tx.Type() == types.SponsoredTxType || tx.Type() == types.MultiSigTxType) {
+		return ErrPrivacyTxEnvelope
+	}
+	// Sponsors must have signed and be able to pay GP * GL for all the
+	// transactions they sponsor on top of their own pending ones
+	if tx.Type() == types.SponsoredTxType {
+		sponsor, err := types.Sponsor(pool.signer, tx)
+		if err != nil {
+			return types.ErrInvalidSponsor
+		}
+		cost := new(big.Int).Add(pool.sponsorCommitment(sponsor, from, tx.Nonce()), tx.GasCost())
+		if pool.currentReader.GetBalance(sponsor).Cmp(cost) < 0 {
+			return ErrInsufficientSponsorFunds
+		}
+	}
 
 	intrGas := IntrinsicGas(tx.Data(), tx.To() == nil, pool.homestead)
 	if types.IsNormalTransaction(tx.Txtype()) {
@@ -596,7 +644,7 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 		}
 
 	} else {
-		err := ValidPrivacyTx(pool.currentState, from.Bytes(), tx.Data(), tx.GasPrice(), intrGas, tx.Value(), pool.currentMaxGas)
+		err := ValidPrivacyTx(pool.currentReader, from.Bytes(), tx.Data(), tx.GasPrice(), intrGas, tx.Value(), pool.currentMaxGas)
 		if err != nil {
 			return err
 		}
@@ -605,7 +653,7 @@ func (pool *TxPool) validateTx(tx *types.Transaction, local bool) error {
 	// Check precompile contracts transactions validation
 	if tx.To() != nil {
 		if p := vm.PrecompiledContractsByzantium[*tx.To()]; p != nil {
-			if err = p.ValidTx(pool.currentState, pool.signer, tx); err != nil {
+			if err = p.ValidTx(pool.currentReader, pool.signer, tx); err != nil {
 				return err
 			}
 		}
@@ -668,6 +716,7 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 		}
 		pool.all[tx.Hash()] = tx
 		pool.priced.Put(tx)
+		pool.trackSponsored(tx)
 		pool.journalTx(from, tx)
 
 		log.Trace("Pooled new executable transaction", "hash", hash, "from", from, "to", tx.To())
@@ -678,6 +727,8 @@ func (pool *TxPool) add(tx *types.Transaction, local bool) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	pool.trackSponsored(tx)
+
 	// Mark local addresses and journal local transactions
 	if local {
 		pool.locals.add(from)
@@ -1073,6 +1124,21 @@ func (pool *TxPool) promoteExecutables(accounts []common.Address) {
 	}
 }
 
+// removeExpired drops every transaction whose valid-until block number lies
+// below the next block to be built on top of the current head.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) removeExpired() {
+	next := new(big.Int).Add(pool.currentNumber, common.Big1)
+	for hash, tx := range pool.all {
+		if tx.Expired(next) {
+			log.Trace("Removed expired transaction", "hash", hash, "validUntil", tx.ValidUntil())
+			pool.removeTx(hash)
+			expiredTxCounter.Inc(1)
+		}
+	}
+}
+
 // demoteUnexecutables removes invalid and processed transactions from the pools
 // executable/pending queue and any subsequent transactions that become unexecutable
 // are moved back into the future queue.
@@ -1129,6 +1195,120 @@ func (pool *TxPool) demoteUnexecutables() {
 			delete(pool.beats, addr)
 		}
 	}
+	// Drop the pending and queued transactions their sponsors can't pay for anymore
+	pool.removeUnsponsored()
+}
+
+// trackSponsored adds a pooled transaction to the index of its sponsor.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) trackSponsored(tx *types.Transaction) {
+	if tx.Type() != types.SponsoredTxType {
+		return
+	}
+	sponsor, _ := types.Sponsor(pool.signer, tx) // already validated
+	if pool.sponsored[sponsor] == nil {
+		pool.sponsored[sponsor] = make(map[common.Hash]*types.Transaction)
+	}
+	pool.sponsored[sponsor][tx.Hash()] = tx
+}
+
+// sponsoredTxs returns the pooled transactions of a sponsor, pending ones
+// first, then by sender and nonce. Transactions that left the pool are pruned
+// from the index.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) sponsoredTxs(sponsor common.Address) []*types.Transaction {
+	txs := make([]*types.Transaction, 0, len(pool.sponsored[sponsor]))
+	for hash, tx := range pool.sponsored[sponsor] {
+		if pool.all[hash] == nil {
+			delete(pool.sponsored[sponsor], hash)
+			continue
+		}
+		txs = append(txs, tx)
+	}
+	if len(pool.sponsored[sponsor]) == 0 {
+		delete(pool.sponsored, sponsor)
+	}
+	pending := make(map[common.Hash]bool, len(txs))
+	senders := make(map[common.Hash]common.Address, len(txs))
+	for _, tx := range txs {
+		from, _ := types.Sender(pool.signer, tx) // already validated
+		list := pool.pending[from]
+		pending[tx.Hash()] = list != nil && list.txs.Get(tx.Nonce()) == tx
+		senders[tx.Hash()] = from
+	}
+	sort.Slice(txs, func(i, j int) bool {
+		hi, hj := txs[i].Hash(), txs[j].Hash()
+		if pending[hi] != pending[hj] {
+			return pending[hi]
+		}
+		if c := bytes.Compare(senders[hi][:], senders[hj][:]); c != 0 {
+			return c < 0
+		}
+		return txs[i].Nonce() < txs[j].Nonce()
+	})
+	return txs
+}
+
+// pendingCost returns what an account pays for its pending transactions as
+// their sender, leaving out the one with the given nonce if skip is set.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) pendingCost(addr common.Address, skip bool, nonce uint64) *big.Int {
+	cost := new(big.Int)
+	if list := pool.pending[addr]; list != nil {
+		for _, tx := range list.Flatten() {
+			if skip && tx.Nonce() == nonce {
+				continue
+			}
+			cost.Add(cost, tx.SenderCost())
+		}
+	}
+	return cost
+}
+
+// sponsorCommitment returns what a sponsor is committed to pay: its own
+// pending transactions and the gas of the pooled transactions it sponsors.
+// The transaction of the sender with the given nonce is left out, a new
+// transaction would replace it.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) sponsorCommitment(sponsor, from common.Address, nonce uint64) *big.Int {
+	cost := pool.pendingCost(sponsor, sponsor == from, nonce)
+	for _, tx := range pool.sponsoredTxs(sponsor) {
+		if sender, _ := types.Sender(pool.signer, tx); sender == from && tx.Nonce() == nonce {
+			continue
+		}
+		cost.Add(cost, tx.GasCost())
+	}
+	return cost
+}
+
+// removeUnsponsored drops the transactions whose sponsor can't pay for them on
+// top of its own pending transactions and the ones it sponsors before,
+// pending transactions coming first.
+//
+// Note, this method assumes the pool lock is held!
+func (pool *TxPool) removeUnsponsored() {
+	for sponsor := range pool.sponsored {
+		var (
+			balance = pool.currentState.GetBalance(sponsor)
+			cost    = pool.pendingCost(sponsor, false, 0)
+			drops   []common.Hash
+		)
+		for _, tx := range pool.sponsoredTxs(sponsor) {
+			if cost.Add(cost, tx.GasCost()).Cmp(balance) > 0 {
+				cost.Sub(cost, tx.GasCost())
+				drops = append(drops, tx.Hash())
+			}
+		}
+		for _, hash := range drops {
+			log.Trace("Removed unsponsored transaction", "hash", hash, "sponsor", sponsor)
+			pool.removeTx(hash)
+			unsponsoredCounter.Inc(1)
+		}
+	}
 }
 
 // addressByHeartbeat is an account address tagged with its last activity timestamp.
@@ -239,6 +239,176 @@ func TestInvalidTransactions(t *testing.T) {
 	}
 }
 
+// Tests that expiring transactions are rejected past their valid-until block
+// and evicted from the pool once the chain head moves beyond it.
+func TestTransactionExpiry(t *testing.T) {
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	expiring := func(nonce uint64, validUntil uint64) *types.Transaction {
+		tx, _ := types.SignTx(types.NewExpiringTransaction(nonce, common.Address{}, big.NewInt(100), big.NewInt(100000), big.NewInt(1), nil, validUntil), types.HomesteadSigner{}, key)
+		return tx
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currentState.AddBalance(from, big.NewInt(1000000))
+
+	tx0, tx1 := expiring(0, 1), expiring(1, 5)
+	if err := pool.AddRemote(tx0); err != nil {
+		t.Fatalf("failed to add expiring transaction: %v", err)
+	}
+	if err := pool.AddRemote(tx1); err != nil {
+		t.Fatalf("failed to add expiring transaction: %v", err)
+	}
+	if pending, _ := pool.Stats(); pending != 2 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 2)
+	}
+	// Move the head past the first transaction's validity window
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(1), GasLimit: big.NewInt(1000000)})
+
+	if pool.Get(tx0.Hash()) != nil {
+		t.Errorf("expired transaction not evicted")
+	}
+	if pool.Get(tx1.Hash()) == nil {
+		t.Errorf("live transaction evicted")
+	}
+	if pending, queued := pool.Stats(); pending != 0 || queued != 1 {
+		t.Errorf("pool stats mismatched: have %d/%d, want %d/%d", pending, queued, 0, 1)
+	}
+	if err := pool.AddRemote(tx0); err != ErrTxExpired {
+		t.Errorf("expired transaction error mismatch: have %v, want %v", err, ErrTxExpired)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that sponsored transactions are only accepted if the sponsor signed
+// them and can pay for their gas, while the sender only needs the value.
+func TestTransactionSponsoring(t *testing.T) {
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	sponsorKey, _ := crypto.GenerateKey()
+	sponsor := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+
+	tx, _ := types.SignTx(types.NewSponsoredTransaction(0, common.Address{}, big.NewInt(100), big.NewInt(100000), big.NewInt(1), nil, sponsor), types.HomesteadSigner{}, key)
+	if err := pool.AddRemote(tx); err != types.ErrInvalidSponsor {
+		t.Errorf("unsponsored transaction error mismatch: have %v, want %v", err, types.ErrInvalidSponsor)
+	}
+	tx, _ = types.SponsorTx(tx, types.NewEIP155Signer(params.TestChainConfig.ChainId), sponsorKey)
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	pool.currentState.AddBalance(from, tx.Value())
+	if err := pool.AddRemote(tx); err != ErrInsufficientSponsorFunds {
+		t.Errorf("unfunded sponsor error mismatch: have %v, want %v", err, ErrInsufficientSponsorFunds)
+	}
+	pool.currentState.AddBalance(sponsor, tx.GasCost())
+	if err := pool.AddRemote(tx); err != nil {
+		t.Fatalf("failed to add sponsored transaction: %v", err)
+	}
+	if pending, _ := pool.Stats(); pending != 1 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 1)
+	}
+	// The sponsor has to pay for all the transactions it sponsors
+	otherKey, _ := crypto.GenerateKey()
+	other, _ := types.SignTx(types.NewSponsoredTransaction(0, common.Address{}, big.NewInt(100), big.NewInt(100000), big.NewInt(1), nil, sponsor), types.HomesteadSigner{}, otherKey)
+	other, _ = types.SponsorTx(other, types.NewEIP155Signer(params.TestChainConfig.ChainId), sponsorKey)
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(otherKey.PublicKey), other.Value())
+	if err := pool.AddRemote(other); err != ErrInsufficientSponsorFunds {
+		t.Errorf("overcommitted sponsor error mismatch: have %v, want %v", err, ErrInsufficientSponsorFunds)
+	}
+	pool.currentState.AddBalance(sponsor, other.GasCost())
+	if err := pool.AddRemote(other); err != nil {
+		t.Fatalf("failed to add second sponsored transaction: %v", err)
+	}
+	// Transactions the sponsor can't pay for anymore are dropped
+	pool.currentState.SubBalance(sponsor, other.GasCost())
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(1), GasLimit: big.NewInt(1000000)})
+
+	if pending, queued := pool.Stats(); pending != 1 || queued != 0 {
+		t.Errorf("pool stats mismatched: have %d/%d, want %d/%d", pending, queued, 1, 0)
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that sponsors can't commit the funds their own pending transactions
+// spend to the transactions they sponsor.
+func TestTransactionSponsorSends(t *testing.T) {
+	pool, key := setupTxPool()
+	defer pool.Stop()
+
+	sponsorKey, _ := crypto.GenerateKey()
+	sponsor := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+
+	own := transaction(0, big.NewInt(100000), sponsorKey)
+	pool.currentState.AddBalance(sponsor, own.Cost())
+	if err := pool.AddRemote(own); err != nil {
+		t.Fatalf("failed to add sponsor transaction: %v", err)
+	}
+	tx, _ := types.SignTx(types.NewSponsoredTransaction(0, common.Address{}, big.NewInt(100), big.NewInt(100000), big.NewInt(1), nil, sponsor), types.HomesteadSigner{}, key)
+	tx, _ = types.SponsorTx(tx, types.NewEIP155Signer(params.TestChainConfig.ChainId), sponsorKey)
+	pool.currentState.AddBalance(crypto.PubkeyToAddress(key.PublicKey), tx.Value())
+	if err := pool.AddRemote(tx); err != ErrInsufficientSponsorFunds {
+		t.Errorf("overcommitted sponsor error mismatch: have %v, want %v", err, ErrInsufficientSponsorFunds)
+	}
+	pool.currentState.AddBalance(sponsor, tx.GasCost())
+	if err := pool.AddRemote(tx); err != nil {
+		t.Fatalf("failed to add sponsored transaction: %v", err)
+	}
+	// Once the sponsor can only pay for its own transaction, the sponsored one
+	// is dropped
+	pool.currentState.SubBalance(sponsor, tx.GasCost())
+	pool.lockedReset(nil, &types.Header{Number: big.NewInt(1), GasLimit: big.NewInt(1000000)})
+
+	if pool.all[own.Hash()] == nil {
+		t.Errorf("sponsor transaction dropped")
+	}
+	if pool.all[tx.Hash()] != nil {
+		t.Errorf("unpayable sponsored transaction kept")
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
+// Tests that multi-signature transactions are accepted from the policy account
+// once the threshold of owners signed them.
+func TestTransactionMultiSig(t *testing.T) {
+	pool, _ := setupTxPool()
+	defer pool.Stop()
+
+	keys := make([]*ecdsa.PrivateKey, 3)
+	owners := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		owners[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	policy := &types.MultiSigPolicy{Threshold: 2, Owners: owners}
+	pool.currentState.AddBalance(policy.Address(), big.NewInt(1000000))
+
+	signer := types.NewEIP155Signer(params.TestChainConfig.ChainId)
+	tx := types.NewMultiSigTransaction(0, common.Address{}, big.NewInt(100), big.NewInt(100000), big.NewInt(1), nil, policy)
+	tx, _ = types.SignMultiSigTx(tx, signer, keys[0])
+	if err := pool.AddRemote(tx); err != ErrInvalidSender {
+		t.Errorf("under-signed transaction error mismatch: have %v, want %v", err, ErrInvalidSender)
+	}
+	tx, _ = types.SignMultiSigTx(tx, signer, keys[1])
+	if err := pool.AddRemote(tx); err != nil {
+		t.Fatalf("failed to add multi-signature transaction: %v", err)
+	}
+	if pending, _ := pool.Stats(); pending != 1 {
+		t.Fatalf("pending transactions mismatched: have %d, want %d", pending, 1)
+	}
+	if list := pool.pending[policy.Address()]; list == nil || list.Len() != 1 {
+		t.Errorf("transaction not pooled under the multi-signature account")
+	}
+	if err := validateTxPoolInternals(pool); err != nil {
+		t.Fatalf("pool internal state corrupted: %v", err)
+	}
+}
+
 func TestTransactionQueue(t *testing.T) {
 	pool, key := setupTxPool()
 	defer pool.Stop()
@@ -25,6 +25,8 @@ func (t txdata) MarshalJSON() ([]byte, error) {
 		V            *hexutil.Big    `json:"v" gencodec:"required"`
 		R            *hexutil.Big    `json:"r" gencodec:"required"`
 		S            *hexutil.Big    `json:"s" gencodec:"required"`
+		Type         *hexutil.Uint64 `json:"type,omitempty" rlp:"-"`
+		Ext          TxPayload       `json:"ext,omitempty" rlp:"-"`
 		Hash         *common.Hash    `json:"hash" rlp:"-"`
 	}
 
@@ -39,6 +41,11 @@ func (t txdata) MarshalJSON() ([]byte, error) {
 	enc.V = (*hexutil.Big)(t.V)
 	enc.R = (*hexutil.Big)(t.R)
 	enc.S = (*hexutil.Big)(t.S)
+	if t.Ext != nil {
+		typ := hexutil.Uint64(t.Ext.Type())
+		enc.Type = &typ
+		enc.Ext = t.Ext
+	}
 	enc.Hash = t.Hash
 	return json.Marshal(&enc)
 }
@@ -55,6 +62,8 @@ func (t *txdata) UnmarshalJSON(input []byte) error {
 		V            *hexutil.Big    `json:"v" gencodec:"required"`
 		R            *hexutil.Big    `json:"r" gencodec:"required"`
 		S            *hexutil.Big    `json:"s" gencodec:"required"`
+		Type         *hexutil.Uint64 `json:"type,omitempty" rlp:"-"`
+		Ext          json.RawMessage `json:"ext,omitempty" rlp:"-"`
 		Hash         *common.Hash    `json:"hash" rlp:"-"`
 	}
 	var dec txdata
@@ -102,6 +111,16 @@ func (t *txdata) UnmarshalJSON(input []byte) error {
 		return errors.New("missing required field 's' for txdata")
 	}
 	t.S = (*big.Int)(dec.S)
+	if dec.Type != nil && *dec.Type != hexutil.Uint64(LegacyTxType) {
+		if *dec.Type > 0xff {
+			return ErrEnvelopeType
+		}
+		ext, err := unmarshalTxPayload(byte(*dec.Type), dec.Ext)
+		if err != nil {
+			return err
+		}
+		t.Ext = ext
+	}
 	if dec.Hash != nil {
 		t.Hash = dec.Hash
 	}
@@ -0,0 +1,153 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/common/hexutil"
+	"github.com/combchain/go-combchain/rlp"
+)
+
+// MaxMultiSigOwners bounds the number of owners of a multi-signature account,
+// keeping the cost of signature verification predictable.
+const MaxMultiSigOwners = 16
+
+var (
+	ErrMultiSigThreshold = errors.New("invalid multi-signature threshold")
+	ErrMultiSigOwners    = errors.New("invalid multi-signature owner set")
+	ErrMultiSigSigner    = errors.New("multi-signature signer is not an owner")
+	ErrMultiSigUnsigned  = errors.New("not enough multi-signature signatures")
+	ErrMultiSigSigs      = errors.New("multi-signature signatures not unique and ordered")
+	ErrNotMultiSig       = errors.New("transaction is not multi-signature")
+)
+
+// MultiSigPolicy describes a native m-of-n account. The account address is
+// derived from the policy itself, so no single key controls it.
+type MultiSigPolicy struct {
+	Threshold uint64
+	Owners    []common.Address
+}
+
+// Address returns the address of the account governed by the policy.
+func (p *MultiSigPolicy) Address() common.Address {
+	enc, _ := rlp.EncodeToBytes(p)
+	return common.BytesToAddress(crypto.Keccak256([]byte("multisig"), enc)[12:])
+}
+
+// Validate checks that the threshold is reachable and the owners are unique
+// and within MaxMultiSigOwners.
+func (p *MultiSigPolicy) Validate() error {
+	if len(p.Owners) == 0 || len(p.Owners) > MaxMultiSigOwners {
+		return ErrMultiSigOwners
+	}
+	if p.Threshold == 0 || p.Threshold > uint64(len(p.Owners)) {
+		return ErrMultiSigThreshold
+	}
+	seen := make(map[common.Address]struct{}, len(p.Owners))
+	for _, owner := range p.Owners {
+		if _, ok := seen[owner]; ok {
+			return ErrMultiSigOwners
+		}
+		seen[owner] = struct{}{}
+	}
+	return nil
+}
+
+// Copy returns a deep copy of the policy.
+func (p *MultiSigPolicy) Copy() *MultiSigPolicy {
+	return &MultiSigPolicy{
+		Threshold: p.Threshold,
+		Owners:    append([]common.Address(nil), p.Owners...),
+	}
+}
+
+// MultiSigSignature is a single owner signature of a multi-signature
+// transaction, in the [R || S || V+27] format.
+type MultiSigSignature struct {
+	V *big.Int
+	R *big.Int
+	S *big.Int
+}
+
+// MultiSigPayload turns a transaction into one sent from the account of Policy.
+// The sender signature values of the transaction are unused, the owners sign
+// the transaction with Sigs instead.
+type MultiSigPayload struct {
+	Policy MultiSigPolicy
+	Sigs   []MultiSigSignature
+}
+
+func (p *MultiSigPayload) Type() byte { return MultiSigTxType }
+
+func (p *MultiSigPayload) copy() TxPayload {
+	cpy := &MultiSigPayload{
+		Policy: *p.Policy.Copy(),
+		Sigs:   make([]MultiSigSignature, len(p.Sigs)),
+	}
+	for i, sig := range p.Sigs {
+		cpy.Sigs[i] = MultiSigSignature{V: new(big.Int), R: new(big.Int), S: new(big.Int)}
+		if sig.V != nil {
+			cpy.Sigs[i].V.Set(sig.V)
+		}
+		if sig.R != nil {
+			cpy.Sigs[i].R.Set(sig.R)
+		}
+		if sig.S != nil {
+			cpy.Sigs[i].S.Set(sig.S)
+		}
+	}
+	return cpy
+}
+
+func (p *MultiSigPayload) sigHashFields() []interface{} {
+	return []interface{}{p.Policy.Threshold, p.Policy.Owners}
+}
+
+type multiSigSignatureJSON struct {
+	V *hexutil.Big `json:"v"`
+	R *hexutil.Big `json:"r"`
+	S *hexutil.Big `json:"s"`
+}
+
+type multiSigPayloadJSON struct {
+	Threshold *hexutil.Uint64         `json:"threshold"`
+	Owners    []common.Address        `json:"owners"`
+	Sigs      []multiSigSignatureJSON `json:"sigs"`
+}
+
+func (p *MultiSigPayload) MarshalJSON() ([]byte, error) {
+	threshold := hexutil.Uint64(p.Policy.Threshold)
+	enc := multiSigPayloadJSON{
+		Threshold: &threshold,
+		Owners:    p.Policy.Owners,
+		Sigs:      make([]multiSigSignatureJSON, len(p.Sigs)),
+	}
+	for i, sig := range p.Sigs {
+		enc.Sigs[i] = multiSigSignatureJSON{(*hexutil.Big)(sig.V), (*hexutil.Big)(sig.R), (*hexutil.Big)(sig.S)}
+	}
+	return json.Marshal(&enc)
+}
+
+func (p *MultiSigPayload) UnmarshalJSON(input []byte) error {
+	var dec multiSigPayloadJSON
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	if dec.Threshold == nil {
+		return errors.New("missing required field 'threshold' for multi-signature payload")
+	}
+	p.Policy = MultiSigPolicy{Threshold: uint64(*dec.Threshold), Owners: dec.Owners}
+	p.Sigs = make([]MultiSigSignature, len(dec.Sigs))
+	for i, sig := range dec.Sigs {
+		if sig.V == nil || sig.R == nil || sig.S == nil {
+			return errors.New("incomplete signature in multi-signature payload")
+		}
+		p.Sigs[i] = MultiSigSignature{(*big.Int)(sig.V), (*big.Int)(sig.R), (*big.Int)(sig.S)}
+	}
+	return nil
+}
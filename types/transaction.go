@@ -50,9 +50,10 @@ func deriveSigner(V *big.Int) Signer {
 type Transaction struct {
 	data txdata
 	// caches
-	hash atomic.Value
-	size atomic.Value
-	from atomic.Value
+	hash    atomic.Value
+	size    atomic.Value
+	from    atomic.Value
+	sponsor atomic.Value
 }
 
 type txdata struct {
@@ -70,6 +71,10 @@ type txdata struct {
 	R *big.Int `json:"r" gencodec:"required"`
 	S *big.Int `json:"s" gencodec:"required"`
 
+	// Ext holds the type specific fields of an enveloped transaction and is
+	// nil for legacy ones. It is encoded next to, not inside, the field list.
+	Ext TxPayload `json:"ext,omitempty" rlp:"-"`
+
 	// This is only used when marshaling to JSON.
 	Hash *common.Hash `json:"hash" rlp:"-"`
 }
@@ -95,6 +100,30 @@ func NewContractCreation(nonce uint64, amount, gasLimit, gasPrice *big.Int, data
 	return newTransaction(nonce, nil, amount, gasLimit, gasPrice, data)
 }
 
+// NewExpiringTransaction creates a transaction that may only be included in
+// blocks up to and including validUntil.
+func NewExpiringTransaction(nonce uint64, to common.Address, amount, gasLimit, gasPrice *big.Int, data []byte, validUntil uint64) *Transaction {
+	tx := newTransaction(nonce, &to, amount, gasLimit, gasPrice, data)
+	tx.data.Ext = &ExpiringPayload{ValidUntil: validUntil}
+	return tx
+}
+
+// NewSponsoredTransaction creates a transaction whose gas is paid by sponsor.
+// The sponsor signature has to be added with SponsorTx.
+func NewSponsoredTransaction(nonce uint64, to common.Address, amount, gasLimit, gasPrice *big.Int, data []byte, sponsor common.Address) *Transaction {
+	tx := newTransaction(nonce, &to, amount, gasLimit, gasPrice, data)
+	tx.data.Ext = &SponsoredPayload{Sponsor: sponsor, V: new(big.Int), R: new(big.Int), S: new(big.Int)}
+	return tx
+}
+
+// NewMultiSigTransaction creates a transaction sent from the multi-signature
+// account of policy. Owner signatures have to be added with SignMultiSigTx.
+func NewMultiSigTransaction(nonce uint64, to common.Address, amount, gasLimit, gasPrice *big.Int, data []byte, policy *MultiSigPolicy) *Transaction {
+	tx := newTransaction(nonce, &to, amount, gasLimit, gasPrice, data)
+	tx.data.Ext = &MultiSigPayload{Policy: *policy.Copy()}
+	return tx
+}
+
 func newTransaction(nonce uint64, to *common.Address, amount, gasLimit, gasPrice *big.Int, data []byte) *Transaction {
 	if len(data) > 0 {
 		data = common.CopyBytes(data)
@@ -143,20 +172,41 @@ func isProtectedV(V *big.Int) bool {
 	return true
 }
 
-// DecodeRLP implements rlp.Encoder
+// EncodeRLP implements rlp.Encoder. Legacy transactions are encoded as a
+// plain list, enveloped ones as a byte string holding the envelope.
 func (tx *Transaction) EncodeRLP(w io.Writer) error {
-	return rlp.Encode(w, &tx.data)
+	if tx.data.Ext == nil {
+		return rlp.Encode(w, &tx.data)
+	}
+	enc, err := encodeEnvelope(&tx.data)
+	if err != nil {
+		return err
+	}
+	return rlp.Encode(w, enc)
 }
 
 // DecodeRLP implements rlp.Decoder
 func (tx *Transaction) DecodeRLP(s *rlp.Stream) error {
-	_, size, _ := s.Kind()
-	err := s.Decode(&tx.data)
-	if err == nil {
+	kind, size, err := s.Kind()
+	if err != nil {
+		return err
+	}
+	if kind == rlp.List {
+		if err := s.Decode(&tx.data); err != nil {
+			return err
+		}
 		tx.size.Store(common.StorageSize(rlp.ListSize(size)))
+		return nil
 	}
-
-	return err
+	enc, err := s.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := decodeEnvelope(enc, &tx.data); err != nil {
+		return err
+	}
+	tx.size.Store(common.StorageSize(rlp.ListSize(uint64(len(enc)))))
+	return nil
 }
 
 func (tx *Transaction) MarshalJSON() ([]byte, error) {
@@ -172,6 +222,11 @@ func (tx *Transaction) UnmarshalJSON(input []byte) error {
 	if err := dec.UnmarshalJSON(input); err != nil {
 		return err
 	}
+	// Multi-signature transactions carry their signatures in the payload
+	if dec.Ext != nil && dec.Ext.Type() == MultiSigTxType {
+		*tx = Transaction{data: dec}
+		return nil
+	}
 	var V byte
 	if isProtectedV(dec.V) {
 		chainId := deriveChainId(dec.V).Uint64()
@@ -189,12 +244,50 @@ func (tx *Transaction) UnmarshalJSON(input []byte) error {
 func (tx *Transaction) Data() []byte   { return common.CopyBytes(tx.data.Payload) }
 func (tx *Transaction) Txtype() uint64 { return tx.data.Txtype }
 
+// Type returns the envelope type of the transaction, or LegacyTxType if the
+// transaction is not enveloped. It is independent of Txtype, which selects
+// between public and privacy execution.
+func (tx *Transaction) Type() byte {
+	if tx.data.Ext == nil {
+		return LegacyTxType
+	}
+	return tx.data.Ext.Type()
+}
+
+// Ext returns a copy of the type specific payload of an enveloped transaction,
+// or nil for legacy transactions.
+func (tx *Transaction) Ext() TxPayload {
+	if tx.data.Ext == nil {
+		return nil
+	}
+	return tx.data.Ext.copy()
+}
+
 func (tx *Transaction) Gas() *big.Int      { return new(big.Int).Set(tx.data.GasLimit) }
 func (tx *Transaction) GasPrice() *big.Int { return new(big.Int).Set(tx.data.Price) }
 func (tx *Transaction) Value() *big.Int    { return new(big.Int).Set(tx.data.Amount) }
 func (tx *Transaction) Nonce() uint64      { return tx.data.AccountNonce }
 func (tx *Transaction) CheckNonce() bool   { return true }
 
+// ValidUntil returns the last block number the transaction may be included in.
+// It returns zero if the transaction never expires.
+func (tx *Transaction) ValidUntil() uint64 {
+	if ext, ok := tx.data.Ext.(*ExpiringPayload); ok {
+		return ext.ValidUntil
+	}
+	return 0
+}
+
+// Expired reports whether the transaction may no longer be included in the
+// block with the given number.
+func (tx *Transaction) Expired(number *big.Int) bool {
+	until := tx.ValidUntil()
+	if until == 0 {
+		return false
+	}
+	return number.Cmp(new(big.Int).SetUint64(until)) > 0
+}
+
 // To returns the recipient address of the transaction.
 // It returns nil if the transaction is a contract creation.
 func (tx *Transaction) To() *common.Address {
@@ -222,7 +315,7 @@ func (tx *Transaction) Size() common.StorageSize {
 		return size.(common.StorageSize)
 	}
 	c := writeCounter(0)
-	rlp.Encode(&c, &tx.data)
+	rlp.Encode(&c, tx)
 	tx.size.Store(common.StorageSize(c))
 	return common.StorageSize(c)
 }
@@ -242,11 +335,24 @@ func (tx *Transaction) AsMessage(s Signer) (Message, error) {
 		data:       tx.data.Payload,
 		checkNonce: true,
 		txType:     tx.Txtype(),
+		validUntil: tx.ValidUntil(),
 	}
 
 	var err error
-	msg.from, err = Sender(s, tx)
-	return msg, err
+	if msg.from, err = Sender(s, tx); err != nil {
+		return msg, err
+	}
+	if tx.Type() == SponsoredTxType {
+		sponsor, err := Sponsor(s, tx)
+		if err != nil {
+			return msg, err
+		}
+		msg.sponsor = &sponsor
+	}
+	if ext, ok := tx.data.Ext.(*MultiSigPayload); ok {
+		msg.multiSig = ext.Policy.Copy()
+	}
+	return msg, nil
 }
 
 // WithSignature returns a new transaction with the given signature.
@@ -257,10 +363,28 @@ func (tx *Transaction) WithSignature(signer Signer, sig []byte) (*Transaction, e
 		return nil, err
 	}
 	cpy := &Transaction{data: tx.data}
+	if tx.data.Ext != nil {
+		cpy.data.Ext = tx.data.Ext.copy()
+	}
 	cpy.data.R, cpy.data.S, cpy.data.V = r, s, v
 	return cpy, nil
 }
 
+// GasCost returns gasprice * gaslimit, the part of the cost that a sponsor
+// pays for a sponsored transaction.
+func (tx *Transaction) GasCost() *big.Int {
+	return new(big.Int).Mul(tx.data.Price, tx.data.GasLimit)
+}
+
+// SenderCost returns the amount the sender has to be able to pay: the value
+// for sponsored transactions and the full cost otherwise.
+func (tx *Transaction) SenderCost() *big.Int {
+	if tx.Type() == SponsoredTxType {
+		return new(big.Int).Set(tx.data.Amount)
+	}
+	return tx.Cost()
+}
+
 // Cost returns amount + gasprice * gaslimit.
 func (tx *Transaction) Cost() *big.Int {
 	total := new(big.Int).Mul(tx.data.Price, tx.data.GasLimit)
@@ -292,7 +416,7 @@ func (tx *Transaction) String() string {
 	} else {
 		to = fmt.Sprintf("%x", tx.data.Recipient[:])
 	}
-	enc, _ := rlp.EncodeToBytes(&tx.data)
+	enc, _ := rlp.EncodeToBytes(tx)
 	return fmt.Sprintf(`
 	TX(%x)
 	Contract: %v
@@ -456,6 +580,9 @@ type Message struct {
 	data                    []byte
 	checkNonce              bool
 	txType                  uint64
+	validUntil              uint64
+	sponsor                 *common.Address
+	multiSig                *MultiSigPolicy
 }
 
 func NewMessage(from common.Address, to *common.Address, nonce uint64, amount, gasLimit, price *big.Int, data []byte, checkNonce bool) Message {
@@ -480,7 +607,15 @@ func (m Message) Nonce() uint64        { return m.nonce }
 func (m Message) Data() []byte         { return m.data }
 func (m Message) CheckNonce() bool     { return m.checkNonce }
 
-func (m Message) TxType() uint64 { return m.txType }
+func (m Message) TxType() uint64     { return m.txType }
+func (m Message) ValidUntil() uint64 { return m.validUntil }
+
+// Sponsor returns the account paying for gas, or nil if the sender pays.
+func (m Message) Sponsor() *common.Address { return m.sponsor }
+
+// MultiSig returns the policy of the multi-signature sender, or nil if the
+// message was signed by a single key.
+func (m Message) MultiSig() *MultiSigPolicy { return m.multiSig }
 
 ////////////////////////////////////for privacy tx ///////////////////////
 func NewOTATransaction(nonce uint64, to common.Address, amount, gasLimit, gasPrice *big.Int, data []byte) *Transaction {
@@ -0,0 +1,231 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package types
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/common/hexutil"
+	"github.com/combchain/go-combchain/rlp"
+)
+
+// envelopeVersion is the version byte leading every transaction envelope.
+const envelopeVersion = 0x01
+
+// Envelope types of transactions. Legacy transactions are not enveloped and
+// are encoded as a plain RLP list for backward compatibility.
+const (
+	LegacyTxType    byte = 0x00
+	ExpiringTxType  byte = 0x01
+	SponsoredTxType byte = 0x02
+	MultiSigTxType  byte = 0x03
+)
+
+var (
+	ErrEnvelopeVersion = errors.New("unsupported transaction envelope version")
+	ErrEnvelopeType    = errors.New("unsupported transaction envelope type")
+	errShortEnvelope   = errors.New("transaction envelope too short")
+)
+
+// TxPayload is the type specific part of an enveloped transaction. Every
+// envelope type carries the common transaction fields plus one payload.
+type TxPayload interface {
+	// Type returns the envelope type identifying the payload.
+	Type() byte
+
+	// copy returns a deep copy of the payload.
+	copy() TxPayload
+
+	// sigHashFields returns the payload fields covered by the sender signature.
+	sigHashFields() []interface{}
+}
+
+// txPayloads maps every supported envelope type to a constructor of its
+// payload, used when decoding.
+var txPayloads = map[byte]func() TxPayload{
+	ExpiringTxType:  func() TxPayload { return new(ExpiringPayload) },
+	SponsoredTxType: func() TxPayload { return new(SponsoredPayload) },
+	MultiSigTxType:  func() TxPayload { return new(MultiSigPayload) },
+}
+
+// newTxPayload creates an empty payload for the given envelope type.
+func newTxPayload(typ byte) (TxPayload, error) {
+	ctor, ok := txPayloads[typ]
+	if !ok {
+		return nil, ErrEnvelopeType
+	}
+	return ctor(), nil
+}
+
+// IsValidEnvelopeType reports whether the envelope type is known.
+func IsValidEnvelopeType(typ byte) bool {
+	if typ == LegacyTxType {
+		return true
+	}
+	_, ok := txPayloads[typ]
+	return ok
+}
+
+// envelopeBody is the RLP content of an envelope following the version and
+// type bytes.
+type envelopeBody struct {
+	Data txdata
+	Ext  rlp.RawValue
+}
+
+// encodeEnvelope assembles the envelope of a typed transaction as
+// version || type || rlp([fields, payload]).
+func encodeEnvelope(data *txdata) ([]byte, error) {
+	ext, err := rlp.EncodeToBytes(data.Ext)
+	if err != nil {
+		return nil, err
+	}
+	body, err := rlp.EncodeToBytes(&envelopeBody{Data: *data, Ext: ext})
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte{envelopeVersion, data.Ext.Type()}, body...), nil
+}
+
+// decodeEnvelope parses an envelope produced by encodeEnvelope into data.
+func decodeEnvelope(enc []byte, data *txdata) error {
+	if len(enc) < 2 {
+		return errShortEnvelope
+	}
+	if enc[0] != envelopeVersion {
+		return ErrEnvelopeVersion
+	}
+	ext, err := newTxPayload(enc[1])
+	if err != nil {
+		return err
+	}
+	var body envelopeBody
+	if err := rlp.DecodeBytes(enc[2:], &body); err != nil {
+		return err
+	}
+	if err := rlp.DecodeBytes(body.Ext, ext); err != nil {
+		return err
+	}
+	*data = body.Data
+	data.Ext = ext
+	return nil
+}
+
+// unmarshalTxPayload decodes the JSON representation of a payload of the
+// given envelope type.
+func unmarshalTxPayload(typ byte, input json.RawMessage) (TxPayload, error) {
+	ext, err := newTxPayload(typ)
+	if err != nil {
+		return nil, err
+	}
+	if len(input) == 0 {
+		return nil, fmt.Errorf("missing payload for transaction type %d", typ)
+	}
+	if err := json.Unmarshal(input, ext); err != nil {
+		return nil, err
+	}
+	return ext, nil
+}
+
+// ExpiringPayload limits the inclusion of a transaction to blocks up to and
+// including ValidUntil.
+type ExpiringPayload struct {
+	ValidUntil uint64
+}
+
+func (p *ExpiringPayload) Type() byte { return ExpiringTxType }
+
+func (p *ExpiringPayload) copy() TxPayload {
+	cpy := *p
+	return &cpy
+}
+
+func (p *ExpiringPayload) sigHashFields() []interface{} {
+	return []interface{}{p.ValidUntil}
+}
+
+func (p *ExpiringPayload) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		ValidUntil hexutil.Uint64 `json:"validUntil"`
+	}{hexutil.Uint64(p.ValidUntil)})
+}
+
+func (p *ExpiringPayload) UnmarshalJSON(input []byte) error {
+	var dec struct {
+		ValidUntil *hexutil.Uint64 `json:"validUntil"`
+	}
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	if dec.ValidUntil == nil {
+		return errors.New("missing required field 'validUntil' for expiring payload")
+	}
+	p.ValidUntil = uint64(*dec.ValidUntil)
+	return nil
+}
+
+// SponsoredPayload moves the gas cost of a transaction from its sender to a
+// sponsor. The sender signature commits to the sponsor address, the sponsor
+// countersigns with V, R and S over the sponsor hash.
+type SponsoredPayload struct {
+	Sponsor common.Address
+
+	// Sponsor signature values
+	V *big.Int
+	R *big.Int
+	S *big.Int
+}
+
+func (p *SponsoredPayload) Type() byte { return SponsoredTxType }
+
+func (p *SponsoredPayload) copy() TxPayload {
+	cpy := &SponsoredPayload{Sponsor: p.Sponsor, V: new(big.Int), R: new(big.Int), S: new(big.Int)}
+	if p.V != nil {
+		cpy.V.Set(p.V)
+	}
+	if p.R != nil {
+		cpy.R.Set(p.R)
+	}
+	if p.S != nil {
+		cpy.S.Set(p.S)
+	}
+	return cpy
+}
+
+func (p *SponsoredPayload) sigHashFields() []interface{} {
+	return []interface{}{p.Sponsor}
+}
+
+func (p *SponsoredPayload) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&struct {
+		Sponsor common.Address `json:"sponsor"`
+		V       *hexutil.Big   `json:"v"`
+		R       *hexutil.Big   `json:"r"`
+		S       *hexutil.Big   `json:"s"`
+	}{p.Sponsor, (*hexutil.Big)(p.V), (*hexutil.Big)(p.R), (*hexutil.Big)(p.S)})
+}
+
+func (p *SponsoredPayload) UnmarshalJSON(input []byte) error {
+	var dec struct {
+		Sponsor *common.Address `json:"sponsor"`
+		V       *hexutil.Big    `json:"v"`
+		R       *hexutil.Big    `json:"r"`
+		S       *hexutil.Big    `json:"s"`
+	}
+	if err := json.Unmarshal(input, &dec); err != nil {
+		return err
+	}
+	if dec.Sponsor == nil {
+		return errors.New("missing required field 'sponsor' for sponsored payload")
+	}
+	if dec.V == nil || dec.R == nil || dec.S == nil {
+		return errors.New("missing sponsor signature for sponsored payload")
+	}
+	p.Sponsor = *dec.Sponsor
+	p.V, p.R, p.S = (*big.Int)(dec.V), (*big.Int)(dec.R), (*big.Int)(dec.S)
+	return nil
+}
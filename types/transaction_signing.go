@@ -17,6 +17,7 @@
 package types
 
 import (
+	"bytes"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
@@ -29,6 +30,8 @@ import (
 
 var (
 	ErrInvalidChainId = errors.New("invalid chain id for signer")
+	ErrNotSponsored   = errors.New("transaction is not sponsored")
+	ErrInvalidSponsor = errors.New("sponsor signature does not match sponsor")
 )
 
 // sigCache is used to cache the derived sender and contains
@@ -86,7 +89,15 @@ func Sender(signer Signer, tx *Transaction) (common.Address, error) {
 		}
 	}
 
-	addr, err := signer.Sender(tx)
+	var (
+		addr common.Address
+		err  error
+	)
+	if ext, ok := tx.data.Ext.(*MultiSigPayload); ok {
+		addr, err = multiSigSender(signer.Hash(tx), ext)
+	} else {
+		addr, err = signer.Sender(tx)
+	}
 	if err != nil {
 		return common.Address{}, err
 	}
@@ -94,6 +105,152 @@ func Sender(signer Signer, tx *Transaction) (common.Address, error) {
 	return addr, nil
 }
 
+// SignMultiSigTx adds the signature of one owner to a multi-signature
+// transaction using the given signer and the owner's private key. Signatures
+// are kept ordered by signer address, so owners may sign in any order.
+func SignMultiSigTx(tx *Transaction, s Signer, prv *ecdsa.PrivateKey) (*Transaction, error) {
+	ext, ok := tx.data.Ext.(*MultiSigPayload)
+	if !ok {
+		return nil, ErrNotMultiSig
+	}
+	if uint64(len(ext.Sigs)) >= ext.Policy.Threshold {
+		return nil, ErrMultiSigSigs
+	}
+	h := s.Hash(tx)
+	sig, err := crypto.Sign(h[:], prv)
+	if err != nil {
+		return nil, err
+	}
+	owner := crypto.PubkeyToAddress(prv.PublicKey)
+
+	// Find the position of the new signature among the present ones
+	pos := len(ext.Sigs)
+	for i, have := range ext.Sigs {
+		addr, err := recoverPlain(h, have.R, have.S, have.V, true)
+		if err != nil {
+			return nil, err
+		}
+		if addr == owner {
+			return nil, ErrMultiSigSigs
+		}
+		if bytes.Compare(owner[:], addr[:]) < 0 {
+			pos = i
+			break
+		}
+	}
+	signed := ext.copy().(*MultiSigPayload)
+	signed.Sigs = append(signed.Sigs[:pos], append([]MultiSigSignature{{
+		R: new(big.Int).SetBytes(sig[:32]),
+		S: new(big.Int).SetBytes(sig[32:64]),
+		V: new(big.Int).SetBytes([]byte{sig[64] + 27}),
+	}}, signed.Sigs[pos:]...)...)
+
+	cpy := &Transaction{data: tx.data}
+	cpy.data.Ext = signed
+	return cpy, nil
+}
+
+// multiSigSender verifies the owner signatures of a multi-signature payload
+// against sighash and returns the account address of its policy. Exactly
+// threshold owners have to sign, their signatures ordered by strictly
+// increasing owner address, so that the signatures of a transaction and with
+// them its hash can't be altered by a relayer.
+func multiSigSender(sighash common.Hash, ext *MultiSigPayload) (common.Address, error) {
+	if err := ext.Policy.Validate(); err != nil {
+		return common.Address{}, err
+	}
+	if uint64(len(ext.Sigs)) < ext.Policy.Threshold {
+		return common.Address{}, ErrMultiSigUnsigned
+	}
+	if uint64(len(ext.Sigs)) > ext.Policy.Threshold {
+		return common.Address{}, ErrMultiSigSigs
+	}
+	owners := make(map[common.Address]struct{}, len(ext.Policy.Owners))
+	for _, owner := range ext.Policy.Owners {
+		owners[owner] = struct{}{}
+	}
+	var last common.Address
+	for i, sig := range ext.Sigs {
+		if sig.V == nil || sig.R == nil || sig.S == nil {
+			return common.Address{}, ErrInvalidSig
+		}
+		addr, err := recoverPlain(sighash, sig.R, sig.S, sig.V, true)
+		if err != nil {
+			return common.Address{}, err
+		}
+		if _, ok := owners[addr]; !ok {
+			return common.Address{}, ErrMultiSigSigner
+		}
+		if i > 0 && bytes.Compare(last[:], addr[:]) >= 0 {
+			return common.Address{}, ErrMultiSigSigs
+		}
+		last = addr
+	}
+	return ext.Policy.Address(), nil
+}
+
+// SponsorTx adds the sponsor signature to a sponsored transaction using the
+// given signer and the sponsor's private key.
+func SponsorTx(tx *Transaction, s Signer, prv *ecdsa.PrivateKey) (*Transaction, error) {
+	ext, ok := tx.data.Ext.(*SponsoredPayload)
+	if !ok {
+		return nil, ErrNotSponsored
+	}
+	h := SponsorHash(s, tx)
+	sig, err := crypto.Sign(h[:], prv)
+	if err != nil {
+		return nil, err
+	}
+	signed := ext.copy().(*SponsoredPayload)
+	signed.R = new(big.Int).SetBytes(sig[:32])
+	signed.S = new(big.Int).SetBytes(sig[32:64])
+	signed.V = new(big.Int).SetBytes([]byte{sig[64] + 27})
+
+	cpy := &Transaction{data: tx.data}
+	cpy.data.Ext = signed
+	return cpy, nil
+}
+
+// SponsorHash returns the hash to be signed by the sponsor of a transaction.
+// It binds the sponsor to the exact transaction the sender signed.
+func SponsorHash(s Signer, tx *Transaction) common.Hash {
+	var sponsor common.Address
+	if ext, ok := tx.data.Ext.(*SponsoredPayload); ok {
+		sponsor = ext.Sponsor
+	}
+	return rlpHash([]interface{}{s.Hash(tx), sponsor})
+}
+
+// Sponsor returns the address of the account paying for the gas of a sponsored
+// transaction, derived from the sponsor signature. It fails if the signature
+// doesn't recover to the sponsor the sender committed to.
+//
+// Like Sender, it caches the address for the signer used to derive it.
+func Sponsor(signer Signer, tx *Transaction) (common.Address, error) {
+	ext, ok := tx.data.Ext.(*SponsoredPayload)
+	if !ok {
+		return common.Address{}, ErrNotSponsored
+	}
+	if sc := tx.sponsor.Load(); sc != nil {
+		sigCache := sc.(sigCache)
+		if sigCache.signer.Equal(signer) {
+			return sigCache.from, nil
+		}
+	}
+	if ext.V == nil || ext.R == nil || ext.S == nil {
+		return common.Address{}, ErrInvalidSig
+	}
+	addr, err := recoverPlain(SponsorHash(signer, tx), ext.R, ext.S, ext.V, true)
+	if err != nil {
+		return common.Address{}, err
+	}
+	if addr != ext.Sponsor {
+		return common.Address{}, ErrInvalidSponsor
+	}
+	tx.sponsor.Store(sigCache{signer: signer, from: addr})
+	return addr, nil
+}
+
 // Signer encapsulates transaction signature handling. Note that this interface is not a
 // stable API and may change at any time to accommodate new protocol rules.
 type Signer interface {
@@ -159,16 +316,7 @@ func (s EIP155Signer) SignatureValues(tx *Transaction, sig []byte) (R, S, V *big
 // Hash returns the hash to be signed by the sender.
 // It does not uniquely identify the transaction.
 func (s EIP155Signer) Hash(tx *Transaction) common.Hash {
-	return rlpHash([]interface{}{
-		tx.data.Txtype,
-		tx.data.AccountNonce,
-		tx.data.Price,
-		tx.data.GasLimit,
-		tx.data.Recipient,
-		tx.data.Amount,
-		tx.data.Payload,
-		s.chainId, uint(0), uint(0),
-	})
+	return rlpHash(append(sigHashFields(tx), s.chainId, uint(0), uint(0)))
 }
 
 // HomesteadTransaction implements TransactionInterface using the
@@ -212,7 +360,18 @@ func (fs FrontierSigner) SignatureValues(tx *Transaction, sig []byte) (r, s, v *
 // Hash returns the hash to be signed by the sender.
 // It does not uniquely identify the transaction.
 func (fs FrontierSigner) Hash(tx *Transaction) common.Hash {
-	return rlpHash([]interface{}{
+	return rlpHash(sigHashFields(tx))
+}
+
+func (fs FrontierSigner) Sender(tx *Transaction) (common.Address, error) {
+	return recoverPlain(fs.Hash(tx), tx.data.R, tx.data.S, tx.data.V, false)
+}
+
+// sigHashFields returns the transaction fields covered by the sender signature.
+// Enveloped transactions commit to the envelope version and type as well as to
+// their type specific fields, so a signature can't be replayed across types.
+func sigHashFields(tx *Transaction) []interface{} {
+	fields := []interface{}{
 		tx.data.Txtype,
 		tx.data.AccountNonce,
 		tx.data.Price,
@@ -220,11 +379,12 @@ func (fs FrontierSigner) Hash(tx *Transaction) common.Hash {
 		tx.data.Recipient,
 		tx.data.Amount,
 		tx.data.Payload,
-	})
-}
-
-func (fs FrontierSigner) Sender(tx *Transaction) (common.Address, error) {
-	return recoverPlain(fs.Hash(tx), tx.data.R, tx.data.S, tx.data.V, false)
+	}
+	if tx.data.Ext == nil {
+		return fields
+	}
+	fields = append([]interface{}{uint(envelopeVersion), uint(tx.data.Ext.Type())}, fields...)
+	return append(fields, tx.data.Ext.sigHashFields()...)
 }
 
 func recoverPlain(sighash common.Hash, R, S, Vb *big.Int, homestead bool) (common.Address, error) {
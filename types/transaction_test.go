@@ -233,3 +233,229 @@ func TestTransactionJSON(t *testing.T) {
 		}
 	}
 }
+
+// Tests that expiring transactions survive an RLP and JSON round trip inside
+// their envelope with the valid-until block number intact and covered by the
+// signature.
+func TestExpiringTransactionEncoding(t *testing.T) {
+	key, addr := defaultTestKey()
+	signer := NewEIP155Signer(common.Big1)
+
+	tx, err := SignTx(NewExpiringTransaction(3, common.Address{1}, common.Big1, big.NewInt(21000), common.Big1, []byte("abc"), 100), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	parsed, err := decodeTx(enc)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if parsed.Hash() != tx.Hash() {
+		t.Errorf("hash mismatch: have %x, want %x", parsed.Hash(), tx.Hash())
+	}
+	if parsed.ValidUntil() != 100 {
+		t.Errorf("valid-until mismatch: have %d, want %d", parsed.ValidUntil(), 100)
+	}
+	if from, err := Sender(signer, parsed); err != nil || from != addr {
+		t.Errorf("sender mismatch: have %x (%v), want %x", from, err, addr)
+	}
+	data, err := json.Marshal(tx)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var jsonTx *Transaction
+	if err := json.Unmarshal(data, &jsonTx); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if jsonTx.Hash() != tx.Hash() {
+		t.Errorf("json hash mismatch: have %x, want %x", jsonTx.Hash(), tx.Hash())
+	}
+	// Changing the expiry must invalidate the signature
+	tampered := parsed.data
+	tampered.Ext = &ExpiringPayload{ValidUntil: 200}
+	if from, err := Sender(signer, &Transaction{data: tampered}); err == nil && from == addr {
+		t.Errorf("tampered valid-until still recovers the original sender")
+	}
+	// Legacy transactions never expire
+	if rightvrsTx.ValidUntil() != 0 || rightvrsTx.Expired(big.NewInt(1<<40)) {
+		t.Errorf("legacy transaction reported as expiring")
+	}
+	if tx.Expired(big.NewInt(100)) || !tx.Expired(big.NewInt(101)) {
+		t.Errorf("expiry boundary mismatch")
+	}
+}
+
+// Tests that enveloped transactions are encoded as RLP strings, while legacy
+// ones keep decoding from plain lists, and that malformed envelopes are
+// rejected.
+func TestTransactionEnvelope(t *testing.T) {
+	tx := NewExpiringTransaction(0, common.Address{1}, common.Big0, common.Big1, common.Big1, nil, 10)
+	if tx.Type() != ExpiringTxType {
+		t.Fatalf("envelope type mismatch: have %d, want %d", tx.Type(), ExpiringTxType)
+	}
+	if rightvrsTx.Type() != LegacyTxType {
+		t.Fatalf("legacy type mismatch: have %d, want %d", rightvrsTx.Type(), LegacyTxType)
+	}
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	if enc[0] >= 0xc0 {
+		t.Fatalf("enveloped transaction encoded as list: %x", enc)
+	}
+	var envelope []byte
+	if err := rlp.DecodeBytes(enc, &envelope); err != nil {
+		t.Fatalf("envelope not a byte string: %v", err)
+	}
+	if envelope[0] != envelopeVersion || envelope[1] != ExpiringTxType {
+		t.Fatalf("envelope header mismatch: have %x", envelope[:2])
+	}
+	// Unknown versions and types must be refused
+	for _, header := range [][]byte{{0x02, ExpiringTxType}, {envelopeVersion, 0xff}} {
+		bad := append(append([]byte{}, header...), envelope[2:]...)
+		badEnc, _ := rlp.EncodeToBytes(bad)
+		if _, err := decodeTx(badEnc); err == nil {
+			t.Errorf("envelope with header %x decoded without error", header)
+		}
+	}
+	// Signatures must not carry over between the legacy and enveloped forms
+	legacy := NewTransaction(0, common.Address{1}, common.Big0, common.Big1, common.Big1, nil)
+	signer := NewEIP155Signer(common.Big1)
+	if signer.Hash(legacy) == signer.Hash(tx) {
+		t.Errorf("legacy and enveloped transactions share a signing hash")
+	}
+}
+
+// Tests that the sponsor of a sponsored transaction is recovered from its
+// countersignature and must match the sponsor committed to by the sender.
+func TestSponsoredTransaction(t *testing.T) {
+	key, addr := defaultTestKey()
+	sponsorKey, _ := crypto.GenerateKey()
+	sponsorAddr := crypto.PubkeyToAddress(sponsorKey.PublicKey)
+	signer := NewEIP155Signer(common.Big1)
+
+	tx, err := SignTx(NewSponsoredTransaction(0, common.Address{1}, common.Big1, big.NewInt(21000), common.Big1, nil, sponsorAddr), signer, key)
+	if err != nil {
+		t.Fatalf("could not sign transaction: %v", err)
+	}
+	if _, err := Sponsor(signer, tx); err == nil {
+		t.Fatalf("unsigned sponsor recovered")
+	}
+	tx, err = SponsorTx(tx, signer, sponsorKey)
+	if err != nil {
+		t.Fatalf("could not sponsor transaction: %v", err)
+	}
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	parsed, err := decodeTx(enc)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if from, err := Sender(signer, parsed); err != nil || from != addr {
+		t.Errorf("sender mismatch: have %x (%v), want %x", from, err, addr)
+	}
+	if sponsor, err := Sponsor(signer, parsed); err != nil || sponsor != sponsorAddr {
+		t.Errorf("sponsor mismatch: have %x (%v), want %x", sponsor, err, sponsorAddr)
+	}
+	msg, err := parsed.AsMessage(signer)
+	if err != nil {
+		t.Fatalf("failed to convert to message: %v", err)
+	}
+	if msg.Sponsor() == nil || *msg.Sponsor() != sponsorAddr {
+		t.Errorf("message sponsor mismatch: have %v, want %x", msg.Sponsor(), sponsorAddr)
+	}
+	if parsed.SenderCost().Cmp(common.Big1) != 0 {
+		t.Errorf("sender cost mismatch: have %v, want %v", parsed.SenderCost(), common.Big1)
+	}
+	// A countersignature by anyone but the committed sponsor is rejected
+	otherKey, _ := crypto.GenerateKey()
+	forged, err := SponsorTx(tx, signer, otherKey)
+	if err != nil {
+		t.Fatalf("could not sponsor transaction: %v", err)
+	}
+	if _, err := Sponsor(signer, forged); err != ErrInvalidSponsor {
+		t.Errorf("forged sponsor error mismatch: have %v, want %v", err, ErrInvalidSponsor)
+	}
+	if _, err := SponsorTx(rightvrsTx, signer, sponsorKey); err != ErrNotSponsored {
+		t.Errorf("legacy sponsoring error mismatch: have %v, want %v", err, ErrNotSponsored)
+	}
+}
+
+// Tests that multi-signature transactions recover to the policy account once
+// enough distinct owners signed, and are rejected otherwise.
+func TestMultiSigTransaction(t *testing.T) {
+	keys := make([]*ecdsa.PrivateKey, 3)
+	owners := make([]common.Address, len(keys))
+	for i := range keys {
+		keys[i], _ = crypto.GenerateKey()
+		owners[i] = crypto.PubkeyToAddress(keys[i].PublicKey)
+	}
+	policy := &MultiSigPolicy{Threshold: 2, Owners: owners}
+	signer := NewEIP155Signer(common.Big1)
+
+	tx := NewMultiSigTransaction(0, common.Address{1}, common.Big1, big.NewInt(21000), common.Big1, nil, policy)
+	tx, _ = SignMultiSigTx(tx, signer, keys[0])
+	if _, err := Sender(signer, tx); err != ErrMultiSigUnsigned {
+		t.Errorf("single signature error mismatch: have %v, want %v", err, ErrMultiSigUnsigned)
+	}
+	if _, err := SignMultiSigTx(tx, signer, keys[0]); err != ErrMultiSigSigs {
+		t.Errorf("duplicate signing error mismatch: have %v, want %v", err, ErrMultiSigSigs)
+	}
+	outsider, _ := crypto.GenerateKey()
+	if forged, _ := SignMultiSigTx(tx, signer, outsider); forged != nil {
+		if _, err := Sender(signer, forged); err != ErrMultiSigSigner {
+			t.Errorf("outsider signature error mismatch: have %v, want %v", err, ErrMultiSigSigner)
+		}
+	}
+	tx, _ = SignMultiSigTx(tx, signer, keys[2])
+
+	// Reordered, duplicated or surplus signatures must not yield other valid
+	// encodings of the same transaction
+	sigs := tx.data.Ext.(*MultiSigPayload).Sigs
+	third, _ := SignMultiSigTx(NewMultiSigTransaction(0, common.Address{1}, common.Big1, big.NewInt(21000), common.Big1, nil, policy), signer, keys[1])
+	for i, alt := range [][]MultiSigSignature{
+		{sigs[1], sigs[0]},
+		{sigs[0], sigs[0]},
+		append(append([]MultiSigSignature{}, sigs...), third.data.Ext.(*MultiSigPayload).Sigs[0]),
+	} {
+		malleated := &Transaction{data: tx.data}
+		malleated.data.Ext = &MultiSigPayload{Policy: *policy, Sigs: alt}
+		if _, err := Sender(signer, malleated); err != ErrMultiSigSigs {
+			t.Errorf("malleated signatures %d: error mismatch: have %v, want %v", i, err, ErrMultiSigSigs)
+		}
+	}
+	enc, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatalf("encode error: %v", err)
+	}
+	parsed, err := decodeTx(enc)
+	if err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	from, err := Sender(signer, parsed)
+	if err != nil {
+		t.Fatalf("failed to derive sender: %v", err)
+	}
+	if from != policy.Address() {
+		t.Errorf("sender mismatch: have %x, want %x", from, policy.Address())
+	}
+	data, err := json.Marshal(parsed)
+	if err != nil {
+		t.Fatalf("json.Marshal failed: %v", err)
+	}
+	var jsonTx *Transaction
+	if err := json.Unmarshal(data, &jsonTx); err != nil {
+		t.Fatalf("json.Unmarshal failed: %v", err)
+	}
+	if jsonTx.Hash() != tx.Hash() {
+		t.Errorf("json hash mismatch: have %x, want %x", jsonTx.Hash(), tx.Hash())
+	}
+	if _, err := SignMultiSigTx(rightvrsTx, signer, keys[0]); err != ErrNotMultiSig {
+		t.Errorf("legacy signing error mismatch: have %v, want %v", err, ErrNotMultiSig)
+	}
+}
@@ -81,7 +81,7 @@ func (c *ecrecover) Run(input []byte, contract *Contract, evm *EVM) ([]byte, err
 	return common.LeftPadBytes(crypto.Keccak256(pubKey[1:])[12:], 32), nil
 }
 
-func (c *ecrecover) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *ecrecover) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -100,7 +100,7 @@ func (c *sha256hash) Run(input []byte, contract *Contract, evm *EVM) ([]byte, er
 	return h[:], nil
 }
 
-func (c *sha256hash) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *sha256hash) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -120,7 +120,7 @@ func (c *ripemd160hash) Run(input []byte, contract *Contract, evm *EVM) ([]byte,
 	return common.LeftPadBytes(ripemd.Sum(nil), 32), nil
 }
 
-func (c *ripemd160hash) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *ripemd160hash) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -138,7 +138,7 @@ func (c *dataCopy) Run(in []byte, contract *Contract, evm *EVM) ([]byte, error)
 	return in, nil
 }
 
-func (c *dataCopy) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *dataCopy) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -247,7 +247,7 @@ func (c *bigModExp) Run(input []byte, contract *Contract, evm *EVM) ([]byte, err
 	return common.LeftPadBytes(base.Exp(base, exp, mod).Bytes(), int(modLen)), nil
 }
 
-func (c *bigModExp) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *bigModExp) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -315,7 +315,7 @@ func (c *bn256Add) Run(input []byte, contract *Contract, evm *EVM) ([]byte, erro
 	return res.Marshal(), nil
 }
 
-func (c *bn256Add) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *bn256Add) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -337,7 +337,7 @@ func (c *bn256ScalarMul) Run(input []byte, contract *Contract, evm *EVM) ([]byte
 	return res.Marshal(), nil
 }
 
-func (c *bn256ScalarMul) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *bn256ScalarMul) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -389,7 +389,7 @@ func (c *bn256Pairing) Run(input []byte, contract *Contract, evm *EVM) ([]byte,
 	return false32Byte, nil
 }
 
-func (c *bn256Pairing) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *bn256Pairing) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	return nil
 }
 
@@ -558,7 +558,7 @@ func (c *combchainStampSC) Run(in []byte, contract *Contract, env *EVM) ([]byte,
 	return nil, errMethodId
 }
 
-func (c *combchainStampSC) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *combchainStampSC) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	if stateDB == nil || signer == nil || tx == nil {
 		return errParameters
 	}
@@ -578,7 +578,7 @@ func (c *combchainStampSC) ValidTx(stateDB StateDB, signer types.Signer, tx *typ
 	return errParameters
 }
 
-func (c *combchainStampSC) ValidBuyStampReq(stateDB StateDB, payload []byte, value *big.Int) (otaAddr []byte, err error) {
+func (c *combchainStampSC) ValidBuyStampReq(stateDB StateReader, payload []byte, value *big.Int) (otaAddr []byte, err error) {
 	if stateDB == nil || len(payload) == 0 || value == nil {
 		return nil, errors.New("unknown error")
 	}
@@ -701,7 +701,7 @@ func (c *combCoinSC) Run(in []byte, contract *Contract, evm *EVM) ([]byte, error
 	return nil, errMethodId
 }
 
-func (c *combCoinSC) ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error {
+func (c *combCoinSC) ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error {
 	if stateDB == nil || signer == nil || tx == nil {
 		return errParameters
 	}
@@ -735,7 +735,7 @@ var (
 	ether = new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
 )
 
-func (c *combCoinSC) ValidBuyCoinReq(stateDB StateDB, payload []byte, txValue *big.Int) (otaAddr []byte, err error) {
+func (c *combCoinSC) ValidBuyCoinReq(stateDB StateReader, payload []byte, txValue *big.Int) (otaAddr []byte, err error) {
 	if stateDB == nil || len(payload) == 0 || txValue == nil {
 		return nil, errors.New("unknown error")
 	}
@@ -804,7 +804,7 @@ func (c *combCoinSC) buyCoin(in []byte, contract *Contract, evm *EVM) ([]byte, e
 	}
 }
 
-func (c *combCoinSC) ValidRefundReq(stateDB StateDB, payload []byte, from []byte) (image []byte, value *big.Int, err error) {
+func (c *combCoinSC) ValidRefundReq(stateDB StateReader, payload []byte, from []byte) (image []byte, value *big.Int, err error) {
 	if stateDB == nil || len(payload) == 0 || len(from) == 0 {
 		return nil, nil, errors.New("unknown error")
 	}
@@ -926,7 +926,7 @@ type RingSignInfo struct {
 	OTABalance *big.Int
 }
 
-func FetchRingSignInfo(stateDB StateDB, hashInput []byte, ringSignedStr string) (info *RingSignInfo, err error) {
+func FetchRingSignInfo(stateDB StateReader, hashInput []byte, ringSignedStr string) (info *RingSignInfo, err error) {
 	if stateDB == nil || hashInput == nil {
 		return nil, errParameters
 	}
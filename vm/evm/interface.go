@@ -69,6 +69,26 @@ type StateDB interface {
 	ForEachStorageByteArray(common.Address, func(common.Hash, []byte) bool)
 }
 
+// StateReader is the read-only part of StateDB, used to validate transactions
+// and query the OTA storage without modifying the state.
+type StateReader interface {
+	GetBalance(common.Address) *big.Int
+	GetNonce(common.Address) uint64
+
+	GetCodeHash(common.Address) common.Hash
+	GetCode(common.Address) []byte
+	GetCodeSize(common.Address) int
+
+	GetState(common.Address, common.Hash) common.Hash
+	GetStateByteArray(common.Address, common.Hash) []byte
+
+	Exist(common.Address) bool
+	Empty(common.Address) bool
+
+	ForEachStorage(common.Address, func(common.Hash, common.Hash) bool)
+	ForEachStorageByteArray(common.Address, func(common.Hash, []byte) bool)
+}
+
 // CallContext provides a basic interface for the EVM calling conventions. The EVM EVM
 // depends on this context being implemented for doing subcalls and initialising new EVM contracts.
 type CallContext interface {
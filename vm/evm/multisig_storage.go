@@ -0,0 +1,71 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/rlp"
+	"github.com/combchain/go-combchain/types"
+)
+
+var (
+	ErrMultiSigPolicyMismatch = errors.New("multi-signature policy mismatch")
+)
+
+// GetMultiSigPolicy retrieves the policy registered for a multi-signature
+// account. It returns nil if addr is not a multi-signature account.
+func GetMultiSigPolicy(statedb StateReader, addr common.Address) (*types.MultiSigPolicy, error) {
+	if statedb == nil {
+		return nil, ErrUnknown
+	}
+
+	enc := statedb.GetStateByteArray(multiSigStorageAddr, addr.Hash())
+	if len(enc) == 0 {
+		return nil, nil
+	}
+
+	policy := new(types.MultiSigPolicy)
+	if err := rlp.DecodeBytes(enc, policy); err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// IsMultiSigAccount checks whether addr is a registered multi-signature account.
+func IsMultiSigAccount(statedb StateReader, addr common.Address) bool {
+	if statedb == nil {
+		return false
+	}
+	return len(statedb.GetStateByteArray(multiSigStorageAddr, addr.Hash())) != 0
+}
+
+// RegisterMultiSigPolicy records policy as the policy of the account it
+// derives. Registering the same policy again is a no-op.
+func RegisterMultiSigPolicy(statedb StateDB, policy *types.MultiSigPolicy) error {
+	if statedb == nil || policy == nil {
+		return ErrUnknown
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	enc, err := rlp.EncodeToBytes(policy)
+	if err != nil {
+		return err
+	}
+
+	addr := policy.Address()
+	stored := statedb.GetStateByteArray(multiSigStorageAddr, addr.Hash())
+	if len(stored) != 0 {
+		if !bytes.Equal(stored, enc) {
+			return ErrMultiSigPolicyMismatch
+		}
+		return nil
+	}
+
+	statedb.SetStateByteArray(multiSigStorageAddr, addr.Hash(), enc)
+	return nil
+}
@@ -0,0 +1,50 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package vm
+
+import (
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/state"
+	"github.com/combchain/go-combchain/types"
+)
+
+func TestRegisterMultiSigPolicy(t *testing.T) {
+	var (
+		db, _      = ethdb.NewMemDatabase()
+		statedb, _ = state.New(common.Hash{}, state.NewDatabase(db))
+
+		policy = &types.MultiSigPolicy{
+			Threshold: 2,
+			Owners:    []common.Address{{1}, {2}, {3}},
+		}
+	)
+
+	if IsMultiSigAccount(statedb, policy.Address()) {
+		t.Fatalf("unregistered account reported as multi-signature")
+	}
+	if err := RegisterMultiSigPolicy(statedb, policy); err != nil {
+		t.Fatalf("RegisterMultiSigPolicy err:%s", err.Error())
+	}
+	if err := RegisterMultiSigPolicy(statedb, policy); err != nil {
+		t.Fatalf("repeated RegisterMultiSigPolicy err:%s", err.Error())
+	}
+	if !IsMultiSigAccount(statedb, policy.Address()) {
+		t.Fatalf("registered account not reported as multi-signature")
+	}
+
+	stored, err := GetMultiSigPolicy(statedb, policy.Address())
+	if err != nil {
+		t.Fatalf("GetMultiSigPolicy err:%s", err.Error())
+	}
+	if stored == nil || stored.Threshold != policy.Threshold || len(stored.Owners) != len(policy.Owners) {
+		t.Errorf("stored policy mismatch: have %v, want %v", stored, policy)
+	}
+
+	invalid := &types.MultiSigPolicy{Threshold: 4, Owners: policy.Owners}
+	if err := RegisterMultiSigPolicy(statedb, invalid); err != types.ErrMultiSigThreshold {
+		t.Errorf("invalid policy err mismatch: have %v, want %v", err, types.ErrMultiSigThreshold)
+	}
+}
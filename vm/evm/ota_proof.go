@@ -0,0 +1,123 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+
+	"github.com/combchain/combchain/crypto"
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/state"
+)
+
+var (
+	ErrInvalidOTAProof = errors.New("invalid OTA proof")
+)
+
+// OTAProof proves whether an OTA exists. It proves the balance stored for the
+// OTA AX in the balance storage and, if the OTA exists, the OTA stored under
+// the AX in the storage bucket of its balance.
+type OTAProof struct {
+	Balance *state.AccountProof // Proof of the AX in otaBalanceStorageAddr
+	Bucket  *state.AccountProof // Proof of the AX in the balance bucket, nil if the OTA doesn't exist
+}
+
+// GetOTAProof returns the proof of the OTA in the state. The state must have
+// been finalised, see state.StateDB.GetProof.
+func GetOTAProof(statedb *state.StateDB, otacombAddr []byte) (*OTAProof, error) {
+	otaAX, err := GetAXFromcombAddr(otacombAddr)
+	if err != nil {
+		return nil, err
+	}
+	key := common.BytesToHash(otaAX)
+
+	proof := new(OTAProof)
+	if proof.Balance, err = statedb.GetProof(otaBalanceStorageAddr, []common.Hash{key}); err != nil {
+		return nil, err
+	}
+	balance, err := GetOtaBalanceFromAX(statedb, otaAX)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Sign() == 0 {
+		return proof, nil
+	}
+	if proof.Bucket, err = statedb.GetProof(OTABalance2ContractAddr(balance), []common.Hash{key}); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// VerifyOTAProof checks the proof of the OTA against a state root. It returns
+// whether the OTA exists and its balance.
+func VerifyOTAProof(root common.Hash, otacombAddr []byte, proof *OTAProof) (bool, *big.Int, error) {
+	otaAX, err := GetAXFromcombAddr(otacombAddr)
+	if err != nil {
+		return false, nil, err
+	}
+	key := common.BytesToHash(otaAX)
+
+	value, err := verifySlotProof(root, otaBalanceStorageAddr, key, proof.Balance)
+	if err != nil {
+		return false, nil, err
+	}
+	balance := new(big.Int).SetBytes(value)
+	if balance.Sign() == 0 {
+		if proof.Bucket != nil {
+			return false, nil, ErrInvalidOTAProof
+		}
+		return false, common.Big0, nil
+	}
+	stored, err := verifySlotProof(root, OTABalance2ContractAddr(balance), key, proof.Bucket)
+	if err != nil {
+		return false, nil, err
+	}
+	if !bytes.Equal(stored, otacombAddr) {
+		return false, nil, ErrInvalidOTAProof
+	}
+	return true, balance, nil
+}
+
+// GetOTAImageProof returns the proof of the OTA image key in the image
+// storage. The state must have been finalised, see state.StateDB.GetProof.
+func GetOTAImageProof(statedb *state.StateDB, otaImage []byte) (*state.AccountProof, error) {
+	if len(otaImage) == 0 {
+		return nil, errors.New("invalid input param!")
+	}
+	return statedb.GetProof(otaImageStorageAddr, []common.Hash{crypto.Keccak256Hash(otaImage)})
+}
+
+// VerifyOTAImageProof checks the proof of the OTA image against a state root.
+// It returns whether the image has been used and the value stored for it.
+func VerifyOTAImageProof(root common.Hash, otaImage []byte, proof *state.AccountProof) (bool, []byte, error) {
+	if len(otaImage) == 0 {
+		return false, nil, errors.New("invalid input param!")
+	}
+	value, err := verifySlotProof(root, otaImageStorageAddr, crypto.Keccak256Hash(otaImage), proof)
+	if err != nil {
+		return false, nil, err
+	}
+	return len(value) != 0, value, nil
+}
+
+// verifySlotProof checks that the proof covers exactly the given storage slot
+// of the account, returning its raw value. Slots of missing accounts are
+// empty.
+func verifySlotProof(root common.Hash, addr common.Address, key common.Hash, proof *state.AccountProof) ([]byte, error) {
+	if proof == nil || proof.Address != addr {
+		return nil, ErrInvalidOTAProof
+	}
+	account, values, err := proof.Verify(root)
+	if err != nil {
+		return nil, err
+	}
+	if account == nil {
+		return nil, nil
+	}
+	if len(values) != 1 || proof.Storage[0].Key != key {
+		return nil, ErrInvalidOTAProof
+	}
+	return values[0], nil
+}
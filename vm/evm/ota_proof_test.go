@@ -0,0 +1,67 @@
+// Copyright 2018 combchain Foundation Ltd
+
+package vm
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/combchain/go-combchain/common"
+	"github.com/combchain/go-combchain/ethdb"
+	"github.com/combchain/go-combchain/state"
+)
+
+func TestOTAProofs(t *testing.T) {
+	var (
+		db, _      = ethdb.NewMemDatabase()
+		statedb, _ = state.New(common.Hash{}, state.NewDatabase(db))
+
+		stored  = common.FromHex(otaShortAddrs[0])
+		missing = common.FromHex(otaShortAddrs[1])
+		image   = []byte("ota image")
+		unused  = []byte("unused ota image")
+		balance = big.NewInt(1000000)
+	)
+	if _, err := AddOTAIfNotExist(statedb, balance, stored); err != nil {
+		t.Fatalf("failed to add ota: %v", err)
+	}
+	if err := AddOTAImage(statedb, image, []byte{0x01}); err != nil {
+		t.Fatalf("failed to add ota image: %v", err)
+	}
+	root, _ := statedb.CommitTo(db, true)
+
+	proof, err := GetOTAProof(statedb, stored)
+	if err != nil {
+		t.Fatalf("failed to create ota proof: %v", err)
+	}
+	if exist, have, err := VerifyOTAProof(root, stored, proof); err != nil || !exist || have.Cmp(balance) != 0 {
+		t.Errorf("ota proof mismatch: have %v %v (%v), want true %v", exist, have, err, balance)
+	}
+	// A proof of one OTA must not prove another
+	if _, _, err := VerifyOTAProof(root, missing, proof); err == nil {
+		t.Errorf("ota proof verified for another ota")
+	}
+	proof, err = GetOTAProof(statedb, missing)
+	if err != nil {
+		t.Fatalf("failed to create ota absence proof: %v", err)
+	}
+	if exist, _, err := VerifyOTAProof(root, missing, proof); err != nil || exist {
+		t.Errorf("ota absence proof mismatch: have %v (%v), want false", exist, err)
+	}
+	// Key images are proven used or unused
+	imageProof, err := GetOTAImageProof(statedb, image)
+	if err != nil {
+		t.Fatalf("failed to create image proof: %v", err)
+	}
+	if used, value, err := VerifyOTAImageProof(root, image, imageProof); err != nil || !used || !bytes.Equal(value, []byte{0x01}) {
+		t.Errorf("image proof mismatch: have %v %x (%v), want true 01", used, value, err)
+	}
+	if _, _, err := VerifyOTAImageProof(root, unused, imageProof); err == nil {
+		t.Errorf("image proof verified for another image")
+	}
+	imageProof, _ = GetOTAImageProof(statedb, unused)
+	if used, _, err := VerifyOTAImageProof(root, unused, imageProof); err != nil || used {
+		t.Errorf("image absence proof mismatch: have %v (%v), want false", used, err)
+	}
+}
@@ -57,7 +57,7 @@ func IsAXPointTocombAddr(AX []byte, otacombAddr []byte) bool {
 }
 
 // GetOtaBalanceFromAX retrieve ota balance from ota AX
-func GetOtaBalanceFromAX(statedb StateDB, otaAX []byte) (*big.Int, error) {
+func GetOtaBalanceFromAX(statedb StateReader, otaAX []byte) (*big.Int, error) {
 	if statedb == nil {
 		return nil, ErrUnknown
 	}
@@ -92,7 +92,7 @@ func SetOtaBalanceToAX(statedb StateDB, otaAX []byte, balance *big.Int) error {
 //
 // In order to avoid additional ota have conflict with existing,
 // even if AX exist in balance storage already, will return true.
-func CheckOTAAXExist(statedb StateDB, otaAX []byte) (exist bool, balance *big.Int, err error) {
+func CheckOTAAXExist(statedb StateReader, otaAX []byte) (exist bool, balance *big.Int, err error) {
 	if statedb == nil {
 		return false, nil, ErrUnknown
 	}
@@ -113,7 +113,7 @@ func CheckOTAAXExist(statedb StateDB, otaAX []byte) (exist bool, balance *big.In
 	return true, balance, nil
 }
 
-func CheckOTALongAddrExist(statedb StateDB, otaLongAddr []byte) (exist bool, balance *big.Int, err error) {
+func CheckOTALongAddrExist(statedb StateReader, otaLongAddr []byte) (exist bool, balance *big.Int, err error) {
 	if statedb == nil {
 		return false, nil, ErrUnknown
 	}
@@ -153,7 +153,7 @@ func CheckOTALongAddrExist(statedb StateDB, otaLongAddr []byte) (exist bool, bal
 	return true, balance, nil
 }
 
-func BatCheckOTAExist(statedb StateDB, otaLongAddrs [][]byte) (exist bool, balance *big.Int, unexistOta []byte, err error) {
+func BatCheckOTAExist(statedb StateReader, otaLongAddrs [][]byte) (exist bool, balance *big.Int, unexistOta []byte, err error) {
 	if statedb == nil || len(otaLongAddrs) == 0 {
 		return false, nil, nil, ErrUnknown
 	}
@@ -181,7 +181,7 @@ func BatCheckOTAExist(statedb StateDB, otaLongAddrs [][]byte) (exist bool, balan
 	return true, balance, nil, nil
 }
 
-func GetUnspendOTATotalBalance(statedb StateDB) (*big.Int, error) {
+func GetUnspendOTATotalBalance(statedb StateReader) (*big.Int, error) {
 	if statedb == nil {
 		return nil, ErrUnknown
 	}
@@ -219,6 +219,32 @@ func GetUnspendOTATotalBalance(statedb StateDB) (*big.Int, error) {
 	return totalOTABalance.Sub(totalOTABalance, totalSpendedOTABalance), nil
 }
 
+// OTAStorageAddresses returns the accounts whose byte-array storage holds the
+// OTA state. Their storage is iterated by key, so syncing it needs the
+// preimages of the storage trie keys.
+func OTAStorageAddresses() []common.Address {
+	addrs := []common.Address{otaBalanceStorageAddr, otaImageStorageAddr}
+	for _, set := range []map[string]string{StampValueSet, combCoinValueSet} {
+		for key := range set {
+			if value, ok := new(big.Int).SetString(key, 16); ok {
+				addrs = append(addrs, OTABalance2ContractAddr(value))
+			}
+		}
+	}
+	return addrs
+}
+
+// IsOTADenomination reports whether an OTA note can hold the value, being
+// a combcoin or stamp denomination.
+func IsOTADenomination(value *big.Int) bool {
+	if value == nil {
+		return false
+	}
+	_, coin := combCoinValueSet[value.Text(16)]
+	_, stamp := StampValueSet[value.Text(16)]
+	return coin || stamp
+}
+
 // setOTA storage ota info, include balance and combAddr. Overwrite if ota exist already.
 func setOTA(statedb StateDB, balance *big.Int, otacombAddr []byte) error {
 	if statedb == nil || balance == nil {
@@ -272,7 +298,7 @@ func AddOTAIfNotExist(statedb StateDB, balance *big.Int, otacombAddr []byte) (bo
 }
 
 // GetOTAInfoFromAX retrieve ota info, include balance and combAddr
-func GetOTAInfoFromAX(statedb StateDB, otaAX []byte) (otacombAddr []byte, balance *big.Int, err error) {
+func GetOTAInfoFromAX(statedb StateReader, otaAX []byte) (otacombAddr []byte, balance *big.Int, err error) {
 	if statedb == nil {
 		return nil, nil, ErrUnknown
 	}
@@ -373,7 +399,7 @@ func doOTAStorageTravelCallBack(env *GetOTASetEnv, value []byte) (bool, error) {
 // 		   If loopTimes%rnd == 0, collect current exist ota to result set and update the rnd.
 //		   Loop checking exist ota and loop traveling ota mpt, untile collect enough ota or find error.
 //
-func GetOTASet(statedb StateDB, otaAX []byte, setNum int) (otacombAddrs [][]byte, balance *big.Int, err error) {
+func GetOTASet(statedb StateReader, otaAX []byte, setNum int) (otacombAddrs [][]byte, balance *big.Int, err error) {
 	if statedb == nil {
 		return nil, nil, ErrUnknown
 	}
@@ -431,7 +457,7 @@ func GetOTASet(statedb StateDB, otaAX []byte, setNum int) (otacombAddrs [][]byte
 }
 
 // CheckOTAImageExist checks ota image key exist already or not
-func CheckOTAImageExist(statedb StateDB, otaImage []byte) (bool, []byte, error) {
+func CheckOTAImageExist(statedb StateReader, otaImage []byte) (bool, []byte, error) {
 	if statedb == nil || len(otaImage) == 0 {
 		return false, nil, errors.New("invalid input param!")
 	}
@@ -26,6 +26,7 @@ var (
 
 	otaBalanceStorageAddr = common.BytesToAddress(big.NewInt(300).Bytes())
 	otaImageStorageAddr   = common.BytesToAddress(big.NewInt(301).Bytes())
+	multiSigStorageAddr   = common.BytesToAddress(big.NewInt(302).Bytes())
 
 	// 0.01comb --> "0x0000000000000000000000010000000000000000"
 	otaBalancePercentdot001WStorageAddr = common.HexToAddress(combStampdot001)
@@ -60,7 +61,7 @@ var (
 type PrecompiledContract interface {
 	RequiredGas(input []byte) uint64                                // RequiredPrice calculates the contract gas use
 	Run(input []byte, contract *Contract, evm *EVM) ([]byte, error) // Run runs the precompiled contract
-	ValidTx(stateDB StateDB, signer types.Signer, tx *types.Transaction) error
+	ValidTx(stateDB StateReader, signer types.Signer, tx *types.Transaction) error
 }
 
 // PrecompiledContractsHomestead contains the default set of pre-compiled Ethereum